@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const redactedValue = "[REDACTED]"
+
+var (
+	defaultAuditRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	defaultAuditRedactFields  = []string{"password", "token", "access_token", "refresh_token", "secret", "api_key"}
+)
+
+type auditConfig struct {
+	enabled          bool
+	sampleRate       float64
+	alwaysLogFailure bool
+	bodyPreviewBytes int
+	headers          []string
+	redactHeaders    []string
+	redactFields     []string
+}
+
+type auditEntry struct {
+	method  string
+	url     string
+	headers http.Header
+	body    []byte
+	status  int
+	latency time.Duration
+	attempt int
+	err     error
+}
+
+// auditLogger writes sampled, redacted request/response records through the sink logger.
+type auditLogger struct {
+	logger        *zap.SugaredLogger
+	cfg           auditConfig
+	headers       map[string]bool
+	redactHeaders map[string]bool
+	redactFields  map[string]bool
+	redactPaths   [][]string
+	sample        func() float64
+}
+
+func newAuditLogger(logger *zap.SugaredLogger, cfg auditConfig) *auditLogger {
+	al := &auditLogger{
+		logger:        logger,
+		cfg:           cfg,
+		headers:       make(map[string]bool),
+		redactHeaders: make(map[string]bool),
+		redactFields:  make(map[string]bool),
+		sample:        rand.Float64,
+	}
+	for _, h := range cfg.headers {
+		if h = strings.TrimSpace(h); h != "" {
+			al.headers[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+	for _, h := range cfg.redactHeaders {
+		if h = strings.TrimSpace(h); h != "" {
+			al.redactHeaders[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+	// A field containing a dot is a path from the JSON root, otherwise it matches the key at any depth.
+	for _, f := range cfg.redactFields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if strings.Contains(f, ".") {
+			al.redactPaths = append(al.redactPaths, strings.Split(f, "."))
+		} else {
+			al.redactFields[strings.ToLower(f)] = true
+		}
+	}
+	return al
+}
+
+func (al *auditLogger) shouldLog(failed bool) bool {
+	if al == nil || !al.cfg.enabled {
+		return false
+	}
+	if failed && al.cfg.alwaysLogFailure {
+		return true
+	}
+	if al.cfg.sampleRate >= 1 {
+		return true
+	}
+	if al.cfg.sampleRate <= 0 {
+		return false
+	}
+	return al.sample() < al.cfg.sampleRate
+}
+
+func (al *auditLogger) log(entry auditEntry) {
+	failed := entry.err != nil || entry.status >= http.StatusBadRequest
+	if !al.shouldLog(failed) {
+		return
+	}
+	fields := []interface{}{
+		"method", entry.method,
+		"url", al.redactURL(entry.url),
+		"headers", al.selectHeaders(entry.headers),
+		"bodyPreview", al.bodyPreview(entry.body),
+		"bodySize", len(entry.body),
+		"status", entry.status,
+		"latencyMs", entry.latency.Milliseconds(),
+		"attempt", entry.attempt,
+	}
+	if entry.err != nil {
+		fields = append(fields, "error", entry.err.Error())
+	}
+	if failed {
+		al.logger.Warnw("HTTP request audit", fields...)
+		return
+	}
+	al.logger.Infow("HTTP request audit", fields...)
+}
+
+func (al *auditLogger) redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	changed := false
+	for key := range query {
+		if al.redactFields[strings.ToLower(key)] {
+			query.Set(key, redactedValue)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+func (al *auditLogger) selectHeaders(headers http.Header) map[string]string {
+	selected := make(map[string]string)
+	for key, values := range headers {
+		key = http.CanonicalHeaderKey(key)
+		if !al.headers[key] {
+			continue
+		}
+		if al.redactHeaders[key] {
+			selected[key] = redactedValue
+			continue
+		}
+		selected[key] = strings.Join(values, ",")
+	}
+	return selected
+}
+
+func (al *auditLogger) bodyPreview(body []byte) string {
+	if len(body) == 0 || al.cfg.bodyPreviewBytes <= 0 {
+		return ""
+	}
+	preview := body
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err == nil {
+		doc = al.redactJSON(doc, nil)
+		if redacted, err := json.Marshal(doc); err == nil {
+			preview = redacted
+		}
+	}
+	if len(preview) > al.cfg.bodyPreviewBytes {
+		return string(preview[:al.cfg.bodyPreviewBytes]) + "..."
+	}
+	return string(preview)
+}
+
+func (al *auditLogger) redactJSON(value interface{}, path []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := append(append([]string{}, path...), key)
+			if al.redactFields[strings.ToLower(key)] || al.matchesPath(childPath) {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = al.redactJSON(child, childPath)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = al.redactJSON(child, path)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func (al *auditLogger) matchesPath(path []string) bool {
+	for _, redactPath := range al.redactPaths {
+		if len(redactPath) != len(path) {
+			continue
+		}
+		matched := true
+		for i := range path {
+			if !strings.EqualFold(redactPath[i], path[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
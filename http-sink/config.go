@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	flag2 "github.com/numaproj/numaflow-sinks/shared/flag"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// sinkConfig covers every httpSink setting. It is read from the -config file, and
+// each field tagged with a flag name is overridden by that flag when it is set.
+type sinkConfig struct {
+	URL         string         `yaml:"url" flag:"url"`
+	Method      string         `yaml:"method" flag:"method"`
+	Retries     int            `yaml:"retries" flag:"retries"`
+	Timeout     int            `yaml:"timeout" flag:"timeout"`
+	Insecure    bool           `yaml:"insecure" flag:"insecure"`
+	DropIfError bool           `yaml:"dropIfError" flag:"dropIfError"`
+	Headers     arrayFlags     `yaml:"headers" flag:"headers"`
+	Metrics     metricsConfig  `yaml:"metrics"`
+	Audit       auditSettings  `yaml:"audit"`
+	Health      healthConfig   `yaml:"health"`
+	Preset      presetSettings `yaml:"preset"`
+	Response    responseConfig `yaml:"response"`
+}
+
+type metricsConfig struct {
+	Port   int           `yaml:"port" flag:"udsinkMetricsPort"`
+	Labels flag2.MapFlag `yaml:"labels" flag:"udsinkMetricsLabels"`
+}
+
+type auditSettings struct {
+	Enabled       bool           `yaml:"enabled" flag:"auditLog"`
+	SampleRate    float64        `yaml:"sampleRate" flag:"auditSampleRate"`
+	LogFailures   bool           `yaml:"logFailures" flag:"auditLogFailures"`
+	BodyPreview   int            `yaml:"bodyPreview" flag:"auditBodyPreview"`
+	Headers       flag2.ListFlag `yaml:"headers" flag:"auditHeaders"`
+	RedactHeaders flag2.ListFlag `yaml:"redactHeaders" flag:"auditRedactHeaders"`
+	RedactFields  flag2.ListFlag `yaml:"redactFields" flag:"auditRedactFields"`
+}
+
+type healthConfig struct {
+	URL             string  `yaml:"url" flag:"healthURL"`
+	ProbeInterval   int     `yaml:"probeInterval" flag:"healthProbeInterval"`
+	ReadinessWindow int     `yaml:"readinessWindow" flag:"readinessWindow"`
+	MinSuccessRate  float64 `yaml:"minSuccessRate" flag:"readinessMinSuccessRate"`
+	MinSamples      int     `yaml:"minSamples" flag:"readinessMinSamples"`
+}
+
+type presetSettings struct {
+	Name          string         `yaml:"name" flag:"preset"`
+	Fields        flag2.MapFlag  `yaml:"fields" flag:"presetFields"`
+	RoutingKey    string         `yaml:"pagerdutyRoutingKey" flag:"pagerdutyRoutingKey"`
+	ResolveValues flag2.ListFlag `yaml:"resolveValues" flag:"presetResolveValues"`
+}
+
+type responseConfig struct {
+	File           string `yaml:"file" flag:"responseFile"`
+	FileMaxSize    int    `yaml:"fileMaxSize" flag:"responseFileMaxSize"`
+	FileMaxBackups int    `yaml:"fileMaxBackups" flag:"responseFileMaxBackups"`
+	URL            string `yaml:"url" flag:"responseURL"`
+	MaxBytes       int64  `yaml:"maxBytes" flag:"responseMaxBytes"`
+}
+
+func defaultConfig() sinkConfig {
+	return sinkConfig{
+		Retries: 3,
+		Timeout: 30,
+		Metrics: metricsConfig{Port: 9090, Labels: flag2.MapFlag{}},
+		Audit:   auditSettings{SampleRate: 1, LogFailures: true, BodyPreview: 256},
+		Health: healthConfig{
+			ProbeInterval:   30,
+			ReadinessWindow: 60,
+			MinSuccessRate:  0.5,
+			MinSamples:      5,
+		},
+		Preset:   presetSettings{Fields: flag2.MapFlag{}},
+		Response: responseConfig{FileMaxSize: 100, FileMaxBackups: 3, MaxBytes: 64 * 1024},
+	}
+}
+
+// registerFlags binds the flags to the config fields, using the current values as defaults.
+func (c *sinkConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.URL, "url", c.URL, "URL")
+	fs.StringVar(&c.Method, "method", c.Method, "HTTP Method (default GET, POST with a preset)")
+	fs.IntVar(&c.Retries, "retries", c.Retries, "Request Retries")
+	fs.IntVar(&c.Timeout, "timeout", c.Timeout, "Request Timeout in seconds")
+	fs.BoolVar(&c.Insecure, "insecure", c.Insecure, "Skip TLS verify")
+	fs.BoolVar(&c.DropIfError, "dropIfError", c.DropIfError, "Messages will drop after retry")
+	fs.Var(&c.Headers, "headers", "HTTP Headers E.g: \"Content-Type: application/json\"")
+	fs.IntVar(&c.Metrics.Port, "udsinkMetricsPort", c.Metrics.Port, "UDSink Metrics Port")
+	fs.Var(&c.Metrics.Labels, "udsinkMetricsLabels", "UDSink Metrics Labels E.g: label=val1,label1=val2")
+	fs.BoolVar(&c.Audit.Enabled, "auditLog", c.Audit.Enabled, "Enable request/response audit logging")
+	fs.Float64Var(&c.Audit.SampleRate, "auditSampleRate", c.Audit.SampleRate, "Fraction of requests to audit, between 0 and 1")
+	fs.BoolVar(&c.Audit.LogFailures, "auditLogFailures", c.Audit.LogFailures, "Always audit failed requests regardless of sample rate")
+	fs.IntVar(&c.Audit.BodyPreview, "auditBodyPreview", c.Audit.BodyPreview, "Max number of body bytes in the audit log")
+	fs.Var(&c.Audit.Headers, "auditHeaders", "Request headers included in the audit log E.g: Content-Type,X-Request-Id")
+	fs.Var(&c.Audit.RedactHeaders, "auditRedactHeaders", "Headers redacted in the audit log (default Authorization,Proxy-Authorization,Cookie,Set-Cookie,X-Api-Key)")
+	fs.Var(&c.Audit.RedactFields, "auditRedactFields", "JSON fields or dotted paths redacted in the audit log (default password,token,access_token,refresh_token,secret,api_key)")
+	fs.StringVar(&c.Health.URL, "healthURL", c.Health.URL, "URL probed periodically to check downstream readiness")
+	fs.IntVar(&c.Health.ProbeInterval, "healthProbeInterval", c.Health.ProbeInterval, "Health probe interval in seconds")
+	fs.IntVar(&c.Health.ReadinessWindow, "readinessWindow", c.Health.ReadinessWindow, "Window in seconds for the downstream success rate")
+	fs.Float64Var(&c.Health.MinSuccessRate, "readinessMinSuccessRate", c.Health.MinSuccessRate, "Min downstream success rate for readiness")
+	fs.IntVar(&c.Health.MinSamples, "readinessMinSamples", c.Health.MinSamples, "Min requests in the window before the success rate affects readiness")
+	fs.StringVar(&c.Preset.Name, "preset", c.Preset.Name, "Notification preset, one of "+presetNames())
+	fs.Var(&c.Preset.Fields, "presetFields", "Preset field to JSON path mapping E.g: title=alert.name,dedupKey=alert.id")
+	fs.StringVar(&c.Preset.RoutingKey, "pagerdutyRoutingKey", c.Preset.RoutingKey, "PagerDuty Events v2 routing key")
+	fs.Var(&c.Preset.ResolveValues, "presetResolveValues", "Status values that resolve an alert (default resolved,ok)")
+	fs.StringVar(&c.Response.File, "responseFile", c.Response.File, "NDJSON file the responses are recorded to")
+	fs.IntVar(&c.Response.FileMaxSize, "responseFileMaxSize", c.Response.FileMaxSize, "Max size in MB of the response file before it is rotated")
+	fs.IntVar(&c.Response.FileMaxBackups, "responseFileMaxBackups", c.Response.FileMaxBackups, "Number of rotated response files to keep")
+	fs.StringVar(&c.Response.URL, "responseURL", c.Response.URL, "Collector URL the responses are posted to")
+	fs.Int64Var(&c.Response.MaxBytes, "responseMaxBytes", c.Response.MaxBytes, "Max number of response body bytes recorded")
+}
+
+// loadConfig reads a YAML or JSON config file on top of the defaults. Unknown fields are rejected.
+func loadConfig(path string) (*sinkConfig, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	cfg := defaultConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &cfg, data, nil
+}
+
+// overrideWithFlags copies into c the fields of src whose flag is set on the command line.
+func (c *sinkConfig) overrideWithFlags(src *sinkConfig, fs *flag.FlagSet) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	overrideFields(reflect.ValueOf(c).Elem(), reflect.ValueOf(src).Elem(), set)
+}
+
+func overrideFields(dst, src reflect.Value, set map[string]bool) {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		name, ok := field.Tag.Lookup("flag")
+		switch {
+		case ok && set[name]:
+			dst.Field(i).Set(src.Field(i))
+		case !ok && field.Type.Kind() == reflect.Struct:
+			overrideFields(dst.Field(i), src.Field(i), set)
+		}
+	}
+}
+
+// validate checks every setting and reports all problems at once.
+func (c *sinkConfig) validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if c.URL == "" {
+		addf("url is required")
+	} else if err := validateURL(c.URL); err != nil {
+		addf("url: %v", err)
+	}
+	switch c.Method {
+	case "", http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead, http.MethodOptions:
+	default:
+		addf("method: unsupported HTTP method %q", c.Method)
+	}
+	if c.Retries < 1 {
+		addf("retries: must be at least 1, got %d", c.Retries)
+	}
+	if c.Timeout < 1 {
+		addf("timeout: must be at least 1 second, got %d", c.Timeout)
+	}
+	if _, err := parseHeaders(c.Headers); err != nil {
+		addf("headers: %v", err)
+	}
+	if c.Metrics.Port < 1 || c.Metrics.Port > 65535 {
+		addf("metrics.port: must be between 1 and 65535, got %d", c.Metrics.Port)
+	}
+	if c.Audit.SampleRate < 0 || c.Audit.SampleRate > 1 {
+		addf("audit.sampleRate: must be between 0 and 1, got %v", c.Audit.SampleRate)
+	}
+	if c.Audit.BodyPreview < 0 {
+		addf("audit.bodyPreview: must not be negative, got %d", c.Audit.BodyPreview)
+	}
+	if c.Health.URL != "" {
+		if err := validateURL(c.Health.URL); err != nil {
+			addf("health.url: %v", err)
+		}
+		if c.Health.ProbeInterval < 1 {
+			addf("health.probeInterval: must be at least 1 second, got %d", c.Health.ProbeInterval)
+		}
+	}
+	if c.Health.ReadinessWindow < 1 {
+		addf("health.readinessWindow: must be at least 1 second, got %d", c.Health.ReadinessWindow)
+	}
+	if c.Health.MinSuccessRate < 0 || c.Health.MinSuccessRate > 1 {
+		addf("health.minSuccessRate: must be between 0 and 1, got %v", c.Health.MinSuccessRate)
+	}
+	if c.Preset.Name != "" {
+		if _, err := newPreset(c.presetConfig()); err != nil {
+			addf("preset: %v", err)
+		}
+	}
+	if c.Response.URL != "" {
+		if err := validateURL(c.Response.URL); err != nil {
+			addf("response.url: %v", err)
+		}
+	}
+	if c.Response.File != "" || c.Response.URL != "" {
+		if c.Response.MaxBytes < 1 {
+			addf("response.maxBytes: must be at least 1, got %d", c.Response.MaxBytes)
+		}
+		if c.Response.FileMaxSize < 1 {
+			addf("response.fileMaxSize: must be at least 1 MB, got %d", c.Response.FileMaxSize)
+		}
+		if c.Response.FileMaxBackups < 0 {
+			addf("response.fileMaxBackups: must not be negative, got %d", c.Response.FileMaxBackups)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https in %q", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("host is missing in %q", rawURL)
+	}
+	return nil
+}
+
+// parseHeaders parses headers given as "Key: Value".
+func parseHeaders(list []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, header := range list {
+		key, value, found := strings.Cut(header, ":")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected \"Key: Value\"", header)
+		}
+		headers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+func (c *sinkConfig) method() string {
+	if c.Method != "" {
+		return c.Method
+	}
+	if c.Preset.Name != "" {
+		return http.MethodPost
+	}
+	return http.MethodGet
+}
+
+func (c *sinkConfig) auditConfig() auditConfig {
+	cfg := auditConfig{
+		enabled:          c.Audit.Enabled,
+		sampleRate:       c.Audit.SampleRate,
+		alwaysLogFailure: c.Audit.LogFailures,
+		bodyPreviewBytes: c.Audit.BodyPreview,
+		headers:          c.Audit.Headers,
+		redactHeaders:    c.Audit.RedactHeaders,
+		redactFields:     c.Audit.RedactFields,
+	}
+	if len(cfg.redactHeaders) == 0 {
+		cfg.redactHeaders = defaultAuditRedactHeaders
+	}
+	if len(cfg.redactFields) == 0 {
+		cfg.redactFields = defaultAuditRedactFields
+	}
+	return cfg
+}
+
+func (c *sinkConfig) presetConfig() presetConfig {
+	return presetConfig{
+		name:          c.Preset.Name,
+		fields:        c.Preset.Fields,
+		routingKey:    c.Preset.RoutingKey,
+		resolveValues: c.Preset.ResolveValues,
+	}
+}
+
+// dynamicConfig builds the settings that are safe to swap while datums are in flight.
+func (c *sinkConfig) dynamicConfig(logger *zap.SugaredLogger) (dynamicConfig, error) {
+	headers, err := parseHeaders(c.Headers)
+	if err != nil {
+		return dynamicConfig{}, err
+	}
+	dc := dynamicConfig{
+		headers: headers,
+		audit:   newAuditLogger(logger.Named("audit"), c.auditConfig()),
+	}
+	if c.Preset.Name != "" {
+		if dc.preset, err = newPreset(c.presetConfig()); err != nil {
+			return dynamicConfig{}, err
+		}
+	}
+	return dc, nil
+}
+
+// restartRequired lists the settings that differ from c but are only applied at startup.
+func (c *sinkConfig) restartRequired(other *sinkConfig) []string {
+	var fields []string
+	for name, changed := range map[string]bool{
+		"url":         c.URL != other.URL,
+		"method":      c.method() != other.method(),
+		"retries":     c.Retries != other.Retries,
+		"timeout":     c.Timeout != other.Timeout,
+		"insecure":    c.Insecure != other.Insecure,
+		"dropIfError": c.DropIfError != other.DropIfError,
+		"metrics":     !reflect.DeepEqual(c.Metrics, other.Metrics),
+		"health":      c.Health != other.Health,
+		"response":    c.Response != other.Response,
+	} {
+		if changed {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// withDynamic returns a copy of c with the hot reloaded settings of other. The restart-only settings stay the
+// applied ones, so a change to them keeps being reported until the sink restarts.
+func (c *sinkConfig) withDynamic(other *sinkConfig) *sinkConfig {
+	applied := *c
+	// The default method depends on the preset, keep the one in use
+	applied.Method = c.method()
+	applied.Headers = other.Headers
+	applied.Audit = other.Audit
+	applied.Preset = other.Preset
+	return &applied
+}
+
+// configWatcher polls the config file and hot reloads the safe settings when its content changes.
+// Mounted ConfigMaps are updated through a symlink swap, so the content is compared rather than the mtime.
+type configWatcher struct {
+	logger   *zap.SugaredLogger
+	path     string
+	interval time.Duration
+	flags    *flag.FlagSet
+	flagCfg  *sinkConfig
+	current  *sinkConfig
+	checksum [sha256.Size]byte
+	apply    func(dynamicConfig)
+}
+
+func (w *configWatcher) reload() {
+	cfg, data, err := loadConfig(w.path)
+	if err != nil {
+		w.logger.Errorf("Failed to reload config, keeping the current one. %v", err)
+		return
+	}
+	checksum := sha256.Sum256(data)
+	if checksum == w.checksum {
+		return
+	}
+	w.checksum = checksum
+	cfg.overrideWithFlags(w.flagCfg, w.flags)
+	if err := cfg.validate(); err != nil {
+		w.logger.Errorf("Failed to reload config, keeping the current one. %v", err)
+		return
+	}
+	dc, err := cfg.dynamicConfig(w.logger)
+	if err != nil {
+		w.logger.Errorf("Failed to reload config, keeping the current one. %v", err)
+		return
+	}
+	if fields := w.current.restartRequired(cfg); len(fields) > 0 {
+		w.logger.Warnf("Config changes to %v require a restart and are ignored", fields)
+	}
+	w.apply(dc)
+	w.current = w.current.withDynamic(cfg)
+	w.logger.Info("Config reloaded")
+}
+
+func (w *configWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.reload()
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+url: https://example.com/hook
+retries: 5
+headers:
+  - "Authorization: Bearer token"
+  - "X-Team: sre"
+metrics:
+  port: 9100
+  labels:
+    pipeline: alerts
+audit:
+  enabled: true
+  sampleRate: 0.2
+preset:
+  name: slack
+  fields:
+    title: alert.name
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, _, err := loadConfig(writeConfig(t, testConfig))
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.validate())
+	assert.Equal(t, "https://example.com/hook", cfg.URL)
+	assert.Equal(t, 5, cfg.Retries)
+	assert.Equal(t, 30, cfg.Timeout)
+	assert.Equal(t, http.MethodPost, cfg.method())
+	assert.Equal(t, arrayFlags{"Authorization: Bearer token", "X-Team: sre"}, cfg.Headers)
+	assert.Equal(t, 9100, cfg.Metrics.Port)
+	assert.Equal(t, "alerts", cfg.Metrics.Labels["pipeline"])
+	assert.Equal(t, 0.2, cfg.Audit.SampleRate)
+	assert.True(t, cfg.Audit.LogFailures)
+	assert.Equal(t, "alert.name", cfg.Preset.Fields["title"])
+
+	cfg, _, err = loadConfig(writeConfig(t, `{"url": "http://example.com", "method": "PUT", "timeout": 5}`))
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.validate())
+	assert.Equal(t, http.MethodPut, cfg.method())
+	assert.Equal(t, 5, cfg.Timeout)
+
+	_, _, err = loadConfig(writeConfig(t, "url: http://example.com\nretry: 3\n"))
+	assert.ErrorContains(t, err, "field retry not found")
+
+	_, _, err = loadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read config file")
+}
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Method = "FETCH"
+	cfg.Retries = 0
+	cfg.Headers = arrayFlags{"no-colon"}
+	cfg.Audit.SampleRate = 2
+	cfg.Health.URL = "ftp://example.com"
+	cfg.Preset.Name = presetPagerDuty
+	err := cfg.validate()
+	assert.Error(t, err)
+	for _, msg := range []string{
+		"url is required",
+		`method: unsupported HTTP method "FETCH"`,
+		"retries: must be at least 1, got 0",
+		`headers: invalid header "no-colon"`,
+		"audit.sampleRate: must be between 0 and 1, got 2",
+		"health.url: scheme must be http or https",
+		"preset: pagerduty preset requires a routing key",
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+}
+
+func TestConfig_FlagOverrides(t *testing.T) {
+	flagCfg := defaultConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagCfg.registerFlags(fs)
+	assert.NoError(t, fs.Parse([]string{"-retries", "7", "-headers", "X-Flag: 1", "-auditSampleRate", "0.5"}))
+
+	cfg, _, err := loadConfig(writeConfig(t, testConfig))
+	assert.NoError(t, err)
+	cfg.overrideWithFlags(&flagCfg, fs)
+	assert.Equal(t, 7, cfg.Retries)
+	assert.Equal(t, arrayFlags{"X-Flag: 1"}, cfg.Headers)
+	assert.Equal(t, 0.5, cfg.Audit.SampleRate)
+	// Values not set by a flag come from the file
+	assert.Equal(t, "https://example.com/hook", cfg.URL)
+	assert.True(t, cfg.Audit.Enabled)
+	assert.Equal(t, 9100, cfg.Metrics.Port)
+}
+
+func TestConfigWatcher_Reload(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	cfg, data, err := loadConfig(path)
+	assert.NoError(t, err)
+	flagCfg := defaultConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagCfg.registerFlags(fs)
+	assert.NoError(t, fs.Parse([]string{"-retries", "7"}))
+	cfg.overrideWithFlags(&flagCfg, fs)
+
+	hs := &httpSink{logger: logging.NewLogger().Named("http-sink")}
+	dc, err := cfg.dynamicConfig(hs.logger)
+	assert.NoError(t, err)
+	hs.updateConfig(dc)
+	w := &configWatcher{logger: hs.logger, path: path, flags: fs, flagCfg: &flagCfg, current: cfg, checksum: sha256.Sum256(data), apply: hs.updateConfig}
+
+	// An unchanged file is not applied again
+	hs.updateConfig(dynamicConfig{})
+	w.reload()
+	assert.Nil(t, hs.currentConfig().headers)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`
+url: https://example.com/hook
+headers:
+  - "X-Team: platform"
+preset:
+  name: teams
+`), 0644))
+	w.reload()
+	assert.Equal(t, "platform", hs.currentConfig().headers.Get("X-Team"))
+	assert.Empty(t, hs.currentConfig().headers.Get("Authorization"))
+	assert.IsType(t, &teamsPreset{}, hs.currentConfig().preset)
+	assert.Equal(t, 7, w.current.Retries)
+	// Restart-only settings keep the applied values, so the change is reported again on the next reload
+	assert.Equal(t, 9100, w.current.Metrics.Port)
+	assert.Equal(t, http.MethodPost, w.current.method())
+	reloaded, _, err := loadConfig(path)
+	assert.NoError(t, err)
+	reloaded.overrideWithFlags(&flagCfg, fs)
+	assert.Equal(t, []string{"metrics"}, w.current.restartRequired(reloaded))
+
+	// An invalid file keeps the current settings
+	assert.NoError(t, os.WriteFile(path, []byte("url: https://example.com/hook\nheaders: [\"broken\"]\n"), 0644))
+	w.reload()
+	assert.Equal(t, "platform", hs.currentConfig().headers.Get("X-Team"))
+
+	next := *w.current
+	next.URL = "https://example.com/other"
+	next.Timeout = 10
+	assert.Equal(t, []string{"timeout", "url"}, w.current.restartRequired(&next))
+}
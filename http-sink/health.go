@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// healthBucket counts the downstream requests of one second.
+type healthBucket struct {
+	second int64
+	total  int
+	ok     int
+}
+
+// healthChecker tracks downstream health for the readiness endpoint.
+type healthChecker struct {
+	logger         *zap.SugaredLogger
+	minSuccessRate float64
+	minSamples     int
+	probeURL       string
+	probeInterval  time.Duration
+
+	mu sync.Mutex
+	// buckets is a ring of one bucket per second of the window, so memory does not grow with throughput
+	buckets     []healthBucket
+	clientReady bool
+	probeOK     bool
+	probeErr    error
+	now         func() time.Time
+}
+
+func newHealthChecker(logger *zap.SugaredLogger, window time.Duration, minSuccessRate float64, minSamples int) *healthChecker {
+	seconds := int(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &healthChecker{
+		logger:         logger,
+		buckets:        make([]healthBucket, seconds),
+		minSuccessRate: minSuccessRate,
+		minSamples:     minSamples,
+		now:            time.Now,
+	}
+}
+
+func (hc *healthChecker) markClientReady() {
+	if hc == nil {
+		return
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.clientReady = true
+}
+
+// record adds the outcome of a downstream request to the sliding window.
+func (hc *healthChecker) record(ok bool) {
+	if hc == nil {
+		return
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	now := hc.now().Unix()
+	bucket := &hc.buckets[now%int64(len(hc.buckets))]
+	if bucket.second != now {
+		*bucket = healthBucket{second: now}
+	}
+	bucket.total++
+	if ok {
+		bucket.ok++
+	}
+}
+
+func (hc *healthChecker) successRate() (float64, int) {
+	now := hc.now().Unix()
+	total, success := 0, 0
+	for _, bucket := range hc.buckets {
+		if bucket.second > now-int64(len(hc.buckets)) && bucket.second <= now {
+			total += bucket.total
+			success += bucket.ok
+		}
+	}
+	if total == 0 {
+		return 1, 0
+	}
+	return float64(success) / float64(total), total
+}
+
+// healthyStatus reports whether a response status counts as a downstream success. A 4xx is usually a misconfigured
+// destination rejecting every datum, only 429 is left out as the destination is reachable and throttling.
+func healthyStatus(status int) bool {
+	return status < http.StatusBadRequest || status == http.StatusTooManyRequests
+}
+
+// ready returns nil when the sink is ready to receive traffic, otherwise the reason it is not.
+func (hc *healthChecker) ready() error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if !hc.clientReady {
+		return fmt.Errorf("HTTP client is not initialized")
+	}
+	if hc.probeURL != "" && !hc.probeOK {
+		return fmt.Errorf("health probe failed: %v", hc.probeErr)
+	}
+	rate, samples := hc.successRate()
+	if samples >= hc.minSamples && rate < hc.minSuccessRate {
+		return fmt.Errorf("downstream success rate %.2f is below %.2f over %d requests", rate, hc.minSuccessRate, samples)
+	}
+	return nil
+}
+
+func (hc *healthChecker) probe(client *http.Client) {
+	req, err := http.NewRequest(http.MethodGet, hc.probeURL, nil)
+	if err == nil {
+		var res *http.Response
+		res, err = client.Do(req)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode >= http.StatusBadRequest {
+				err = fmt.Errorf("unexpected status code %d", res.StatusCode)
+			}
+		}
+	}
+	if err != nil {
+		hc.logger.Warnf("Health probe to %s failed. %v", hc.probeURL, err)
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.probeOK = err == nil
+	hc.probeErr = err
+}
+
+// startProbe periodically sends a request to the health URL until the context is cancelled.
+func (hc *healthChecker) startProbe(ctx context.Context, client *http.Client) {
+	if hc.probeURL == "" {
+		return
+	}
+	ticker := time.NewTicker(hc.probeInterval)
+	defer ticker.Stop()
+	for {
+		hc.probe(client)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc *healthChecker) livenessHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (hc *healthChecker) readinessHandler(w http.ResponseWriter, _ *http.Request) {
+	if err := hc.ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecker_Readiness(t *testing.T) {
+	hc := newHealthChecker(logging.NewLogger().Named("health"), time.Minute, 0.5, 4)
+	now := time.Now()
+	hc.now = func() time.Time { return now }
+
+	rec := httptest.NewRecorder()
+	hc.readinessHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "not initialized")
+
+	hc.markClientReady()
+	assert.NoError(t, hc.ready())
+
+	// Not enough samples yet to judge the success rate
+	hc.record(false)
+	hc.record(false)
+	hc.record(false)
+	assert.NoError(t, hc.ready())
+	hc.record(true)
+	assert.ErrorContains(t, hc.ready(), "success rate 0.25")
+
+	// Failures outside the window are forgotten
+	now = now.Add(2 * time.Minute)
+	hc.record(true)
+	assert.NoError(t, hc.ready())
+
+	// The window is kept in one bucket per second
+	for i := 0; i < 1000; i++ {
+		now = now.Add(100 * time.Millisecond)
+		hc.record(true)
+	}
+	assert.Len(t, hc.buckets, 60)
+	rate, samples := hc.successRate()
+	assert.Equal(t, float64(1), rate)
+	assert.InDelta(t, 600, samples, 10)
+
+	rec = httptest.NewRecorder()
+	hc.livenessHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestHealthChecker_Probe(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	hc := newHealthChecker(logging.NewLogger().Named("health"), time.Minute, 0.5, 1)
+	hc.probeURL = server.URL
+	hc.probeInterval = time.Hour
+	hc.markClientReady()
+	assert.ErrorContains(t, hc.ready(), "health probe failed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hc.startProbe(ctx, server.Client())
+	assert.NoError(t, hc.ready())
+
+	status = http.StatusServiceUnavailable
+	hc.probe(server.Client())
+	assert.ErrorContains(t, hc.ready(), "unexpected status code 503")
+}
+
+func TestHttpSink_RecordsHealth(t *testing.T) {
+	tests := []struct {
+		status int
+		ready  bool
+	}{
+		{status: http.StatusNoContent, ready: true},
+		{status: http.StatusTooManyRequests, ready: true},
+		{status: http.StatusUnauthorized, ready: false},
+		{status: http.StatusNotFound, ready: false},
+		{status: http.StatusInternalServerError, ready: false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			hs := httpSink{url: server.URL, method: http.MethodPost, logger: logging.NewLogger().Named("http-sink")}
+			hs.health = newHealthChecker(hs.logger, time.Minute, 0.5, 1)
+			hs.createHTTPClient()
+			assert.NoError(t, hs.health.ready())
+			_, err := hs.sendHTTPRequest([]byte("{}"), 1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ready, hs.health.ready() == nil)
+		})
+	}
+}
@@ -3,16 +3,17 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"errors"
 	"flag"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	sinksdk "github.com/numaproj/numaflow-go/pkg/sink"
 	"github.com/numaproj/numaflow-go/pkg/sink/server"
-	flag2 "github.com/numaproj/numaflow-sinks/shared/flag"
 	"github.com/numaproj/numaflow/pkg/shared/logging"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -28,8 +29,18 @@ type httpSink struct {
 	windowing    int
 	skipInsecure bool
 	dropIfError  bool
-	headers      arrayFlags
 	metrics      *MetricsPublisher
+	health       *healthChecker
+	responses    *responseForwarder
+	mu           sync.RWMutex
+	dynamic      dynamicConfig
+}
+
+// dynamicConfig holds the settings that are hot reloaded from the config file.
+type dynamicConfig struct {
+	headers http.Header
+	preset  preset
+	audit   *auditLogger
 }
 type arrayFlags []string
 
@@ -42,6 +53,18 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
+func (hs *httpSink) currentConfig() dynamicConfig {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return hs.dynamic
+}
+
+func (hs *httpSink) updateConfig(dc dynamicConfig) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.dynamic = dc
+}
+
 func (hs *httpSink) createHTTPClient() {
 	//creating http client
 	client := &http.Client{Timeout: time.Duration(hs.timeout) * time.Second}
@@ -52,27 +75,55 @@ func (hs *httpSink) createHTTPClient() {
 		client.Transport = tr
 	}
 	hs.httpClient = client
+	hs.health.markClientReady()
 }
 
-func (hs *httpSink) sendHTTPRequest(data io.Reader) error {
-	req, err := http.NewRequest(hs.method, hs.url, data)
+func (hs *httpSink) sendHTTPRequest(data []byte, attempt int) (*httpResponse, error) {
+	req, err := http.NewRequest(hs.method, hs.url, bytes.NewReader(data))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	dc := hs.currentConfig()
+	for key, values := range dc.headers {
+		req.Header[key] = values
+	}
+	if dc.preset != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
 	}
 	if hs.httpClient == nil {
-		return errors.New("HTTP Client is not initialized")
+		return nil, errors.New("HTTP Client is not initialized")
 	}
+	entry := auditEntry{method: req.Method, url: hs.url, headers: req.Header, body: data, attempt: attempt}
+	start := time.Now()
 	res, err := hs.httpClient.Do(req)
+	entry.latency = time.Since(start)
 	if err != nil {
-		return err
+		entry.err = err
+		dc.audit.log(entry)
+		hs.health.record(false)
+		return nil, err
 	}
-	if res != nil {
-		if res.Body != nil {
-			res.Body.Close()
+	response := &httpResponse{status: res.StatusCode}
+	if res.Body != nil {
+		// Only the response forwarder needs the body, read no more than it keeps
+		if hs.responses != nil {
+			body, err := io.ReadAll(io.LimitReader(res.Body, hs.responses.maxBytes+1))
+			if err != nil {
+				hs.logger.Warnf("Failed to read response body. %v", err)
+			}
+			if int64(len(body)) > hs.responses.maxBytes {
+				body = body[:hs.responses.maxBytes]
+				response.truncated = true
+			}
+			response.body = body
 		}
-		hs.logger.Infof("Response code: %d,", res.StatusCode)
+		res.Body.Close()
 	}
-	return nil
+	entry.status = res.StatusCode
+	hs.logger.Infof("Response code: %d,", res.StatusCode)
+	dc.audit.log(entry)
+	hs.health.record(healthyStatus(entry.status))
+	return response, nil
 }
 
 func (hs *httpSink) handle(ctx context.Context, datumStreamCh <-chan sinksdk.Datum) sinksdk.Responses {
@@ -82,16 +133,31 @@ func (hs *httpSink) handle(ctx context.Context, datumStreamCh <-chan sinksdk.Dat
 		hs.metrics.IncreaseTotalCounter()
 		hs.metrics.UpdateSize(float64(len(datum.Value())))
 		//TODO Need to implemente parallel sending request
-		data := bytes.NewReader(datum.Value())
+		data := datum.Value()
+		if p := hs.currentConfig().preset; p != nil {
+			var err error
+			data, err = p.render(data)
+			if err != nil {
+				hs.logger.Errorf("Failed to render preset payload. Error : %v", err)
+				hs.metrics.IncreaseTotalFailed()
+				failed = failed.Append(sinksdk.ResponseFailure(datum.ID(), "failed to render preset payload"))
+				continue
+			}
+		}
+		attempt := 0
 		backoff := wait.Backoff{
 			Steps:    hs.retries,
 			Duration: 10 * time.Second,
 			Factor:   2,
 		}
 		retryError := wait.ExponentialBackoffWithContext(ctx, backoff, func() (done bool, err error) {
+			attempt++
 			start := time.Now()
-			err = hs.sendHTTPRequest(data)
+			res, err := hs.sendHTTPRequest(data, attempt)
 			hs.metrics.UpdateLatency(float64(time.Since(start).Milliseconds()))
+			if res != nil && hs.responses != nil {
+				hs.responses.forward(newResponseRecord(datum.ID(), datum.EventTime(), hs.method, hs.url, attempt, res))
+			}
 			if err != nil {
 				hs.logger.Errorf("HTTP Request failed. %v", err)
 				return false, nil
@@ -120,27 +186,82 @@ func (hs *httpSink) handle(ctx context.Context, datumStreamCh <-chan sinksdk.Dat
 }
 
 func main() {
-	var metricPort int
-	labels := flag2.MapFlag{}
 	logger := logging.NewLogger().Named("http-sink")
-	hs := httpSink{logger: logger}
-	flag.StringVar(&hs.url, "url", "", "URL")
-	flag.StringVar(&hs.method, "method", "GET", "HTTP Method")
-	flag.IntVar(&hs.retries, "retries", 3, "Request Retries")
-	flag.IntVar(&hs.timeout, "timeout", 30, "Request Timeout in seconds")
-	flag.BoolVar(&hs.skipInsecure, "insecure", false, "Skip TLS verify")
-	flag.BoolVar(&hs.dropIfError, "dropIfError", false, "Messages will drop after retry")
-	flag.Var(&hs.headers, "headers", "HTTP Headers")
-	flag.IntVar(&metricPort, "udsinkMetricsPort", 9090, "UDSink Metrics Port")
-	flag.Var(&labels, "udsinkMetricsLabels", "UDSink Metrics Labels E.g: label=val1,label1=val2")
+	var configFile string
+	var configReloadInterval int
+	cfg := defaultConfig()
+	flag.StringVar(&configFile, "config", "", "YAML or JSON config file, flags override its values")
+	flag.IntVar(&configReloadInterval, "configReloadInterval", 10, "Interval in seconds to check the config file for changes, 0 disables hot reload")
+	cfg.registerFlags(flag.CommandLine)
 	// Parse the flag
 	flag.Parse()
 
-	hs.metrics = NewMetricsServer(labels)
-	go hs.metrics.startMetricServer(metricPort)
-	hs.logger.Infof("Metrics publisher initialized with port=%d", metricPort)
+	flagCfg := cfg
+	var watcher *configWatcher
+	if configFile != "" {
+		fileCfg, data, err := loadConfig(configFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fileCfg.overrideWithFlags(&flagCfg, flag.CommandLine)
+		cfg = *fileCfg
+		watcher = &configWatcher{
+			logger:   logger,
+			path:     configFile,
+			interval: time.Duration(configReloadInterval) * time.Second,
+			flags:    flag.CommandLine,
+			flagCfg:  &flagCfg,
+			current:  fileCfg,
+			checksum: sha256.Sum256(data),
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		logger.Fatal(err)
+	}
+
+	hs := &httpSink{
+		logger:       logger,
+		url:          cfg.URL,
+		method:       cfg.method(),
+		retries:      cfg.Retries,
+		timeout:      cfg.Timeout,
+		skipInsecure: cfg.Insecure,
+		dropIfError:  cfg.DropIfError,
+	}
+	dc, err := cfg.dynamicConfig(logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	hs.updateConfig(dc)
+	if watcher != nil && configReloadInterval > 0 {
+		watcher.apply = hs.updateConfig
+		go watcher.run(context.Background())
+		hs.logger.Infof("Watching config file %s for changes", configFile)
+	}
+
+	hs.health = newHealthChecker(logger.Named("health"), time.Duration(cfg.Health.ReadinessWindow)*time.Second, cfg.Health.MinSuccessRate, cfg.Health.MinSamples)
+	hs.health.probeURL = cfg.Health.URL
+	hs.health.probeInterval = time.Duration(cfg.Health.ProbeInterval) * time.Second
+	hs.metrics = NewMetricsServer(cfg.Metrics.Labels)
+	go hs.metrics.startMetricServer(cfg.Metrics.Port, hs.health)
+	hs.logger.Infof("Metrics publisher initialized with port=%d", cfg.Metrics.Port)
 	//creating http client
 	hs.createHTTPClient()
-	hs.logger.Info("HTTP Sink starting successfully with args %v", hs)
+	if cfg.Response.File != "" || cfg.Response.URL != "" {
+		hs.responses = &responseForwarder{logger: logger.Named("response"), maxBytes: cfg.Response.MaxBytes}
+		if cfg.Response.File != "" {
+			w, err := newFileResponseWriter(cfg.Response.File, int64(cfg.Response.FileMaxSize)*1024*1024, cfg.Response.FileMaxBackups)
+			if err != nil {
+				hs.logger.Fatalf("Failed to open response file. Error : %v", err)
+			}
+			hs.responses.writers = append(hs.responses.writers, w)
+		}
+		if cfg.Response.URL != "" {
+			hs.responses.writers = append(hs.responses.writers, &httpResponseWriter{client: hs.httpClient, url: cfg.Response.URL})
+		}
+		defer hs.responses.close()
+	}
+	go hs.health.startProbe(context.Background(), hs.httpClient)
+	hs.logger.Infof("HTTP Sink starting successfully with url=%s method=%s", hs.url, hs.method)
 	server.New().RegisterSinker(sinksdk.SinkFunc(hs.handle)).Start(context.Background())
 }
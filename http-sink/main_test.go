@@ -9,18 +9,22 @@ import (
 )
 
 func TestHttp_client(t *testing.T) {
+	var header string
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Test")
 		w.WriteHeader(http.StatusNoContent)
 
 	}))
 	hs := httpSink{}
+	hs.dynamic.headers = http.Header{"X-Test": []string{"value"}}
 	hs.url = server.URL
 	hs.method = http.MethodPost
 	hs.logger = logging.NewLogger().Named("http-sink")
-	err := hs.sendHTTPRequest(nil)
+	_, err := hs.sendHTTPRequest(nil, 1)
 	assert.Error(t, err)
 
 	hs.createHTTPClient()
-	err = hs.sendHTTPRequest(nil)
+	_, err = hs.sendHTTPRequest(nil, 1)
 	assert.NoError(t, err)
+	assert.Equal(t, "value", header)
 }
@@ -76,9 +76,11 @@ func NewMetricsServer(labels map[string]string) *MetricsPublisher {
 	return metricsPublisher
 
 }
-func (mp *MetricsPublisher) startMetricServer(port int) error {
+func (mp *MetricsPublisher) startMetricServer(port int, health *healthChecker) error {
 	address := fmt.Sprintf(":%d", port)
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", health.livenessHandler)
+	http.HandleFunc("/readyz", health.readinessHandler)
 	http.ListenAndServe(address, nil)
 	return nil
 }
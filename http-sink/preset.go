@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	presetSlack         = "slack"
+	presetTeams         = "teams"
+	presetTeamsAdaptive = "teams-adaptive"
+	presetPagerDuty     = "pagerduty"
+)
+
+// Fields a preset reads from the datum, mapped to a dotted JSON path with -presetFields.
+const (
+	fieldTitle    = "title"
+	fieldText     = "text"
+	fieldSeverity = "severity"
+	fieldSource   = "source"
+	fieldStatus   = "status"
+	fieldDedupKey = "dedupKey"
+	fieldLink     = "link"
+)
+
+var defaultPresetFields = map[string]string{
+	fieldTitle:    "title",
+	fieldText:     "text",
+	fieldSeverity: "severity",
+	fieldSource:   "source",
+	fieldStatus:   "status",
+	fieldDedupKey: "id",
+	fieldLink:     "url",
+}
+
+var defaultResolveValues = []string{"resolved", "ok"}
+
+type presetConfig struct {
+	name          string
+	fields        map[string]string
+	routingKey    string
+	resolveValues []string
+}
+
+// preset turns a JSON datum into the webhook payload of a notification service.
+type preset interface {
+	render(data []byte) ([]byte, error)
+}
+
+func newPreset(cfg presetConfig) (preset, error) {
+	fields := make(map[string]string, len(defaultPresetFields))
+	for key, path := range defaultPresetFields {
+		fields[key] = path
+	}
+	for key, path := range cfg.fields {
+		if _, ok := defaultPresetFields[key]; !ok {
+			return nil, fmt.Errorf("unknown preset field %q", key)
+		}
+		fields[key] = path
+	}
+	resolveValues := cfg.resolveValues
+	if len(resolveValues) == 0 {
+		resolveValues = defaultResolveValues
+	}
+	m := fieldMapper{fields: fields, resolveValues: resolveValues}
+	switch strings.ToLower(cfg.name) {
+	case presetSlack:
+		return &slackPreset{m}, nil
+	case presetTeams:
+		return &teamsPreset{m}, nil
+	case presetTeamsAdaptive:
+		return &teamsAdaptivePreset{m}, nil
+	case presetPagerDuty:
+		if cfg.routingKey == "" {
+			return nil, fmt.Errorf("pagerduty preset requires a routing key")
+		}
+		return &pagerDutyPreset{fieldMapper: m, routingKey: cfg.routingKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown preset %q", cfg.name)
+	}
+}
+
+type fieldMapper struct {
+	fields        map[string]string
+	resolveValues []string
+}
+
+func (m fieldMapper) decode(data []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("preset requires a JSON object payload: %w", err)
+	}
+	return doc, nil
+}
+
+// lookup returns the value at the path mapped to field, rendered as a string.
+func (m fieldMapper) lookup(doc map[string]interface{}, field string) string {
+	var current interface{} = doc
+	for _, key := range strings.Split(m.fields[field], ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		current = obj[key]
+	}
+	switch v := current.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+func (m fieldMapper) resolved(doc map[string]interface{}) bool {
+	status := m.lookup(doc, fieldStatus)
+	for _, value := range m.resolveValues {
+		if strings.EqualFold(status, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m fieldMapper) title(doc map[string]interface{}) string {
+	if title := m.lookup(doc, fieldTitle); title != "" {
+		return title
+	}
+	return "Notification"
+}
+
+type fact struct {
+	name  string
+	value string
+}
+
+// facts returns the severity, status and source of the datum that are set.
+func (m fieldMapper) facts(doc map[string]interface{}) []fact {
+	var facts []fact
+	for _, f := range []struct{ name, field string }{
+		{"Severity", fieldSeverity},
+		{"Status", fieldStatus},
+		{"Source", fieldSource},
+	} {
+		if value := m.lookup(doc, f.field); value != "" {
+			facts = append(facts, fact{name: f.name, value: value})
+		}
+	}
+	return facts
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackPreset struct {
+	fieldMapper
+}
+
+func (p *slackPreset) render(data []byte) ([]byte, error) {
+	doc, err := p.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	title := p.title(doc)
+	msg := slackMessage{
+		Text:   title,
+		Blocks: []slackBlock{{Type: "header", Text: &slackText{Type: "plain_text", Text: title}}},
+	}
+	if text := p.lookup(doc, fieldText); text != "" {
+		msg.Blocks = append(msg.Blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}})
+	}
+	var elements []slackText
+	for _, f := range p.facts(doc) {
+		elements = append(elements, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s:* %s", f.name, f.value)})
+	}
+	if link := p.lookup(doc, fieldLink); link != "" {
+		elements = append(elements, slackText{Type: "mrkdwn", Text: fmt.Sprintf("<%s|View details>", link)})
+	}
+	if len(elements) > 0 {
+		msg.Blocks = append(msg.Blocks, slackBlock{Type: "context", Elements: elements})
+	}
+	return marshalPayload(msg)
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type teamsSection struct {
+	Facts []teamsFact `json:"facts"`
+}
+
+type teamsTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+type teamsAction struct {
+	Type    string        `json:"@type"`
+	Name    string        `json:"name"`
+	Targets []teamsTarget `json:"targets"`
+}
+
+type teamsMessageCard struct {
+	Type            string         `json:"@type"`
+	Context         string         `json:"@context"`
+	ThemeColor      string         `json:"themeColor"`
+	Summary         string         `json:"summary"`
+	Title           string         `json:"title"`
+	Text            string         `json:"text,omitempty"`
+	Sections        []teamsSection `json:"sections,omitempty"`
+	PotentialAction []teamsAction  `json:"potentialAction,omitempty"`
+}
+
+type teamsPreset struct {
+	fieldMapper
+}
+
+func (p *teamsPreset) render(data []byte) ([]byte, error) {
+	doc, err := p.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	title := p.title(doc)
+	card := teamsMessageCard{
+		Type:       "MessageCard",
+		Context:    "http://schema.org/extensions",
+		ThemeColor: p.themeColor(doc),
+		Summary:    title,
+		Title:      title,
+		Text:       p.lookup(doc, fieldText),
+	}
+	if facts := p.facts(doc); len(facts) > 0 {
+		section := teamsSection{}
+		for _, f := range facts {
+			section.Facts = append(section.Facts, teamsFact{Name: f.name, Value: f.value})
+		}
+		card.Sections = []teamsSection{section}
+	}
+	if link := p.lookup(doc, fieldLink); link != "" {
+		card.PotentialAction = []teamsAction{{
+			Type:    "OpenUri",
+			Name:    "View details",
+			Targets: []teamsTarget{{OS: "default", URI: link}},
+		}}
+	}
+	return marshalPayload(card)
+}
+
+func (p *teamsPreset) themeColor(doc map[string]interface{}) string {
+	if p.resolved(doc) {
+		return "2EB886"
+	}
+	switch pagerDutySeverity(p.lookup(doc, fieldSeverity)) {
+	case "critical", "error":
+		return "D32F2F"
+	case "warning":
+		return "FFA000"
+	default:
+		return "0076D7"
+	}
+}
+
+type adaptiveFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type adaptiveElement struct {
+	Type   string         `json:"type"`
+	Text   string         `json:"text,omitempty"`
+	Size   string         `json:"size,omitempty"`
+	Weight string         `json:"weight,omitempty"`
+	Wrap   bool           `json:"wrap,omitempty"`
+	Facts  []adaptiveFact `json:"facts,omitempty"`
+}
+
+type adaptiveAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type adaptiveCard struct {
+	Schema  string            `json:"$schema"`
+	Type    string            `json:"type"`
+	Version string            `json:"version"`
+	Body    []adaptiveElement `json:"body"`
+	Actions []adaptiveAction  `json:"actions,omitempty"`
+}
+
+type adaptiveAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveMessage struct {
+	Type        string               `json:"type"`
+	Attachments []adaptiveAttachment `json:"attachments"`
+}
+
+type teamsAdaptivePreset struct {
+	fieldMapper
+}
+
+func (p *teamsAdaptivePreset) render(data []byte) ([]byte, error) {
+	doc, err := p.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	card := adaptiveCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body:    []adaptiveElement{{Type: "TextBlock", Text: p.title(doc), Size: "Large", Weight: "Bolder", Wrap: true}},
+	}
+	if text := p.lookup(doc, fieldText); text != "" {
+		card.Body = append(card.Body, adaptiveElement{Type: "TextBlock", Text: text, Wrap: true})
+	}
+	if facts := p.facts(doc); len(facts) > 0 {
+		factSet := adaptiveElement{Type: "FactSet"}
+		for _, f := range facts {
+			factSet.Facts = append(factSet.Facts, adaptiveFact{Title: f.name, Value: f.value})
+		}
+		card.Body = append(card.Body, factSet)
+	}
+	if link := p.lookup(doc, fieldLink); link != "" {
+		card.Actions = []adaptiveAction{{Type: "Action.OpenUrl", Title: "View details", URL: link}}
+	}
+	return marshalPayload(adaptiveMessage{
+		Type:        "message",
+		Attachments: []adaptiveAttachment{{ContentType: "application/vnd.microsoft.card.adaptive", Content: card}},
+	})
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPreset struct {
+	fieldMapper
+	routingKey string
+}
+
+func (p *pagerDutyPreset) render(data []byte) ([]byte, error) {
+	doc, err := p.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	event := pagerDutyEvent{
+		RoutingKey: p.routingKey,
+		DedupKey:   p.lookup(doc, fieldDedupKey),
+	}
+	if p.resolved(doc) {
+		if event.DedupKey == "" {
+			return nil, fmt.Errorf("pagerduty resolve event requires a dedup key at %q", p.fields[fieldDedupKey])
+		}
+		event.EventAction = "resolve"
+		return marshalPayload(event)
+	}
+	source := p.lookup(doc, fieldSource)
+	if source == "" {
+		source = "numaflow"
+	}
+	event.EventAction = "trigger"
+	event.Payload = &pagerDutyPayload{
+		Summary:       p.title(doc),
+		Source:        source,
+		Severity:      pagerDutySeverity(p.lookup(doc, fieldSeverity)),
+		CustomDetails: doc,
+	}
+	if link := p.lookup(doc, fieldLink); link != "" {
+		event.Links = []pagerDutyLink{{Href: link, Text: "View details"}}
+	}
+	return marshalPayload(event)
+}
+
+// pagerDutySeverity maps a free form severity to one accepted by the Events v2 API.
+func pagerDutySeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical", "crit", "fatal", "page":
+		return "critical"
+	case "error", "err", "high", "major":
+		return "error"
+	case "warning", "warn", "medium", "minor":
+		return "warning"
+	case "info", "low", "ok":
+		return "info"
+	default:
+		return "error"
+	}
+}
+
+// presetNames lists the supported presets for flag help.
+func presetNames() string {
+	return strings.Join([]string{presetSlack, presetTeams, presetTeamsAdaptive, presetPagerDuty}, ",")
+}
+
+// marshalPayload encodes v without escaping the HTML characters used in Slack links.
+func marshalPayload(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+var testPresetFields = map[string]string{
+	"title":    "alert.name",
+	"text":     "alert.description",
+	"severity": "alert.severity",
+	"source":   "alert.host",
+	"status":   "alert.state",
+	"dedupKey": "alert.id",
+	"link":     "alert.dashboard",
+}
+
+func assertGolden(t *testing.T, name string, actual []byte) {
+	t.Helper()
+	golden := filepath.Join("testdata", "presets", name+".golden.json")
+	if *updateGolden {
+		var out bytes.Buffer
+		assert.NoError(t, json.Indent(&out, actual, "", "  "))
+		out.WriteString("\n")
+		assert.NoError(t, os.WriteFile(golden, out.Bytes(), 0644))
+	}
+	expected, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(actual))
+}
+
+func TestPreset_Golden(t *testing.T) {
+	tests := []struct {
+		golden string
+		preset string
+		input  string
+	}{
+		{"slack", presetSlack, "alert.json"},
+		{"teams", presetTeams, "alert.json"},
+		{"teams-adaptive", presetTeamsAdaptive, "alert.json"},
+		{"pagerduty-trigger", presetPagerDuty, "alert.json"},
+		{"pagerduty-resolve", presetPagerDuty, "resolved.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			p, err := newPreset(presetConfig{name: tt.preset, fields: testPresetFields, routingKey: "routing-key"})
+			assert.NoError(t, err)
+			input, err := os.ReadFile(filepath.Join("testdata", "presets", tt.input))
+			assert.NoError(t, err)
+			out, err := p.render(input)
+			assert.NoError(t, err)
+			assertGolden(t, tt.golden, out)
+		})
+	}
+}
+
+func TestPreset_DefaultFields(t *testing.T) {
+	p, err := newPreset(presetConfig{name: presetSlack})
+	assert.NoError(t, err)
+	out, err := p.render([]byte(`{"title":"Disk full","text":"/var is at 99%"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"text":"Disk full","blocks":[{"type":"header","text":{"type":"plain_text","text":"Disk full"}},{"type":"section","text":{"type":"mrkdwn","text":"/var is at 99%"}}]}`, string(out))
+}
+
+func TestPreset_Errors(t *testing.T) {
+	_, err := newPreset(presetConfig{name: "email"})
+	assert.ErrorContains(t, err, "unknown preset")
+	_, err = newPreset(presetConfig{name: presetSlack, fields: map[string]string{"color": "c"}})
+	assert.ErrorContains(t, err, "unknown preset field")
+	_, err = newPreset(presetConfig{name: presetPagerDuty})
+	assert.ErrorContains(t, err, "routing key")
+
+	p, err := newPreset(presetConfig{name: presetPagerDuty, routingKey: "key"})
+	assert.NoError(t, err)
+	_, err = p.render([]byte(`not json`))
+	assert.ErrorContains(t, err, "JSON object")
+	_, err = p.render([]byte(`{"status":"resolved"}`))
+	assert.ErrorContains(t, err, "dedup key")
+}
+
+func TestPagerDutySeverity(t *testing.T) {
+	assert.Equal(t, "critical", pagerDutySeverity("CRIT"))
+	assert.Equal(t, "error", pagerDutySeverity("high"))
+	assert.Equal(t, "warning", pagerDutySeverity("warn"))
+	assert.Equal(t, "info", pagerDutySeverity("low"))
+	assert.Equal(t, "error", pagerDutySeverity(""))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// httpResponse is the part of a downstream response kept after the body is closed.
+type httpResponse struct {
+	status    int
+	body      []byte
+	truncated bool
+}
+
+// responseRecord is a downstream response joined with the datum that produced it.
+type responseRecord struct {
+	DatumID    string          `json:"datumId"`
+	EventTime  time.Time       `json:"eventTime"`
+	RecordedAt time.Time       `json:"recordedAt"`
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	Attempt    int             `json:"attempt"`
+	Status     int             `json:"status"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Truncated  bool            `json:"truncated,omitempty"`
+}
+
+func newResponseRecord(datumID string, eventTime time.Time, method, url string, attempt int, res *httpResponse) responseRecord {
+	record := responseRecord{
+		DatumID:    datumID,
+		EventTime:  eventTime,
+		RecordedAt: time.Now(),
+		Method:     method,
+		URL:        url,
+		Attempt:    attempt,
+		Status:     res.status,
+		Truncated:  res.truncated,
+	}
+	// JSON bodies are embedded as is, anything else is kept as a JSON string
+	if len(res.body) > 0 {
+		if !res.truncated && json.Valid(res.body) {
+			record.Body = res.body
+		} else {
+			record.Body, _ = json.Marshal(string(res.body))
+		}
+	}
+	return record
+}
+
+type responseWriter interface {
+	write(record responseRecord) error
+	close() error
+}
+
+// responseForwarder sends every recorded response to the configured writers.
+type responseForwarder struct {
+	logger   *zap.SugaredLogger
+	maxBytes int64
+	writers  []responseWriter
+}
+
+func (rf *responseForwarder) forward(record responseRecord) {
+	for _, w := range rf.writers {
+		if err := w.write(record); err != nil {
+			rf.logger.Warnf("Failed to forward response for datum %s. %v", record.DatumID, err)
+		}
+	}
+}
+
+func (rf *responseForwarder) close() {
+	for _, w := range rf.writers {
+		if err := w.close(); err != nil {
+			rf.logger.Warnf("Failed to close response writer. %v", err)
+		}
+	}
+}
+
+// fileResponseWriter appends records as NDJSON and rotates the file once it reaches maxSize.
+type fileResponseWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newFileResponseWriter(path string, maxSize int64, maxBackups int) (*fileResponseWriter, error) {
+	w := &fileResponseWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *fileResponseWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *fileResponseWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if w.maxBackups <= 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return w.open()
+	}
+	for i := w.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *fileResponseWriter) write(record responseRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size > 0 && w.size+int64(len(line)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+func (w *fileResponseWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// httpResponseWriter posts each record as JSON to a collector URL.
+type httpResponseWriter struct {
+	client *http.Client
+	url    string
+}
+
+func (w *httpResponseWriter) write(record responseRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("response collector returned status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (w *httpResponseWriter) close() error {
+	return nil
+}
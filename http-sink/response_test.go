@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func readRecords(t *testing.T, path string) []responseRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	var records []responseRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record responseRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestNewResponseRecord(t *testing.T) {
+	eventTime := time.UnixMilli(1680124991883).UTC()
+	record := newResponseRecord("id-1", eventTime, http.MethodPost, "http://example.com", 2, &httpResponse{status: 200, body: []byte(`{"score":1}`)})
+	b, err := json.Marshal(record)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"body":{"score":1}`)
+	assert.Contains(t, string(b), `"datumId":"id-1"`)
+	assert.Contains(t, string(b), `"eventTime":"2023-03-29T21:23:11.883Z"`)
+
+	record = newResponseRecord("id-2", eventTime, http.MethodPost, "http://example.com", 1, &httpResponse{status: 200, body: []byte(`{"sco`), truncated: true})
+	b, err = json.Marshal(record)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"body":"{\"sco"`)
+	assert.Contains(t, string(b), `"truncated":true`)
+}
+
+func TestFileResponseWriter_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "responses.ndjson")
+	w, err := newFileResponseWriter(path, 200, 2)
+	assert.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, w.write(responseRecord{DatumID: string(rune('a' + i)), Status: 200}))
+	}
+	assert.NoError(t, w.close())
+
+	current := readRecords(t, path)
+	backup := readRecords(t, path+".1")
+	assert.NotEmpty(t, current)
+	assert.NotEmpty(t, backup)
+	assert.Equal(t, "f", current[len(current)-1].DatumID)
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(200))
+}
+
+func TestHttpResponseWriter(t *testing.T) {
+	var received responseRecord
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer collector.Close()
+	w := &httpResponseWriter{client: collector.Client(), url: collector.URL}
+	assert.NoError(t, w.write(responseRecord{DatumID: "id-1", Status: 201}))
+	assert.Equal(t, "id-1", received.DatumID)
+	assert.Equal(t, 201, received.Status)
+}
+
+func TestHttpSink_ResponseBodyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"enrichment":"value"}`))
+	}))
+	defer server.Close()
+	hs := httpSink{url: server.URL, method: http.MethodPost, logger: logging.NewLogger().Named("http-sink")}
+	hs.createHTTPClient()
+
+	res, err := hs.sendHTTPRequest([]byte("{}"), 1)
+	assert.NoError(t, err)
+	assert.Empty(t, res.body)
+
+	hs.responses = &responseForwarder{logger: hs.logger, maxBytes: 10}
+	res, err = hs.sendHTTPRequest([]byte("{}"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.status)
+	assert.Equal(t, `{"enrichme`, string(res.body))
+	assert.True(t, res.truncated)
+
+	hs.responses.maxBytes = 1024
+	res, err = hs.sendHTTPRequest([]byte("{}"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"enrichment":"value"}`, string(res.body))
+	assert.False(t, res.truncated)
+}
@@ -0,0 +1,65 @@
+package main
+
+import "errors"
+
+// writeError is returned when some payloads of a batch failed to write. It holds the index of
+// the datums that produced them, so only these datums are redelivered.
+type writeError struct {
+	err    error
+	datums map[int]error
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *writeError) Unwrap() error {
+	return e.err
+}
+
+// fail records that a payload of the datum failed to write, keeping the first error of the datum.
+func (e *writeError) fail(datum int, err error) {
+	if e.datums == nil {
+		e.datums = make(map[int]error)
+	}
+	if _, ok := e.datums[datum]; !ok {
+		e.datums[datum] = err
+	}
+}
+
+// failed reports whether any datum of the payload failed.
+func (e *writeError) failed(payload PrometheusPayload) bool {
+	for _, datum := range payload.datums() {
+		if _, ok := e.datums[datum]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// failedDatums maps a write error to the datums it failed. An error without datum information fails every datum of the payloads.
+func failedDatums(err error, payloads []PrometheusPayload) map[int]error {
+	var werr *writeError
+	if errors.As(err, &werr) {
+		return werr.datums
+	}
+	failed := make(map[int]error)
+	for _, payload := range payloads {
+		for _, datum := range payload.datums() {
+			failed[datum] = err
+		}
+	}
+	return failed
+}
+
+// succeeded returns the payloads whose datums did not fail.
+func succeeded(payloads []PrometheusPayload, failed map[int]error) []PrometheusPayload {
+	werr := &writeError{datums: failed}
+	remaining := make([]PrometheusPayload, 0, len(payloads))
+	for _, payload := range payloads {
+		if !werr.failed(payload) {
+			remaining = append(remaining, payload)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	sinksdk "github.com/numaproj/numaflow-go/pkg/sinker"
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+type testDatum struct {
+	id    string
+	value string
+}
+
+func (d testDatum) Keys() []string             { return nil }
+func (d testDatum) Value() []byte              { return []byte(d.value) }
+func (d testDatum) EventTime() time.Time       { return time.Time{} }
+func (d testDatum) Watermark() time.Time       { return time.Time{} }
+func (d testDatum) ID() string                 { return d.id }
+func (d testDatum) Headers() map[string]string { return nil }
+
+func sinkDatums(ps *prometheusSink, datums ...testDatum) map[string]sinksdk.Response {
+	ch := make(chan sinksdk.Datum, len(datums))
+	for _, datum := range datums {
+		ch <- datum
+	}
+	close(ch)
+	results := make(map[string]sinksdk.Response)
+	for _, res := range ps.Sink(context.Background(), ch).Items() {
+		results[res.ID] = res
+	}
+	return results
+}
+
+func TestSink_PerDatumAck(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	pgw.fail = "/metrics/job/ns_none_fail"
+	ps := &prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 2, validation: VALIDATION_FAIL}
+	ps.metrics = NewMetricsServer(nil, "test_ack")
+
+	results := sinkDatums(ps,
+		testDatum{id: "ok", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1}`},
+		testDatum{id: "malformed", value: `{"name":`},
+		testDatum{id: "negative", value: `{"name":"requests","namespace":"ns","subsystem":"none","type":"Counter","value":-1}`},
+		testDatum{id: "invalid-name", value: `{"name":"bad name","namespace":"ns","subsystem":"none","type":"Gauge","value":1}`},
+		testDatum{id: "push-failed", value: `{"name":"fail","namespace":"ns","subsystem":"none","type":"Gauge","value":1}`},
+	)
+	assert.Len(t, results, 5)
+	assert.True(t, results["ok"].Success)
+	assert.False(t, results["malformed"].Success)
+	assert.Contains(t, results["negative"].Err, "non-negative")
+	assert.Contains(t, results["invalid-name"].Err, "invalid metric name")
+	assert.Contains(t, results["push-failed"].Err, "500")
+	assert.Len(t, pgw.requests["/metrics/job/ns_none_score"], 1)
+
+	// Messages failing to decode are acknowledged when validation failures are skipped
+	ps.skipFailed = true
+	results = sinkDatums(ps, testDatum{id: "malformed", value: `{"name":`})
+	assert.True(t, results["malformed"].Success)
+}
+
+func TestFailedDatums(t *testing.T) {
+	payloads := []PrometheusPayload{{Name: "a", datum: 0}, {Name: "b", datum: 2}}
+	failed := failedDatums(fmt.Errorf("connection refused"), payloads)
+	assert.Len(t, failed, 2)
+	assert.Contains(t, failed, 2)
+
+	werr := &writeError{err: fmt.Errorf("failed")}
+	werr.fail(1, fmt.Errorf("first"))
+	werr.fail(1, fmt.Errorf("second"))
+	failed = failedDatums(werr, payloads)
+	assert.Len(t, failed, 1)
+	assert.EqualError(t, failed[1], "first")
+}
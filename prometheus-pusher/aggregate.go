@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Aggregation functions selected with -aggregation
+const (
+	AGGREGATION_NONE  = "none"
+	AGGREGATION_LAST  = "last"
+	AGGREGATION_MAX   = "max"
+	AGGREGATION_MIN   = "min"
+	AGGREGATION_SUM   = "sum"
+	AGGREGATION_AVG   = "avg"
+	AGGREGATION_COUNT = "count"
+)
+
+// Suffixes of the companion series exposing the number and the sum of the aggregated samples
+const (
+	aggregatedCountSuffix = "_aggregated_count"
+	aggregatedSumSuffix   = "_aggregated_sum"
+)
+
+// aggregator reduces the samples of the same series in a batch to a single sample.
+type aggregator struct {
+	function string
+	// companions adds the _aggregated_count and _aggregated_sum series of each aggregated series
+	companions bool
+}
+
+func newAggregator(function string, companions bool) (*aggregator, error) {
+	switch function {
+	case AGGREGATION_NONE, "":
+		return nil, nil
+	case AGGREGATION_LAST, AGGREGATION_MAX, AGGREGATION_MIN, AGGREGATION_SUM, AGGREGATION_AVG, AGGREGATION_COUNT:
+		return &aggregator{function: function, companions: companions}, nil
+	}
+	return nil, fmt.Errorf("invalid aggregation %q, must be one of none,last,max,min,sum,avg,count", function)
+}
+
+// aggregation holds the samples of a series seen so far.
+type aggregation struct {
+	index int
+	// gauge is set for the gauge and untyped series, the only ones reduced by the function and with companions
+	gauge bool
+	count int
+	sum   float64
+	min   float64
+	max   float64
+}
+
+// aggregate groups the payloads by metric type, name and labels and reduces the values of each group with
+// the aggregation function, in order of first appearance. The aggregated payload is the sample with the latest
+// timestamp, the later one for equal timestamps, with the exemplars and the datums of all samples. Counters,
+// histograms and summaries are cumulative, so they keep this latest sample whatever the function.
+// Delete markers are kept in place and samples are not aggregated across them.
+func (a *aggregator) aggregate(payloads []PrometheusPayload) []PrometheusPayload {
+	if a == nil {
+		return payloads
+	}
+	result := make([]PrometheusPayload, 0, len(payloads))
+	var order []*aggregation
+	series := make(map[string]*aggregation)
+	for _, payload := range payloads {
+		if payload.Delete {
+			result = append(result, payload)
+			series = make(map[string]*aggregation)
+			continue
+		}
+		key := groupKey(payload.metricType()+"\xff"+jobName(payload), payload.Labels)
+		agg, ok := series[key]
+		if !ok {
+			agg = &aggregation{index: len(result), count: 1, sum: payload.Value, min: payload.Value, max: payload.Value}
+			series[key] = agg
+			order = append(order, agg)
+			result = append(result, payload)
+			continue
+		}
+		agg.count++
+		agg.sum += payload.Value
+		agg.min = math.Min(agg.min, payload.Value)
+		agg.max = math.Max(agg.max, payload.Value)
+		merged := &result[agg.index]
+		datums := append(merged.datums(), payload.datum)
+		datums = append(datums, payload.merged...)
+		exemplars := append(slices.Clip(merged.Exemplars), payload.Exemplars...)
+		if payload.TimestampMs >= merged.TimestampMs {
+			*merged = payload
+		}
+		merged.datum, merged.merged = datums[0], datums[1:]
+		merged.Exemplars = exemplars
+	}
+	for _, agg := range order {
+		payload := &result[agg.index]
+		if payload.metricType() != metricTypeGauge && payload.metricType() != metricTypeUntyped {
+			continue
+		}
+		switch a.function {
+		case AGGREGATION_MAX:
+			payload.Value = agg.max
+		case AGGREGATION_MIN:
+			payload.Value = agg.min
+		case AGGREGATION_SUM:
+			payload.Value = agg.sum
+		case AGGREGATION_AVG:
+			payload.Value = agg.sum / float64(agg.count)
+		case AGGREGATION_COUNT:
+			payload.Value = float64(agg.count)
+		}
+		agg.gauge = true
+	}
+	if !a.companions {
+		return result
+	}
+	// The companions follow their series, so they stay before a later delete marker of the group
+	withCompanions := make([]PrometheusPayload, 0, len(result)+2*len(order))
+	next := 0
+	for i, payload := range result {
+		withCompanions = append(withCompanions, payload)
+		if next == len(order) || order[next].index != i {
+			continue
+		}
+		agg := order[next]
+		next++
+		if agg.gauge {
+			withCompanions = append(withCompanions, companion(payload, aggregatedCountSuffix, float64(agg.count)),
+				companion(payload, aggregatedSumSuffix, agg.sum))
+		}
+	}
+	return withCompanions
+}
+
+// companion returns a gauge of the payload series with the name suffix and the value.
+func companion(payload PrometheusPayload, suffix string, value float64) PrometheusPayload {
+	payload.Name += suffix
+	payload.Type = "Gauge"
+	payload.Value = value
+	payload.Exemplars = nil
+	return payload
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func aggregationPayloads() []PrometheusPayload {
+	return []PrometheusPayload{
+		{Name: "score", Type: "Gauge", Value: 2, TimestampMs: 10, Labels: map[string]string{"app": "a"}, datum: 0},
+		{Name: "score", Type: "Gauge", Value: 7, TimestampMs: 30, Labels: map[string]string{"app": "b"}, datum: 0},
+		{Name: "score", Type: "Gauge", Value: 4, TimestampMs: 20, Labels: map[string]string{"app": "a"}, datum: 1},
+		{Name: "score", Type: "Gauge", Value: 3, TimestampMs: 15, Labels: map[string]string{"app": "a"}, datum: 2,
+			Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "abc"}, Value: 3}}},
+	}
+}
+
+func TestNewAggregator(t *testing.T) {
+	a, err := newAggregator(AGGREGATION_NONE, true)
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+	payloads := aggregationPayloads()
+	assert.Equal(t, payloads, a.aggregate(payloads))
+
+	_, err = newAggregator("median", false)
+	assert.ErrorContains(t, err, "invalid aggregation")
+}
+
+func TestAggregator_Functions(t *testing.T) {
+	for function, expected := range map[string]float64{
+		// The latest sample is the one with the highest timestamp, not the last one received
+		AGGREGATION_LAST:  4,
+		AGGREGATION_MAX:   4,
+		AGGREGATION_MIN:   2,
+		AGGREGATION_SUM:   9,
+		AGGREGATION_AVG:   3,
+		AGGREGATION_COUNT: 3,
+	} {
+		a, err := newAggregator(function, false)
+		assert.NoError(t, err)
+		result := a.aggregate(aggregationPayloads())
+		assert.Len(t, result, 2, function)
+		assert.Equal(t, expected, result[0].Value, function)
+		assert.Equal(t, int64(20), result[0].TimestampMs, function)
+		assert.ElementsMatch(t, []int{0, 1, 2}, result[0].datums(), function)
+		assert.Len(t, result[0].Exemplars, 1, function)
+		// A single sample keeps its value, except for count
+		if function == AGGREGATION_COUNT {
+			assert.Equal(t, float64(1), result[1].Value, function)
+		} else {
+			assert.Equal(t, float64(7), result[1].Value, function)
+		}
+		assert.Equal(t, []int{0}, result[1].datums(), function)
+	}
+}
+
+func TestAggregator_Companions(t *testing.T) {
+	a, err := newAggregator(AGGREGATION_MAX, true)
+	assert.NoError(t, err)
+	result := a.aggregate(aggregationPayloads())
+	// The companions follow their series, a series with a single sample has them too
+	assert.Len(t, result, 6)
+	assert.Equal(t, "score", result[0].Name)
+	assert.Equal(t, "score_aggregated_count", result[1].Name)
+	assert.Equal(t, float64(3), result[1].Value)
+	assert.Equal(t, "a", result[1].Labels["app"])
+	assert.Equal(t, "score_aggregated_sum", result[2].Name)
+	assert.Equal(t, float64(9), result[2].Value)
+	assert.Empty(t, result[2].Exemplars)
+	assert.ElementsMatch(t, []int{0, 1, 2}, result[2].datums())
+	assert.Equal(t, "score", result[3].Name)
+	assert.Equal(t, "b", result[4].Labels["app"])
+	assert.Equal(t, float64(1), result[4].Value)
+	assert.Equal(t, float64(7), result[5].Value)
+
+	// Companions stay before a later delete marker of their group
+	result = a.aggregate([]PrometheusPayload{
+		{Name: "score", Type: "Gauge", Value: 1},
+		{Name: "score", Type: "Gauge", Delete: true},
+	})
+	assert.Equal(t, []string{"score", "score_aggregated_count", "score_aggregated_sum", "score"},
+		[]string{result[0].Name, result[1].Name, result[2].Name, result[3].Name})
+	assert.True(t, result[3].Delete)
+}
+
+func TestAggregator_Counters(t *testing.T) {
+	for _, function := range []string{AGGREGATION_LAST, AGGREGATION_MAX, AGGREGATION_MIN, AGGREGATION_SUM, AGGREGATION_AVG, AGGREGATION_COUNT} {
+		a, err := newAggregator(function, true)
+		assert.NoError(t, err)
+		result := a.aggregate([]PrometheusPayload{
+			{Name: "requests_total", Type: "Counter", Value: 10, TimestampMs: 10, datum: 0},
+			{Name: "requests_total", Type: "Counter", Value: 30, TimestampMs: 30, datum: 1},
+			{Name: "requests_total", Type: "Counter", Value: 20, TimestampMs: 20, datum: 2},
+		})
+		// Counters are cumulative, they keep the latest sample whatever the function
+		assert.Len(t, result, 1, function)
+		assert.Equal(t, float64(30), result[0].Value, function)
+		assert.Equal(t, int64(30), result[0].TimestampMs, function)
+		assert.ElementsMatch(t, []int{0, 1, 2}, result[0].datums(), function)
+	}
+}
+
+func TestAggregator_Boundaries(t *testing.T) {
+	a, err := newAggregator(AGGREGATION_SUM, false)
+	assert.NoError(t, err)
+	result := a.aggregate([]PrometheusPayload{
+		{Name: "score", Type: "Gauge", Value: 1},
+		{Name: "score", Type: "Counter", Value: 2},
+		{Name: "latency", Type: "Histogram", Count: 1, Sum: 0.1, Buckets: map[string]uint64{"1": 1}},
+		{Name: "latency", Type: "Histogram", Count: 3, Sum: 0.9, Buckets: map[string]uint64{"1": 3}},
+		{Name: "score", Namespace: "other", Type: "Gauge", Value: 3},
+		{Name: "score", Type: "Gauge", Delete: true},
+		{Name: "score", Type: "Gauge", Value: 4},
+		{Name: "score", Type: "Gauge", Value: 5},
+	})
+	assert.Len(t, result, 6)
+	// Metric types and namespaces are aggregated separately
+	assert.Equal(t, float64(1), result[0].Value)
+	assert.Equal(t, float64(2), result[1].Value)
+	// Histograms keep the last sample
+	assert.Equal(t, uint64(3), result[2].Count)
+	assert.Equal(t, float64(3), result[3].Value)
+	// Samples are not aggregated across a delete marker
+	assert.True(t, result[4].Delete)
+	assert.Equal(t, float64(9), result[5].Value)
+}
+
+func TestSink_Aggregation(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	pgw.fail = "/metrics/job/ns_none_fail"
+	ps := &prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1}
+	ps.metrics = NewMetricsServer(nil, "test_aggregation")
+	ps.aggregator, _ = newAggregator(AGGREGATION_MAX, false)
+
+	results := sinkDatums(ps,
+		testDatum{id: "1", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1}`},
+		testDatum{id: "2", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":3}`},
+		testDatum{id: "3", value: `{"name":"fail","namespace":"ns","subsystem":"none","type":"Gauge","value":1}`},
+		testDatum{id: "4", value: `{"name":"fail","namespace":"ns","subsystem":"none","type":"Gauge","value":2}`},
+	)
+	assert.True(t, results["1"].Success)
+	assert.True(t, results["2"].Success)
+	// Every datum aggregated into a failed sample fails
+	assert.False(t, results["3"].Success)
+	assert.False(t, results["4"].Success)
+	pushed := pgw.requests["/metrics/job/ns_none_score"]
+	if assert.Len(t, pushed, 1) {
+		assert.Equal(t, float64(3), pushed[0].GetMetric()[0].GetGauge().GetValue())
+	}
+}
+
+func TestSink_AggregationCompanions(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	ps := &prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1}
+	ps.metrics = NewMetricsServer(nil, "test_aggregation_companions")
+	ps.aggregator, _ = newAggregator(AGGREGATION_MAX, true)
+	ps.limiter, _ = newCardinalityLimiter(logging.NewLogger().Named("cardinality"), 0, 2, time.Hour, CARDINALITY_DROP, nil)
+
+	results := sinkDatums(ps, testDatum{id: "1", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1}`})
+	assert.True(t, results["1"].Success)
+	// The companions count towards the cardinality limits like the other series
+	assert.Len(t, pgw.requests, 2)
+	assert.Contains(t, pgw.requests, "/metrics/job/ns_none_score")
+	assert.Contains(t, pgw.requests, "/metrics/job/ns_none_score_aggregated_count")
+	assert.Equal(t, float64(1), testutil.ToFloat64(ps.metrics.seriesTotalRejected.WithLabelValues(limitGlobal)))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Actions on the new series beyond the cardinality limits, selected with -cardinalityAction
+const (
+	CARDINALITY_DROP     = "drop"
+	CARDINALITY_OVERFLOW = "overflow"
+)
+
+// Limits reported as the limit label of total_series_rejected
+const (
+	limitMetric = "metric"
+	limitGlobal = "global"
+)
+
+// overflowLabel replaces the labels of the series beyond the limits in overflow mode, except the grouping labels
+const overflowLabel = "cardinality_overflow"
+
+// topLabelsLogged is the number of label names with the most values logged when a limit is reached
+const topLabelsLogged = 3
+
+type trackedSeries struct {
+	labels   map[string]string
+	lastSeen time.Time
+}
+
+// cardinalityLimiter tracks the label sets written per metric name over a sliding window and stops new series
+// once a metric or all metrics together reach their limit. Series already tracked are always written.
+// The overflow series keep the -groupingLabels of their samples.
+type cardinalityLimiter struct {
+	logger         *zap.SugaredLogger
+	metricLimit    int
+	globalLimit    int
+	window         time.Duration
+	overflow       bool
+	groupingLabels []string
+
+	mu      sync.Mutex
+	metrics map[string]map[string]*trackedSeries
+	total   int
+	now     func() time.Time
+}
+
+func newCardinalityLimiter(logger *zap.SugaredLogger, metricLimit, globalLimit int, window time.Duration, action string,
+	groupingLabels []string) (*cardinalityLimiter, error) {
+	if action != CARDINALITY_DROP && action != CARDINALITY_OVERFLOW {
+		return nil, fmt.Errorf("invalid cardinality action %q, must be one of drop,overflow", action)
+	}
+	if metricLimit < 0 || globalLimit < 0 {
+		return nil, fmt.Errorf("cardinality limits must not be negative")
+	}
+	if metricLimit == 0 && globalLimit == 0 {
+		return nil, nil
+	}
+	return &cardinalityLimiter{
+		logger:         logger,
+		metricLimit:    metricLimit,
+		globalLimit:    globalLimit,
+		window:         window,
+		overflow:       action == CARDINALITY_OVERFLOW,
+		groupingLabels: groupingLabels,
+		metrics:        make(map[string]map[string]*trackedSeries),
+		now:            time.Now,
+	}, nil
+}
+
+// expire forgets the series not seen within the window.
+func (l *cardinalityLimiter) expire(now time.Time) {
+	if l.window <= 0 {
+		return
+	}
+	for name, series := range l.metrics {
+		for key, s := range series {
+			if now.Sub(s.lastSeen) > l.window {
+				delete(series, key)
+				l.total--
+			}
+		}
+		if len(series) == 0 {
+			delete(l.metrics, name)
+		}
+	}
+}
+
+// limit returns the payloads within the limits, in overflow mode the payloads beyond them have their labels
+// replaced by the overflow label. It also returns the number of rejected series by limit.
+// The new series admitted are only tracked once record is called after they were written.
+func (l *cardinalityLimiter) limit(payloads []PrometheusPayload) ([]PrometheusPayload, map[string]int) {
+	if l == nil {
+		return payloads, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(l.now())
+	admitted := make([]PrometheusPayload, 0, len(payloads))
+	rejected := make(map[string]int)
+	// new series admitted in the batch, they count towards the limits like the tracked ones
+	pending := make(map[string]map[string]struct{})
+	pendingTotal := 0
+	// rejected label sets of each metric, to find the offending labels
+	offenders := make(map[string][]map[string]string)
+	for _, payload := range payloads {
+		key := groupKey(jobName(payload), payload.Labels)
+		series := l.metrics[payload.Name]
+		if payload.Delete {
+			admitted = append(admitted, payload)
+			continue
+		}
+		if _, ok := series[key]; ok {
+			admitted = append(admitted, payload)
+			continue
+		}
+		if _, ok := pending[payload.Name][key]; ok {
+			admitted = append(admitted, payload)
+			continue
+		}
+		var reached string
+		switch {
+		case l.metricLimit > 0 && len(series)+len(pending[payload.Name]) >= l.metricLimit:
+			reached = limitMetric
+		case l.globalLimit > 0 && l.total+pendingTotal >= l.globalLimit:
+			reached = limitGlobal
+		}
+		if reached != "" {
+			rejected[reached]++
+			offenders[payload.Name] = append(offenders[payload.Name], payload.Labels)
+			if l.overflow {
+				payload.Labels = l.overflowLabels(payload.Labels)
+				admitted = append(admitted, payload)
+			}
+			continue
+		}
+		if pending[payload.Name] == nil {
+			pending[payload.Name] = make(map[string]struct{})
+		}
+		pending[payload.Name][key] = struct{}{}
+		pendingTotal++
+		admitted = append(admitted, payload)
+	}
+	for name, labels := range offenders {
+		l.logger.Warnw("Series cardinality limit reached", zap.String("metric", name), zap.Int("rejected", len(labels)),
+			zap.Int("series", len(l.metrics[name])), zap.Int("total", l.total), zap.Strings("topLabels", l.topLabels(name, labels)))
+	}
+	return admitted, rejected
+}
+
+// overflowLabels returns the labels of the overflow series of a sample, its grouping labels and the overflow label,
+// so the overflow series stays in the group of the sample.
+func (l *cardinalityLimiter) overflowLabels(labels map[string]string) map[string]string {
+	overflow := map[string]string{overflowLabel: "true"}
+	for _, name := range l.groupingLabels {
+		if value, ok := labels[name]; ok {
+			overflow[name] = value
+		}
+	}
+	return overflow
+}
+
+// record tracks the series of the payloads written and refreshes the series already tracked.
+// A delete marker stops tracking its series, overflow series are never tracked.
+func (l *cardinalityLimiter) record(payloads []PrometheusPayload) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for _, payload := range payloads {
+		key := groupKey(jobName(payload), payload.Labels)
+		series := l.metrics[payload.Name]
+		if payload.Delete {
+			if _, ok := series[key]; ok {
+				delete(series, key)
+				l.total--
+			}
+			continue
+		}
+		if s, ok := series[key]; ok {
+			s.lastSeen = now
+			continue
+		}
+		if payload.Labels[overflowLabel] == "true" {
+			continue
+		}
+		if series == nil {
+			series = make(map[string]*trackedSeries)
+			l.metrics[payload.Name] = series
+		}
+		series[key] = &trackedSeries{labels: payload.Labels, lastSeen: now}
+		l.total++
+	}
+}
+
+// topLabels returns the label names with the most distinct values in the tracked and rejected series of the metric,
+// formatted as name=count.
+func (l *cardinalityLimiter) topLabels(name string, rejected []map[string]string) []string {
+	values := make(map[string]map[string]struct{})
+	count := func(labels map[string]string) {
+		for label, value := range labels {
+			if values[label] == nil {
+				values[label] = make(map[string]struct{})
+			}
+			values[label][value] = struct{}{}
+		}
+	}
+	for _, s := range l.metrics[name] {
+		count(s.labels)
+	}
+	for _, labels := range rejected {
+		count(labels)
+	}
+	names := make([]string, 0, len(values))
+	for label := range values {
+		names = append(names, label)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(values[names[i]]) != len(values[names[j]]) {
+			return len(values[names[i]]) > len(values[names[j]])
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > topLabelsLogged {
+		names = names[:topLabelsLogged]
+	}
+	for i, label := range names {
+		names[i] = fmt.Sprintf("%s=%d", label, len(values[label]))
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestPayloads(name string, from, to int) []PrometheusPayload {
+	var payloads []PrometheusPayload
+	for i := from; i < to; i++ {
+		payloads = append(payloads, PrometheusPayload{Name: name, Type: "Gauge", Value: float64(i),
+			Labels: map[string]string{"app": "web", "request_id": fmt.Sprint(i)}})
+	}
+	return payloads
+}
+
+// limitAndRecord limits the payloads and records them as written.
+func limitAndRecord(l *cardinalityLimiter, payloads []PrometheusPayload) ([]PrometheusPayload, map[string]int) {
+	payloads, rejected := l.limit(payloads)
+	l.record(payloads)
+	return payloads, rejected
+}
+
+func TestNewCardinalityLimiter(t *testing.T) {
+	l, err := newCardinalityLimiter(nil, 0, 0, time.Hour, CARDINALITY_DROP, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+	payloads, rejected := l.limit(requestPayloads("score", 0, 3))
+	assert.Len(t, payloads, 3)
+	assert.Empty(t, rejected)
+
+	_, err = newCardinalityLimiter(nil, 10, 0, time.Hour, "sample", nil)
+	assert.ErrorContains(t, err, "invalid cardinality action")
+	_, err = newCardinalityLimiter(nil, -1, 0, time.Hour, CARDINALITY_DROP, nil)
+	assert.Error(t, err)
+}
+
+func TestCardinalityLimiter_Drop(t *testing.T) {
+	l, err := newCardinalityLimiter(logging.NewLogger().Named("cardinality"), 3, 5, time.Minute, CARDINALITY_DROP, nil)
+	assert.NoError(t, err)
+	now := time.Unix(1680124991, 0)
+	l.now = func() time.Time { return now }
+
+	payloads, rejected := limitAndRecord(l, requestPayloads("score", 0, 5))
+	assert.Len(t, payloads, 3)
+	assert.Equal(t, map[string]int{limitMetric: 2}, rejected)
+	// Tracked series are still written once the limit is reached
+	payloads, rejected = limitAndRecord(l, requestPayloads("score", 1, 4))
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, map[string]int{limitMetric: 1}, rejected)
+
+	// The global limit applies to all metrics together
+	payloads, rejected = limitAndRecord(l, requestPayloads("latency", 0, 3))
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, map[string]int{limitGlobal: 1}, rejected)
+
+	// A delete marker frees its series
+	payloads, _ = limitAndRecord(l, []PrometheusPayload{{Name: "score", Type: "Gauge", Delete: true, Labels: map[string]string{"app": "web", "request_id": "0"}}})
+	assert.Len(t, payloads, 1)
+	payloads, rejected = limitAndRecord(l, requestPayloads("score", 7, 8))
+	assert.Len(t, payloads, 1)
+	assert.Empty(t, rejected)
+
+	// Series not seen within the window stop counting
+	now = now.Add(2 * time.Minute)
+	payloads, rejected = limitAndRecord(l, requestPayloads("score", 10, 13))
+	assert.Len(t, payloads, 3)
+	assert.Empty(t, rejected)
+}
+
+func TestCardinalityLimiter_Record(t *testing.T) {
+	l, err := newCardinalityLimiter(logging.NewLogger().Named("cardinality"), 2, 0, time.Hour, CARDINALITY_DROP, nil)
+	assert.NoError(t, err)
+	// The new series of a batch count towards the limits before they are recorded
+	payloads, rejected := l.limit(requestPayloads("score", 0, 3))
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, map[string]int{limitMetric: 1}, rejected)
+	// Series not written are not tracked, so they do not use up the limit
+	payloads, rejected = l.limit(requestPayloads("score", 2, 4))
+	assert.Len(t, payloads, 2)
+	assert.Empty(t, rejected)
+	l.record(payloads[:1])
+	assert.Equal(t, 1, l.total)
+	payloads, rejected = l.limit(requestPayloads("score", 0, 2))
+	assert.Len(t, payloads, 1)
+	assert.Equal(t, map[string]int{limitMetric: 1}, rejected)
+}
+
+func TestCardinalityLimiter_Overflow(t *testing.T) {
+	l, err := newCardinalityLimiter(logging.NewLogger().Named("cardinality"), 2, 0, time.Hour, CARDINALITY_OVERFLOW, []string{"app"})
+	assert.NoError(t, err)
+	payloads, rejected := limitAndRecord(l, requestPayloads("score", 0, 4))
+	assert.Len(t, payloads, 4)
+	assert.Equal(t, map[string]int{limitMetric: 2}, rejected)
+	assert.Equal(t, "1", payloads[1].Labels["request_id"])
+	// The overflow series keep the grouping labels and are not tracked
+	assert.Equal(t, map[string]string{"app": "web", overflowLabel: "true"}, payloads[2].Labels)
+	assert.Equal(t, map[string]string{"app": "web", overflowLabel: "true"}, payloads[3].Labels)
+	assert.Equal(t, 2, l.total)
+}
+
+func TestCardinalityLimiter_TopLabels(t *testing.T) {
+	l, err := newCardinalityLimiter(logging.NewLogger().Named("cardinality"), 2, 0, time.Hour, CARDINALITY_DROP, nil)
+	assert.NoError(t, err)
+	limitAndRecord(l, requestPayloads("score", 0, 2))
+	rejected := []map[string]string{{"app": "web", "request_id": "2", "pod": "a"}}
+	assert.Equal(t, []string{"request_id=3", "app=1", "pod=1"}, l.topLabels("score", rejected))
+}
+
+func TestSink_CardinalityLimit(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	ps := &prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 2}
+	ps.metrics = NewMetricsServer(nil, "test_cardinality")
+	ps.limiter, _ = newCardinalityLimiter(logging.NewLogger().Named("cardinality"), 1, 0, time.Hour, CARDINALITY_DROP, nil)
+
+	results := sinkDatums(ps,
+		testDatum{id: "1", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1,"labels":{"request_id":"1"}}`},
+		testDatum{id: "2", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1,"labels":{"request_id":"2"}}`},
+	)
+	// Dropped series are acknowledged
+	assert.True(t, results["1"].Success)
+	assert.True(t, results["2"].Success)
+	assert.Len(t, pgw.requests, 1)
+	assert.Contains(t, pgw.requests, "/metrics/job/ns_none_score/request_id/1")
+	assert.Equal(t, float64(1), testutil.ToFloat64(ps.metrics.seriesTotalRejected.WithLabelValues(limitMetric)))
+
+	// A series that failed to write does not count, the next new series is written
+	pgw.fail = "/metrics/job/ns_none_latency/request_id/1"
+	results = sinkDatums(ps, testDatum{id: "3", value: `{"name":"latency","namespace":"ns","subsystem":"none","type":"Gauge","value":1,"labels":{"request_id":"1"}}`})
+	assert.False(t, results["3"].Success)
+	results = sinkDatums(ps, testDatum{id: "4", value: `{"name":"latency","namespace":"ns","subsystem":"none","type":"Gauge","value":1,"labels":{"request_id":"2"}}`})
+	assert.True(t, results["4"].Success)
+	assert.Contains(t, pgw.requests, "/metrics/job/ns_none_latency/request_id/2")
+}
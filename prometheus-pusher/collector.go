@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type myCollector struct {
+	metric      *prometheus.Desc
+	labelValues []string
+	ts          time.Time
+	kind        string
+	metricType  prometheus.ValueType
+	value       float64
+	count       uint64
+	sum         float64
+	buckets     map[float64]uint64
+	quantiles   map[float64]float64
+	exemplars   []prometheus.Exemplar
+}
+
+func (c *myCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric
+}
+
+func (c *myCollector) Collect(ch chan<- prometheus.Metric) {
+	var metric prometheus.Metric
+	var err error
+	switch c.kind {
+	case metricTypeHistogram:
+		metric, err = prometheus.NewConstHistogram(c.metric, c.count, c.sum, c.buckets, c.labelValues...)
+	case metricTypeSummary:
+		metric, err = prometheus.NewConstSummary(c.metric, c.count, c.sum, c.quantiles, c.labelValues...)
+	default:
+		metric, err = prometheus.NewConstMetric(c.metric, c.metricType, c.value, c.labelValues...)
+	}
+	if err == nil && len(c.exemplars) > 0 {
+		metric, err = prometheus.NewMetricWithExemplars(metric, c.exemplars...)
+	}
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.metric, err)
+		return
+	}
+	if !c.ts.IsZero() {
+		metric = prometheus.NewMetricWithTimestamp(c.ts, metric)
+	}
+	ch <- metric
+}
+
+// collectorSet collects the series of several collectors. It is an unchecked collector, so series of the
+// same metric with different label values can be registered together.
+type collectorSet []*myCollector
+
+func (cs collectorSet) Describe(chan<- *prometheus.Desc) {}
+
+func (cs collectorSet) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range cs {
+		c.Collect(ch)
+	}
+}
+
+// newCollector validates the payload against the semantics of its metric type and builds its collector.
+// seriesLabels become variable labels of the metric.
+func newCollector(payload PrometheusPayload, seriesLabels map[string]string, ignoreTs bool) (*myCollector, error) {
+	labelNames := make([]string, 0, len(seriesLabels))
+	for name := range seriesLabels {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+	c := &myCollector{
+		metric:      prometheus.NewDesc(payload.Name, payload.Help, labelNames, nil),
+		labelValues: make([]string, len(labelNames)),
+		kind:        payload.metricType(),
+	}
+	for i, name := range labelNames {
+		c.labelValues[i] = seriesLabels[name]
+	}
+	if !ignoreTs {
+		c.ts = time.UnixMilli(payload.TimestampMs)
+	}
+	switch c.kind {
+	case metricTypeGauge:
+		c.metricType = prometheus.GaugeValue
+		c.value = payload.Value
+	case metricTypeUntyped:
+		c.metricType = prometheus.UntypedValue
+		c.value = payload.Value
+	case metricTypeCounter:
+		if err := validateCounter(payload); err != nil {
+			return nil, err
+		}
+		c.metricType = prometheus.CounterValue
+		c.value = payload.Value
+	case metricTypeHistogram:
+		buckets, err := parseBuckets(payload)
+		if err != nil {
+			return nil, err
+		}
+		c.count, c.sum, c.buckets = payload.Count, payload.Sum, buckets
+	case metricTypeSummary:
+		quantiles, err := parseQuantiles(payload)
+		if err != nil {
+			return nil, err
+		}
+		c.count, c.sum, c.quantiles = payload.Count, payload.Sum, quantiles
+	default:
+		return nil, fmt.Errorf("unsupported Metrics Type %q", payload.Type)
+	}
+	exemplars, err := parseExemplars(payload)
+	if err != nil {
+		return nil, err
+	}
+	// OpenMetrics only has exemplars on counters and histogram buckets
+	if c.kind == metricTypeCounter || c.kind == metricTypeHistogram {
+		c.exemplars = exemplars
+	}
+	return c, nil
+}
+
+// parseExemplars checks the exemplar labels against the OpenMetrics limits.
+func parseExemplars(payload PrometheusPayload) ([]prometheus.Exemplar, error) {
+	exemplars := make([]prometheus.Exemplar, 0, len(payload.Exemplars))
+	for _, e := range payload.Exemplars {
+		runes := 0
+		for name, value := range e.Labels {
+			if !labelNameRegexp.MatchString(name) || !utf8.ValidString(value) {
+				return nil, fmt.Errorf("metric %s has an invalid exemplar label %q", payload.Name, name)
+			}
+			runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+		}
+		if runes > prometheus.ExemplarMaxRunes {
+			return nil, fmt.Errorf("metric %s exemplar labels have %d runes, exceeding the limit of %d", payload.Name, runes, prometheus.ExemplarMaxRunes)
+		}
+		exemplar := prometheus.Exemplar{Value: e.Value, Labels: e.Labels}
+		if e.TimestampMs != 0 {
+			exemplar.Timestamp = time.UnixMilli(e.TimestampMs)
+		}
+		exemplars = append(exemplars, exemplar)
+	}
+	return exemplars, nil
+}
+
+// validateCounter checks that a counter value is not negative. Other types are not checked.
+func validateCounter(payload PrometheusPayload) error {
+	if payload.metricType() == metricTypeCounter && (payload.Value < 0 || math.IsNaN(payload.Value)) {
+		return fmt.Errorf("counter %s must have a non-negative value, got %v", payload.Name, payload.Value)
+	}
+	return nil
+}
+
+// parseBuckets checks that the bucket counts are cumulative. The +Inf bucket is implied by Count.
+func parseBuckets(payload PrometheusPayload) (map[float64]uint64, error) {
+	buckets := make(map[float64]uint64, len(payload.Buckets))
+	bounds := make([]float64, 0, len(payload.Buckets))
+	for key, count := range payload.Buckets {
+		bound, err := strconv.ParseFloat(key, 64)
+		if err != nil || math.IsNaN(bound) {
+			return nil, fmt.Errorf("histogram %s has an invalid bucket bound %q", payload.Name, key)
+		}
+		if math.IsInf(bound, 1) {
+			if count != payload.Count {
+				return nil, fmt.Errorf("histogram %s +Inf bucket count %d does not match count %d", payload.Name, count, payload.Count)
+			}
+			continue
+		}
+		buckets[bound] = count
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+	var previous uint64
+	for _, bound := range bounds {
+		count := buckets[bound]
+		if count < previous {
+			return nil, fmt.Errorf("histogram %s bucket counts must be cumulative, bucket %v has %d after %d", payload.Name, bound, count, previous)
+		}
+		previous = count
+	}
+	if previous > payload.Count {
+		return nil, fmt.Errorf("histogram %s bucket count %d exceeds count %d", payload.Name, previous, payload.Count)
+	}
+	return buckets, nil
+}
+
+func parseQuantiles(payload PrometheusPayload) (map[float64]float64, error) {
+	quantiles := make(map[float64]float64, len(payload.Quantiles))
+	for key, value := range payload.Quantiles {
+		quantile, err := strconv.ParseFloat(key, 64)
+		if err != nil || quantile < 0 || quantile > 1 {
+			return nil, fmt.Errorf("summary %s has an invalid quantile %q", payload.Name, key)
+		}
+		quantiles[quantile] = value
+	}
+	return quantiles, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func gatherCollector(t *testing.T, c prometheus.Collector) *io_prometheus_client.MetricFamily {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(c))
+	mfs, err := registry.Gather()
+	assert.NoError(t, err)
+	assert.Len(t, mfs, 1)
+	return mfs[0]
+}
+
+func TestNewCollector_Types(t *testing.T) {
+	payloadMsg := `[{"name":"gauge_metric","type":"Gauge","value":0.5},
+		{"name":"counter_metric","type":"counter","value":12},
+		{"name":"untyped_metric","type":"Untyped","value":-3},
+		{"name":"latency_seconds","type":"Histogram","count":10,"sum":4.2,"buckets":{"0.1":2,"0.5":6,"1":9,"+Inf":10}},
+		{"name":"rpc_seconds","type":"Summary","count":7,"sum":1.4,"quantiles":{"0.5":0.2,"0.99":0.9}}]`
+	var pls []PrometheusPayload
+	assert.NoError(t, json.Unmarshal([]byte(payloadMsg), &pls))
+
+	c, err := newCollector(pls[0], nil, true)
+	assert.NoError(t, err)
+	mf := gatherCollector(t, c)
+	assert.Equal(t, io_prometheus_client.MetricType_GAUGE, mf.GetType())
+	assert.Equal(t, 0.5, mf.GetMetric()[0].GetGauge().GetValue())
+
+	c, err = newCollector(pls[1], nil, true)
+	assert.NoError(t, err)
+	mf = gatherCollector(t, c)
+	assert.Equal(t, io_prometheus_client.MetricType_COUNTER, mf.GetType())
+	assert.Equal(t, float64(12), mf.GetMetric()[0].GetCounter().GetValue())
+
+	c, err = newCollector(pls[2], nil, true)
+	assert.NoError(t, err)
+	mf = gatherCollector(t, c)
+	assert.Equal(t, io_prometheus_client.MetricType_UNTYPED, mf.GetType())
+	assert.Equal(t, float64(-3), mf.GetMetric()[0].GetUntyped().GetValue())
+
+	c, err = newCollector(pls[3], nil, true)
+	assert.NoError(t, err)
+	mf = gatherCollector(t, c)
+	assert.Equal(t, io_prometheus_client.MetricType_HISTOGRAM, mf.GetType())
+	histogram := mf.GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(10), histogram.GetSampleCount())
+	assert.Equal(t, 4.2, histogram.GetSampleSum())
+	assert.Len(t, histogram.GetBucket(), 3)
+	assert.Equal(t, 0.1, histogram.GetBucket()[0].GetUpperBound())
+	assert.Equal(t, uint64(2), histogram.GetBucket()[0].GetCumulativeCount())
+	assert.Equal(t, uint64(9), histogram.GetBucket()[2].GetCumulativeCount())
+
+	c, err = newCollector(pls[4], nil, false)
+	assert.NoError(t, err)
+	mf = gatherCollector(t, c)
+	assert.Equal(t, io_prometheus_client.MetricType_SUMMARY, mf.GetType())
+	summary := mf.GetMetric()[0].GetSummary()
+	assert.Equal(t, uint64(7), summary.GetSampleCount())
+	assert.Equal(t, 1.4, summary.GetSampleSum())
+	assert.Len(t, summary.GetQuantile(), 2)
+	assert.Equal(t, int64(0), mf.GetMetric()[0].GetTimestampMs())
+}
+
+func TestNewCollector_Invalid(t *testing.T) {
+	_, err := newCollector(PrometheusPayload{Name: "m", Type: "Info"}, nil, true)
+	assert.ErrorContains(t, err, "unsupported Metrics Type")
+
+	_, err = newCollector(PrometheusPayload{Name: "m", Type: "Counter", Value: -1}, nil, true)
+	assert.ErrorContains(t, err, "non-negative")
+
+	_, err = newCollector(PrometheusPayload{Name: "m", Type: "Histogram", Count: 5, Buckets: map[string]uint64{"0.1": 3, "0.5": 2}}, nil, true)
+	assert.ErrorContains(t, err, "cumulative")
+
+	_, err = newCollector(PrometheusPayload{Name: "m", Type: "Histogram", Count: 2, Buckets: map[string]uint64{"0.1": 3}}, nil, true)
+	assert.ErrorContains(t, err, "exceeds count")
+
+	_, err = newCollector(PrometheusPayload{Name: "m", Type: "Histogram", Count: 2, Buckets: map[string]uint64{"+Inf": 3}}, nil, true)
+	assert.ErrorContains(t, err, "+Inf")
+
+	_, err = newCollector(PrometheusPayload{Name: "m", Type: "Histogram", Buckets: map[string]uint64{"le": 1}}, nil, true)
+	assert.ErrorContains(t, err, "invalid bucket bound")
+
+	_, err = newCollector(PrometheusPayload{Name: "m", Type: "Summary", Quantiles: map[string]float64{"1.5": 1}}, nil, true)
+	assert.ErrorContains(t, err, "invalid quantile")
+}
+
+func TestNewCollector_Exemplars(t *testing.T) {
+	payload := PrometheusPayload{Name: "anomalies_total", Type: "Counter", Value: 3,
+		Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "abc"}, Value: 1, TimestampMs: 1680124991883}}}
+	c, err := newCollector(payload, nil, true)
+	assert.NoError(t, err)
+	e := gatherCollector(t, c).GetMetric()[0].GetCounter().GetExemplar()
+	assert.Equal(t, "trace_id", e.GetLabel()[0].GetName())
+	assert.Equal(t, "abc", e.GetLabel()[0].GetValue())
+	assert.Equal(t, int64(1680124991883), e.GetTimestamp().AsTime().UnixMilli())
+
+	payload = PrometheusPayload{Name: "latency_seconds", Type: "Histogram", Count: 2, Sum: 0.7, Buckets: map[string]uint64{"0.5": 1, "1": 2},
+		Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "def"}, Value: 0.6}}}
+	c, err = newCollector(payload, nil, true)
+	assert.NoError(t, err)
+	buckets := gatherCollector(t, c).GetMetric()[0].GetHistogram().GetBucket()
+	assert.Nil(t, buckets[0].GetExemplar())
+	assert.Equal(t, 0.6, buckets[1].GetExemplar().GetValue())
+
+	// Gauges cannot carry exemplars in the exposition format, they are left out
+	payload = PrometheusPayload{Name: "anomaly_score", Type: "Gauge", Value: 0.9,
+		Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "abc"}, Value: 0.9}}}
+	c, err = newCollector(payload, nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.9, gatherCollector(t, c).GetMetric()[0].GetGauge().GetValue())
+
+	payload.Exemplars = []Exemplar{{Labels: map[string]string{"trace id": "abc"}}}
+	_, err = newCollector(payload, nil, true)
+	assert.ErrorContains(t, err, "exemplar")
+}
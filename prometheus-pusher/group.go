@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// pushGroup is the set of payloads pushed to the Pushgateway in one request, identified by
+// the job name and the grouping labels.
+type pushGroup struct {
+	key      string
+	jobName  string
+	grouping map[string]string
+	payloads []PrometheusPayload
+	// series is the index in payloads of each series, keyed by name and series labels
+	series map[string]int
+	// delete is set when the group is deleted before its payloads are pushed
+	delete bool
+	// datums are the index of the datums with payloads in the group, including replaced payloads and delete markers
+	datums []int
+}
+
+func jobName(payload PrometheusPayload) string {
+	return fmt.Sprintf("%s_%s_%s", payload.Namespace, payload.Subsystem, payload.Name)
+}
+
+func groupKey(job string, grouping map[string]string) string {
+	keys := make([]string, 0, len(grouping))
+	for key := range grouping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(job)
+	for _, key := range keys {
+		sb.WriteString("\xff")
+		sb.WriteString(key)
+		sb.WriteString("\xff")
+		sb.WriteString(grouping[key])
+	}
+	return sb.String()
+}
+
+// groupingConfig selects which payload labels form the Pushgateway grouping key and which become series labels.
+// Labels in neither list are part of the grouping key, unless groupingLabels is set, then they are series labels.
+// The jobNamer renders the job name of the groups.
+type groupingConfig struct {
+	groupingLabels []string
+	seriesLabels   []string
+	jobNamer       *jobNamer
+}
+
+func (gc groupingConfig) validate() error {
+	for _, name := range gc.groupingLabels {
+		if slices.Contains(gc.seriesLabels, name) {
+			return fmt.Errorf("label %q is both a grouping and a series label", name)
+		}
+	}
+	return nil
+}
+
+// split returns the grouping labels and the series labels of the payload labels.
+func (gc groupingConfig) split(labels map[string]string) (map[string]string, map[string]string) {
+	grouping := make(map[string]string)
+	series := make(map[string]string)
+	for key, value := range labels {
+		switch {
+		case slices.Contains(gc.groupingLabels, key):
+			grouping[key] = value
+		case slices.Contains(gc.seriesLabels, key), len(gc.groupingLabels) > 0:
+			series[key] = value
+		default:
+			grouping[key] = value
+		}
+	}
+	return grouping, series
+}
+
+// groupPayloads groups the payloads by job name and grouping labels, in order of first appearance.
+// A payload with the delete marker marks its group for deletion.
+// A group holds one sample per series, so a later payload replaces an earlier one with the same name and series labels.
+func groupPayloads(payloads []PrometheusPayload, gc groupingConfig) []*pushGroup {
+	var groups []*pushGroup
+	byKey := make(map[string]*pushGroup)
+	for _, payload := range payloads {
+		job := gc.jobNamer.name(payload)
+		grouping, series := gc.split(payload.Labels)
+		key := groupKey(job, grouping)
+		group, ok := byKey[key]
+		if !ok {
+			group = &pushGroup{key: key, jobName: job, grouping: grouping, series: make(map[string]int)}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.datums = append(group.datums, payload.datums()...)
+		if payload.Delete {
+			// Payloads before the delete marker are discarded, the ones after it are pushed to the emptied group
+			group.delete = true
+			group.payloads = nil
+			group.series = make(map[string]int)
+			continue
+		}
+		seriesKey := groupKey(payload.Name, series)
+		if i, ok := group.series[seriesKey]; ok {
+			group.payloads[i] = payload
+			continue
+		}
+		group.series[seriesKey] = len(group.payloads)
+		group.payloads = append(group.payloads, payload)
+	}
+	return groups
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupPayloads(t *testing.T) {
+	payloads := []PrometheusPayload{
+		{Namespace: "ns", Subsystem: "none", Name: "cpu_anomaly", Type: "Gauge", Value: 1, Labels: map[string]string{"app": "a", "pod": "1"}},
+		{Namespace: "ns", Subsystem: "none", Name: "cpu_anomaly", Type: "Gauge", Value: 2, Labels: map[string]string{"pod": "1", "app": "a"}},
+		{Namespace: "ns", Subsystem: "none", Name: "cpu_anomaly", Type: "Gauge", Value: 3, Labels: map[string]string{"app": "b"}},
+		{Namespace: "ns", Subsystem: "none", Name: "mem_anomaly", Type: "Gauge", Value: 4, Labels: map[string]string{"app": "a"}},
+	}
+	groups := groupPayloads(payloads, groupingConfig{})
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "ns_none_cpu_anomaly", groups[0].jobName)
+	// The later sample for the same series replaces the earlier one
+	assert.Len(t, groups[0].payloads, 1)
+	assert.Equal(t, float64(2), groups[0].payloads[0].Value)
+	assert.Equal(t, map[string]string{"app": "b"}, groups[1].grouping)
+	assert.Equal(t, "ns_none_mem_anomaly", groups[2].jobName)
+	assert.NotEqual(t, groupKey("job", map[string]string{"a": "b=c"}), groupKey("job", map[string]string{"a=b": "c"}))
+}
+
+// fakePushgateway records the metric families pushed to each path.
+type fakePushgateway struct {
+	mu       sync.Mutex
+	requests map[string][]*io_prometheus_client.MetricFamily
+	methods  []string
+	fail     string
+}
+
+func newFakePushgateway(t *testing.T) (*fakePushgateway, *httptest.Server) {
+	pgw := &fakePushgateway{requests: make(map[string][]*io_prometheus_client.MetricFamily)}
+	srv := httptest.NewServer(pgw)
+	t.Cleanup(srv.Close)
+	t.Setenv("PROMETHEUS_SERVER", srv.URL)
+	return pgw, srv
+}
+
+func (pgw *fakePushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pgw.mu.Lock()
+	pgw.methods = append(pgw.methods, r.Method+" "+r.URL.Path)
+	pgw.mu.Unlock()
+	if r.URL.Path == pgw.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+	var mfs []*io_prometheus_client.MetricFamily
+	for {
+		mf := &io_prometheus_client.MetricFamily{}
+		if err := dec.Decode(mf); err != nil {
+			break
+		}
+		mfs = append(mfs, mf)
+	}
+	pgw.mu.Lock()
+	pgw.requests[r.URL.Path] = append(pgw.requests[r.URL.Path], mfs...)
+	pgw.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestPushGroups(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	pgw.fail = "/metrics/job/ns_none_fail/app/a"
+	requests := pgw.requests
+
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 3}
+	ps.metrics = NewMetricsServer(nil, "test_group")
+	payloads := []PrometheusPayload{
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 1, Labels: map[string]string{"app": "a"}},
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 2, Labels: map[string]string{"app": "b"}},
+		{Namespace: "ns", Subsystem: "none", Name: "fail", Type: "Gauge", Value: 3, Labels: map[string]string{"app": "a"}},
+	}
+	err := ps.push(context.Background(), payloads)
+	assert.ErrorContains(t, err, "failed to push 1 of 3 groups")
+	assert.Len(t, requests, 2)
+	assert.Len(t, requests["/metrics/job/ns_none_score/app/a"], 1)
+	assert.Len(t, requests["/metrics/job/ns_none_score/app/b"], 1)
+	assert.Equal(t, float64(2), testutil.ToFloat64(ps.metrics.groupsTotalSuccess))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ps.metrics.groupsTotalFailed))
+}
+
+func TestGroupingConfig_Split(t *testing.T) {
+	labels := map[string]string{"app": "a", "namespace": "ns", "pod": "p1"}
+	grouping, series := groupingConfig{}.split(labels)
+	assert.Equal(t, labels, grouping)
+	assert.Empty(t, series)
+
+	grouping, series = groupingConfig{groupingLabels: []string{"app", "namespace"}}.split(labels)
+	assert.Equal(t, map[string]string{"app": "a", "namespace": "ns"}, grouping)
+	assert.Equal(t, map[string]string{"pod": "p1"}, series)
+
+	grouping, series = groupingConfig{seriesLabels: []string{"pod"}}.split(labels)
+	assert.Equal(t, map[string]string{"app": "a", "namespace": "ns"}, grouping)
+	assert.Equal(t, map[string]string{"pod": "p1"}, series)
+
+	assert.Error(t, groupingConfig{groupingLabels: []string{"app"}, seriesLabels: []string{"app"}}.validate())
+}
+
+func TestPushGroups_SeriesLabels(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1,
+		grouping: groupingConfig{groupingLabels: []string{"app"}}}
+	ps.metrics = NewMetricsServer(nil, "test_series_labels")
+	payloads := []PrometheusPayload{
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 1, Labels: map[string]string{"app": "a", "pod": "p1"}},
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 2, Labels: map[string]string{"app": "a", "pod": "p2"}},
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 3, Labels: map[string]string{"app": "a", "pod": "p1"}},
+	}
+	groups := groupPayloads(payloads, ps.grouping)
+	assert.Len(t, groups, 1)
+	assert.Len(t, groups[0].payloads, 2)
+
+	assert.NoError(t, ps.push(context.Background(), payloads))
+	assert.Len(t, pgw.requests, 1)
+	mfs := pgw.requests["/metrics/job/ns_none_score/app/a"]
+	assert.Len(t, mfs, 1)
+	values := make(map[string]float64)
+	for _, m := range mfs[0].GetMetric() {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "pod" {
+				values[l.GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	assert.Equal(t, map[string]float64{"p1": 3, "p2": 2}, values)
+}
+
+func TestGroupPayloads_Delete(t *testing.T) {
+	payloads := []PrometheusPayload{
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 1, Labels: map[string]string{"app": "a"}},
+		{Namespace: "ns", Subsystem: "none", Name: "score", Delete: true, Labels: map[string]string{"app": "a"}},
+		{Namespace: "ns", Subsystem: "none", Name: "score", Delete: true, Labels: map[string]string{"app": "b"}},
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 2, Labels: map[string]string{"app": "b"}},
+	}
+	groups := groupPayloads(payloads, groupingConfig{})
+	assert.Len(t, groups, 2)
+	assert.True(t, groups[0].delete)
+	assert.Empty(t, groups[0].payloads)
+	assert.True(t, groups[1].delete)
+	assert.Len(t, groups[1].payloads, 1)
+}
+
+func TestPushGroups_Methods(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1, pushMethod: PUSH_METHOD_ADD}
+	ps.metrics = NewMetricsServer(nil, "test_methods")
+	ps.janitor = newGroupJanitor(ps.logger, time.Minute, time.Minute, ps.deleteGroup)
+	payloads := []PrometheusPayload{
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 1, Labels: map[string]string{"app": "a"}},
+		{Namespace: "ns", Subsystem: "none", Name: "score", Delete: true, Labels: map[string]string{"app": "b"}},
+		{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 2, Labels: map[string]string{"app": "b"}},
+	}
+	assert.NoError(t, ps.push(context.Background(), payloads))
+	assert.Equal(t, []string{
+		"POST /metrics/job/ns_none_score/app/a",
+		"DELETE /metrics/job/ns_none_score/app/b",
+		"POST /metrics/job/ns_none_score/app/b",
+	}, pgw.methods)
+	assert.Len(t, ps.janitor.groups, 2)
+	assert.Equal(t, float64(1), testutil.ToFloat64(ps.metrics.groupsTotalDeleted))
+
+	pgw.methods = nil
+	assert.NoError(t, ps.push(context.Background(), []PrometheusPayload{{Namespace: "ns", Subsystem: "none", Name: "score", Delete: true, Labels: map[string]string{"app": "a"}}}))
+	assert.Equal(t, []string{"DELETE /metrics/job/ns_none_score/app/a"}, pgw.methods)
+	assert.Len(t, ps.janitor.groups, 1)
+
+	ps.pushMethod = PUSH_METHOD_PUSH
+	pgw.methods = nil
+	assert.NoError(t, ps.push(context.Background(), payloads[:1]))
+	assert.Equal(t, []string{"PUT /metrics/job/ns_none_score/app/a"}, pgw.methods)
+}
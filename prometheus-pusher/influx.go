@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Timestamp precisions of the InfluxDB write API selected with -influxPrecision
+const (
+	INFLUX_PRECISION_NS = "ns"
+	INFLUX_PRECISION_US = "us"
+	INFLUX_PRECISION_MS = "ms"
+	INFLUX_PRECISION_S  = "s"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	influxKeyEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+// influxPoint is a line of the InfluxDB line protocol with the datums it was converted from.
+type influxPoint struct {
+	line   string
+	datums []int
+}
+
+// influxTimestamp converts a millisecond timestamp to the write precision.
+func influxTimestamp(timestampMs int64, precision string) int64 {
+	switch precision {
+	case INFLUX_PRECISION_NS:
+		return timestampMs * int64(time.Millisecond)
+	case INFLUX_PRECISION_US:
+		return timestampMs * int64(time.Millisecond/time.Microsecond)
+	case INFLUX_PRECISION_S:
+		return timestampMs / 1000
+	}
+	return timestampMs
+}
+
+// influxLine converts the payload into a line with the payload name as measurement and the labels as tags.
+// Gauges, counters and untyped metrics have a value field, histograms and summaries count, sum and a field
+// per bucket or quantile, as the Telegraf prometheus input does. Without a timestamp the server time is used.
+func influxLine(payload PrometheusPayload, precision string) (string, error) {
+	type field struct {
+		key   string
+		value float64
+	}
+	var fields []field
+	switch payload.metricType() {
+	case metricTypeGauge, metricTypeUntyped, metricTypeCounter:
+		if err := validateCounter(payload); err != nil {
+			return "", err
+		}
+		fields = append(fields, field{"value", payload.Value})
+	case metricTypeHistogram:
+		buckets, err := parseBuckets(payload)
+		if err != nil {
+			return "", err
+		}
+		bounds := make([]float64, 0, len(buckets))
+		for bound := range buckets {
+			if !math.IsInf(bound, 1) {
+				bounds = append(bounds, bound)
+			}
+		}
+		sort.Float64s(bounds)
+		fields = append(fields, field{"count", float64(payload.Count)}, field{"sum", payload.Sum})
+		for _, bound := range bounds {
+			fields = append(fields, field{strconv.FormatFloat(bound, 'g', -1, 64), float64(buckets[bound])})
+		}
+		fields = append(fields, field{"+Inf", float64(payload.Count)})
+	case metricTypeSummary:
+		quantiles, err := parseQuantiles(payload)
+		if err != nil {
+			return "", err
+		}
+		keys := make([]float64, 0, len(quantiles))
+		for quantile := range quantiles {
+			keys = append(keys, quantile)
+		}
+		sort.Float64s(keys)
+		fields = append(fields, field{"count", float64(payload.Count)}, field{"sum", payload.Sum})
+		for _, quantile := range keys {
+			fields = append(fields, field{strconv.FormatFloat(quantile, 'g', -1, 64), quantiles[quantile]})
+		}
+	default:
+		return "", fmt.Errorf("unsupported Metrics Type %q", payload.Type)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(influxMeasurementEscaper.Replace(payload.Name))
+	names := make([]string, 0, len(payload.Labels))
+	for name := range payload.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := payload.Labels[name]
+		// Tags with empty values are not allowed
+		if value == "" {
+			continue
+		}
+		if strings.ContainsAny(value, "\n\r") {
+			return "", fmt.Errorf("label %s of %s contains a newline, which InfluxDB line protocol does not support", name, payload.Name)
+		}
+		sb.WriteString(",")
+		sb.WriteString(influxKeyEscaper.Replace(name))
+		sb.WriteString("=")
+		sb.WriteString(influxKeyEscaper.Replace(value))
+	}
+	for i, f := range fields {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			return "", fmt.Errorf("field %s of %s is %v, which InfluxDB line protocol does not support", f.key, payload.Name, f.value)
+		}
+		if i == 0 {
+			sb.WriteString(" ")
+		} else {
+			sb.WriteString(",")
+		}
+		sb.WriteString(influxKeyEscaper.Replace(f.key))
+		sb.WriteString("=")
+		sb.WriteString(strconv.FormatFloat(f.value, 'g', -1, 64))
+	}
+	if payload.TimestampMs != 0 {
+		sb.WriteString(" ")
+		sb.WriteString(strconv.FormatInt(influxTimestamp(payload.TimestampMs, precision), 10))
+	}
+	return sb.String(), nil
+}
+
+// influxWriter writes payloads to the InfluxDB v2 write API, PROMETHEUS_SERVER is the base URL of the server.
+type influxWriter struct {
+	logger     *zap.SugaredLogger
+	client     *http.Client
+	url        string
+	org        string
+	bucket     string
+	token      string
+	precision  string
+	batchSize  int
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// writeURL returns the /api/v2/write URL with the org, bucket and precision parameters.
+func (iw *influxWriter) writeURL() (string, error) {
+	u, err := url.Parse(iw.url)
+	if err != nil {
+		return "", err
+	}
+	u = u.JoinPath("/api/v2/write")
+	query := u.Query()
+	if iw.org != "" {
+		query.Set("org", iw.org)
+	}
+	query.Set("bucket", iw.bucket)
+	query.Set("precision", iw.precision)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// write sends the payloads in batches of batchSize lines. A failed batch only fails the datums of its payloads,
+// the other batches are still sent.
+func (iw *influxWriter) write(ctx context.Context, payloads []PrometheusPayload) error {
+	var points []influxPoint
+	var firstErr error
+	werr := &writeError{}
+	for _, payload := range payloads {
+		line, err := influxLine(payload, iw.precision)
+		if err != nil {
+			iw.logger.Errorw("Invalid metric", zap.Any("payload", payload), zap.Error(err))
+			for _, datum := range payload.datums() {
+				werr.fail(datum, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		points = append(points, influxPoint{line: line, datums: payload.datums()})
+	}
+	writeURL, err := iw.writeURL()
+	if err != nil {
+		return err
+	}
+	batchSize := iw.batchSize
+	if batchSize < 1 {
+		batchSize = len(points)
+	}
+	failedBatches := 0
+	for start := 0; start < len(points); start += batchSize {
+		end := min(start+batchSize, len(points))
+		var body bytes.Buffer
+		for _, point := range points[start:end] {
+			body.WriteString(point.line)
+			body.WriteString("\n")
+		}
+		if err := iw.send(ctx, writeURL, body.Bytes()); err != nil {
+			iw.logger.Errorw("InfluxDB write failed", zap.Int("lines", end-start), zap.Error(err))
+			failedBatches++
+			if firstErr == nil {
+				firstErr = err
+			}
+			for _, point := range points[start:end] {
+				for _, datum := range point.datums {
+					werr.fail(datum, err)
+				}
+			}
+			continue
+		}
+		iw.logger.Debugf("InfluxDB write sent %d lines", end-start)
+	}
+	if len(werr.datums) > 0 {
+		werr.err = fmt.Errorf("InfluxDB write failed for %d datums in %d batches: %w", len(werr.datums), failedBatches, firstErr)
+		return werr
+	}
+	return nil
+}
+
+// send posts one batch of lines, retrying 5xx and 429 responses with exponential backoff.
+func (iw *influxWriter) send(ctx context.Context, writeURL string, body []byte) error {
+	return retryRequest(ctx, iw.logger, "InfluxDB write", iw.maxRetries, iw.minBackoff, iw.maxBackoff, func() (time.Duration, bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, writeURL, bytes.NewReader(body))
+		if err != nil {
+			return 0, false, err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		req.Header.Set("User-Agent", "numaflow-prometheus-sink")
+		if iw.token != "" {
+			req.Header.Set("Authorization", "Token "+iw.token)
+		}
+		res, err := iw.client.Do(req)
+		if err != nil {
+			return 0, true, err
+		}
+		defer res.Body.Close()
+		return responseError(res, "InfluxDB write", func(status int) bool { return status == 429 || status/100 == 5 })
+	})
+}
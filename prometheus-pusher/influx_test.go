@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfluxLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload PrometheusPayload
+		line    string
+	}{
+		{
+			name:    "gauge",
+			payload: PrometheusPayload{Name: "anomaly_score", Type: "Gauge", Value: 0.49, TimestampMs: 1680124991883, Labels: map[string]string{"namespace": "ns", "app": "web"}},
+			line:    "anomaly_score,app=web,namespace=ns value=0.49 1680124991883",
+		},
+		{
+			name:    "escaping",
+			payload: PrometheusPayload{Name: "a b,c", Type: "Counter", Value: 1e6, Labels: map[string]string{"k=1 ,": "v=1 ,x", "empty": ""}},
+			line:    `a\ b\,c,k\=1\ \,=v\=1\ \,x value=1e+06`,
+		},
+		{
+			name:    "backslash",
+			payload: PrometheusPayload{Name: "a", Type: "Untyped", Value: -2, Labels: map[string]string{"path": `C:\dir`}},
+			line:    `a,path=C:\dir value=-2`,
+		},
+		{
+			name:    "histogram",
+			payload: PrometheusPayload{Name: "latency_seconds", Type: "Histogram", Count: 10, Sum: 4.2, Buckets: map[string]uint64{"1": 9, "0.1": 2}},
+			line:    "latency_seconds count=10,sum=4.2,0.1=2,1=9,+Inf=10",
+		},
+		{
+			name:    "summary",
+			payload: PrometheusPayload{Name: "rpc_seconds", Type: "Summary", Count: 7, Sum: 1.4, Quantiles: map[string]float64{"0.99": 0.9, "0.5": 0.2}},
+			line:    "rpc_seconds count=7,sum=1.4,0.5=0.2,0.99=0.9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := influxLine(tt.payload, INFLUX_PRECISION_MS)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.line, line)
+		})
+	}
+
+	_, err := influxLine(PrometheusPayload{Name: "a", Type: "Gauge", Value: 1, Labels: map[string]string{"l": "x\ny"}}, INFLUX_PRECISION_MS)
+	assert.ErrorContains(t, err, "newline")
+	_, err = influxLine(PrometheusPayload{Name: "a", Type: "Counter", Value: -1}, INFLUX_PRECISION_MS)
+	assert.ErrorContains(t, err, "non-negative")
+}
+
+func TestInfluxTimestamp(t *testing.T) {
+	assert.Equal(t, int64(1680124991883000000), influxTimestamp(1680124991883, INFLUX_PRECISION_NS))
+	assert.Equal(t, int64(1680124991883000), influxTimestamp(1680124991883, INFLUX_PRECISION_US))
+	assert.Equal(t, int64(1680124991883), influxTimestamp(1680124991883, INFLUX_PRECISION_MS))
+	assert.Equal(t, int64(1680124991), influxTimestamp(1680124991883, INFLUX_PRECISION_S))
+}
+
+type influxReceiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	statuses []int
+}
+
+func (ir *influxReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	if len(ir.statuses) > 0 {
+		status := ir.statuses[0]
+		ir.statuses = ir.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"code":"invalid","message":"failed"}`))
+			return
+		}
+	}
+	body, _ := io.ReadAll(r.Body)
+	ir.requests = append(ir.requests, r)
+	ir.bodies = append(ir.bodies, string(body))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newTestInfluxWriter(url string) *influxWriter {
+	return &influxWriter{
+		logger:     logging.NewLogger().Named("influx"),
+		client:     http.DefaultClient,
+		url:        url,
+		org:        "numaproj",
+		bucket:     "anomalies",
+		token:      "secret",
+		precision:  INFLUX_PRECISION_S,
+		batchSize:  2,
+		maxRetries: 2,
+		minBackoff: time.Millisecond,
+		maxBackoff: time.Millisecond,
+	}
+}
+
+func TestInfluxWriter_Write(t *testing.T) {
+	receiver := &influxReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	iw := newTestInfluxWriter(server.URL)
+	payloads := []PrometheusPayload{
+		{Name: "anomaly_score", Type: "Gauge", Value: 0.49, TimestampMs: 1680124991883, Labels: map[string]string{"app": "web"}},
+		{Name: "anomaly_score", Type: "Gauge", Value: 0.2, TimestampMs: 1680124991883, Labels: map[string]string{"app": "api"}},
+		{Name: "requests_total", Type: "Counter", Value: 7},
+	}
+	assert.NoError(t, iw.write(context.Background(), payloads))
+
+	assert.Len(t, receiver.requests, 2)
+	req := receiver.requests[0]
+	assert.Equal(t, "/api/v2/write", req.URL.Path)
+	assert.Equal(t, url.Values{"org": {"numaproj"}, "bucket": {"anomalies"}, "precision": {"s"}}, req.URL.Query())
+	assert.Equal(t, "Token secret", req.Header.Get("Authorization"))
+	assert.True(t, strings.HasPrefix(req.Header.Get("Content-Type"), "text/plain"))
+	assert.Equal(t, "anomaly_score,app=web value=0.49 1680124991\nanomaly_score,app=api value=0.2 1680124991\n", receiver.bodies[0])
+	assert.Equal(t, "requests_total value=7\n", receiver.bodies[1])
+}
+
+func TestInfluxWriter_Retry(t *testing.T) {
+	receiver := &influxReceiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	iw := newTestInfluxWriter(server.URL)
+	payloads := []PrometheusPayload{{Name: "anomaly_score", Type: "Gauge", Value: 1}}
+	assert.NoError(t, iw.write(context.Background(), payloads))
+	assert.Len(t, receiver.requests, 1)
+
+	receiver.statuses = []int{http.StatusBadRequest}
+	assert.ErrorContains(t, iw.write(context.Background(), payloads), "status code 400")
+	assert.Len(t, receiver.requests, 1)
+}
+
+func TestInfluxWriter_PartialFailure(t *testing.T) {
+	receiver := &influxReceiver{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	iw := newTestInfluxWriter(server.URL)
+	payloads := []PrometheusPayload{
+		{Name: "a", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "b", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "c", Type: "Gauge", Value: 1, datum: 1},
+		{Name: "d", Type: "Counter", Value: -1, datum: 2},
+	}
+	err := iw.write(context.Background(), payloads)
+	var werr *writeError
+	if assert.ErrorAs(t, err, &werr) {
+		assert.Len(t, werr.datums, 2)
+		assert.Contains(t, werr.datums, 0)
+		assert.Contains(t, werr.datums, 2)
+	}
+	assert.Equal(t, []string{"c value=1\n"}, receiver.bodies)
+}
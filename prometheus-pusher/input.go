@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Input formats of the datums selected with -inputFormat
+const (
+	INPUT_JSON        = "json"
+	INPUT_PROMETHEUS  = "prometheus"
+	INPUT_OPENMETRICS = "openmetrics"
+	INPUT_AUTO        = "auto"
+)
+
+const openMetricsEOF = "# EOF"
+
+// inputFormat returns the format of a datum. In auto mode it is taken from the Content-Type header of the datum,
+// or sniffed from the body when the header is missing or unknown.
+func inputFormat(format string, headers map[string]string, body []byte) string {
+	switch format {
+	case "":
+		return INPUT_JSON
+	case INPUT_AUTO:
+	default:
+		return format
+	}
+	for key, value := range headers {
+		if !strings.EqualFold(key, "Content-Type") {
+			continue
+		}
+		mediaType, _, _ := mime.ParseMediaType(value)
+		switch mediaType {
+		case "application/json":
+			return INPUT_JSON
+		case "application/openmetrics-text":
+			return INPUT_OPENMETRICS
+		case "text/plain":
+			return INPUT_PROMETHEUS
+		}
+	}
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '['):
+		return INPUT_JSON
+	case bytes.HasSuffix(trimmed, []byte(openMetricsEOF)):
+		return INPUT_OPENMETRICS
+	}
+	return INPUT_PROMETHEUS
+}
+
+var metricTypeNames = map[dto.MetricType]string{
+	dto.MetricType_COUNTER:   "Counter",
+	dto.MetricType_GAUGE:     "Gauge",
+	dto.MetricType_UNTYPED:   "Untyped",
+	dto.MetricType_HISTOGRAM: "Histogram",
+	dto.MetricType_SUMMARY:   "Summary",
+}
+
+// parseExposition parses a Prometheus text exposition body into payloads.
+func parseExposition(body []byte) ([]PrometheusPayload, error) {
+	payloads, err := parseText(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Prometheus text input: %w", err)
+	}
+	return payloads, nil
+}
+
+// parseText parses a body in the text exposition format into payloads, ordered by metric name.
+func parseText(body []byte) ([]PrometheusPayload, error) {
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		body = append(body[:len(body):len(body)], '\n')
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var payloads []PrometheusPayload
+	for _, name := range names {
+		payloads = append(payloads, familyPayloads(families[name])...)
+	}
+	return payloads, nil
+}
+
+// familyPayloads converts each metric of the family to a payload.
+func familyPayloads(family *dto.MetricFamily) []PrometheusPayload {
+	payloads := make([]PrometheusPayload, 0, len(family.GetMetric()))
+	for _, metric := range family.GetMetric() {
+		payload := PrometheusPayload{
+			Name:        family.GetName(),
+			Help:        family.GetHelp(),
+			Type:        metricTypeNames[family.GetType()],
+			TimestampMs: metric.GetTimestampMs(),
+		}
+		if len(metric.GetLabel()) > 0 {
+			payload.Labels = make(map[string]string, len(metric.GetLabel()))
+			for _, label := range metric.GetLabel() {
+				payload.Labels[label.GetName()] = label.GetValue()
+			}
+		}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			payload.Value = metric.GetCounter().GetValue()
+		case dto.MetricType_GAUGE:
+			payload.Value = metric.GetGauge().GetValue()
+		case dto.MetricType_UNTYPED:
+			payload.Value = metric.GetUntyped().GetValue()
+		case dto.MetricType_HISTOGRAM:
+			histogram := metric.GetHistogram()
+			payload.Count, payload.Sum = histogram.GetSampleCount(), histogram.GetSampleSum()
+			payload.Buckets = make(map[string]uint64, len(histogram.GetBucket()))
+			for _, bucket := range histogram.GetBucket() {
+				// The +Inf bucket is implied by the count
+				if !math.IsInf(bucket.GetUpperBound(), 1) {
+					payload.Buckets[strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)] = bucket.GetCumulativeCount()
+				}
+			}
+		case dto.MetricType_SUMMARY:
+			summary := metric.GetSummary()
+			payload.Count, payload.Sum = summary.GetSampleCount(), summary.GetSampleSum()
+			payload.Quantiles = make(map[string]float64, len(summary.GetQuantile()))
+			for _, quantile := range summary.GetQuantile() {
+				payload.Quantiles[strconv.FormatFloat(quantile.GetQuantile(), 'g', -1, 64)] = quantile.GetValue()
+			}
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// openMetricsTypes maps the OpenMetrics types to the text exposition format types
+var openMetricsTypes = map[string]string{
+	"counter":        "counter",
+	"gauge":          "gauge",
+	"histogram":      "histogram",
+	"summary":        "summary",
+	"gaugehistogram": "histogram",
+	"info":           "gauge",
+	"stateset":       "gauge",
+	"unknown":        "untyped",
+}
+
+// openMetricsFamily is a metric family declared by a TYPE line of an OpenMetrics body.
+type openMetricsFamily struct {
+	kind string
+	// name of the family in the text exposition format
+	name string
+}
+
+// parseOpenMetrics parses an OpenMetrics body into payloads. The body is rewritten to the text exposition format,
+// line by line so errors keep their line number: counters get their _total name, info metrics their _info name,
+// gauge histograms become histograms, state sets and unknown metrics gauges and untyped metrics, timestamps
+// are converted to milliseconds, and _created samples and UNIT lines are dropped. Exemplars are attached to
+// the payload of their sample.
+func parseOpenMetrics(body []byte) ([]PrometheusPayload, error) {
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if len(lines) == 0 || lines[len(lines)-1] != openMetricsEOF {
+		return nil, fmt.Errorf("invalid OpenMetrics input: missing %s", openMetricsEOF)
+	}
+	lines = lines[:len(lines)-1]
+	families := make(map[string]openMetricsFamily)
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) == 4 && fields[0] == "#" && fields[1] == "TYPE" {
+			family := openMetricsFamily{kind: fields[3], name: fields[2]}
+			switch family.kind {
+			case "counter":
+				family.name += "_total"
+			case "info":
+				family.name += "_info"
+			}
+			families[fields[2]] = family
+		}
+	}
+	exemplars := make(map[string][]Exemplar)
+	var text strings.Builder
+	for i, line := range lines {
+		converted, err := convertOpenMetricsLine(line, families, exemplars)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenMetrics input: line %d: %w", i+1, err)
+		}
+		text.WriteString(converted)
+		text.WriteByte('\n')
+	}
+	payloads, err := parseText([]byte(text.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenMetrics input: %w", err)
+	}
+	for i := range payloads {
+		payloads[i].Exemplars = exemplars[groupKey(payloads[i].Name, payloads[i].Labels)]
+	}
+	return payloads, nil
+}
+
+// convertOpenMetricsLine rewrites an OpenMetrics line to the text exposition format. Dropped lines become empty lines.
+func convertOpenMetricsLine(line string, families map[string]openMetricsFamily, exemplars map[string][]Exemplar) (string, error) {
+	if line == openMetricsEOF {
+		return "", fmt.Errorf("%s must be the last line", openMetricsEOF)
+	}
+	if strings.HasPrefix(line, "#") {
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) < 3 {
+			return "", fmt.Errorf("invalid metadata line %q", line)
+		}
+		family, ok := families[fields[2]]
+		switch {
+		case fields[1] == "UNIT":
+			return "", nil
+		case fields[1] == "HELP" && ok:
+			fields[2] = family.name
+		case fields[1] == "TYPE":
+			if !ok {
+				return "", fmt.Errorf("invalid TYPE line %q", line)
+			}
+			kind, ok := openMetricsTypes[family.kind]
+			if !ok {
+				return "", fmt.Errorf("unknown metric type %q", family.kind)
+			}
+			return fmt.Sprintf("# TYPE %s %s", family.name, kind), nil
+		}
+		return strings.Join(fields, " "), nil
+	}
+	if strings.TrimSpace(line) == "" {
+		return "", fmt.Errorf("empty lines are not allowed")
+	}
+	name, labels, rest, err := splitSample(line)
+	if err != nil {
+		return "", err
+	}
+	sample, exemplar, hasExemplar := strings.Cut(rest, " # ")
+	fields := strings.Fields(sample)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", fmt.Errorf("invalid sample %q", line)
+	}
+	familyName, family, suffix := sampleFamily(name, families)
+	switch {
+	case suffix == "_created":
+		return "", nil
+	case family.kind == "gaugehistogram" && (suffix == "_gcount" || suffix == "_gsum"):
+		name = familyName + "_" + suffix[2:]
+	}
+	converted := name + labels + " " + fields[0]
+	if len(fields) == 2 {
+		ts, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		converted += " " + strconv.FormatInt(int64(math.Round(ts*1000)), 10)
+	}
+	if hasExemplar {
+		e, err := parseOpenMetricsExemplar(exemplar)
+		if err != nil {
+			return "", err
+		}
+		seriesLabels, err := parseLabels(labels)
+		if err != nil {
+			return "", err
+		}
+		delete(seriesLabels, "le")
+		// Keyed by the name of the payload, which is the family name for histogram buckets
+		key := groupKey(name, seriesLabels)
+		if family.name != "" {
+			key = groupKey(family.name, seriesLabels)
+		}
+		exemplars[key] = append(exemplars[key], e)
+	}
+	return converted, nil
+}
+
+// sampleFamily finds the family of a sample name, and the suffix of the sample name after the family name.
+func sampleFamily(name string, families map[string]openMetricsFamily) (string, openMetricsFamily, string) {
+	if family, ok := families[name]; ok {
+		return name, family, ""
+	}
+	for _, suffix := range []string{"_total", "_created", "_bucket", "_count", "_sum", "_gcount", "_gsum", "_info"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			if family, ok := families[base]; ok {
+				return base, family, suffix
+			}
+		}
+	}
+	return name, openMetricsFamily{}, ""
+}
+
+// splitSample splits a sample line into its name, its label set with the braces and the rest of the line.
+func splitSample(line string) (string, string, string, error) {
+	end := strings.IndexAny(line, "{ ")
+	if end <= 0 {
+		return "", "", "", fmt.Errorf("invalid sample %q", line)
+	}
+	name := line[:end]
+	if line[end] != '{' {
+		return name, "", strings.TrimLeft(line[end:], " "), nil
+	}
+	closing, err := labelsEnd(line[end:])
+	if err != nil {
+		return "", "", "", err
+	}
+	return name, line[end : end+closing+1], strings.TrimLeft(line[end+closing+1:], " "), nil
+}
+
+// labelsEnd returns the index of the brace closing the label set at the start of s.
+func labelsEnd(s string) (int, error) {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == '}':
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated label set %q", s)
+}
+
+// parseLabels parses a label set with its braces, e.g. {a="1",b="2"}.
+func parseLabels(set string) (map[string]string, error) {
+	labels := make(map[string]string)
+	s := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(set, "{"), "}"))
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		name = strings.TrimSpace(name)
+		rest = strings.TrimSpace(rest)
+		if !ok || !labelNameRegexp.MatchString(name) || !strings.HasPrefix(rest, `"`) {
+			return nil, fmt.Errorf("invalid label set %q", set)
+		}
+		end := 1
+		for ; end < len(rest) && rest[end] != '"'; end++ {
+			if rest[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(rest) {
+			return nil, fmt.Errorf("invalid label set %q", set)
+		}
+		value, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid label value in %q", set)
+		}
+		labels[name] = value
+		s = strings.TrimPrefix(strings.TrimSpace(rest[end+1:]), ",")
+		s = strings.TrimSpace(s)
+	}
+	return labels, nil
+}
+
+// parseOpenMetricsExemplar parses an exemplar, e.g. {trace_id="abc"} 0.5 1680124991.883
+func parseOpenMetricsExemplar(s string) (Exemplar, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") {
+		return Exemplar{}, fmt.Errorf("invalid exemplar %q", s)
+	}
+	closing, err := labelsEnd(s)
+	if err != nil {
+		return Exemplar{}, err
+	}
+	labels, err := parseLabels(s[:closing+1])
+	if err != nil {
+		return Exemplar{}, err
+	}
+	fields := strings.Fields(s[closing+1:])
+	if len(fields) == 0 || len(fields) > 2 {
+		return Exemplar{}, fmt.Errorf("invalid exemplar %q", s)
+	}
+	e := Exemplar{Labels: labels}
+	if e.Value, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return Exemplar{}, fmt.Errorf("invalid exemplar value %q", fields[0])
+	}
+	if len(fields) == 2 {
+		ts, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return Exemplar{}, fmt.Errorf("invalid exemplar timestamp %q", fields[1])
+		}
+		e.TimestampMs = int64(math.Round(ts * 1000))
+	}
+	return e, nil
+}
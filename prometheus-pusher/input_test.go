@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+const expositionBody = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# A histogram, which has a pretty complex representation in the text format:
+# HELP http_request_duration_seconds A histogram of the request duration.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="0.1"} 33444
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds{quantile="0.99"} 76656
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+
+anomaly_score{app="web"} 0.49`
+
+const openMetricsBody = `# TYPE anomalies counter
+# UNIT anomalies events
+# HELP anomalies Anomalies detected.
+anomalies_total{app="web"} 3 1680124991.883 # {trace_id="abc"} 1.0 1680124991.0
+anomalies_created{app="web"} 1680124000.0
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 1
+latency_seconds_bucket{le="1.0"} 2 # {trace_id="def"} 0.7
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_count 2
+latency_seconds_sum 0.9
+# TYPE queue_depth gaugehistogram
+queue_depth_bucket{le="10.0"} 4
+queue_depth_bucket{le="+Inf"} 5
+queue_depth_gcount 5
+queue_depth_gsum 12
+# TYPE build info
+build_info{version="1.2.3"} 1
+# TYPE cpu_temp unknown
+cpu_temp{path="a b\"c}"} 42.5
+# EOF
+`
+
+func TestInputFormat(t *testing.T) {
+	assert.Equal(t, INPUT_JSON, inputFormat("", nil, []byte(expositionBody)))
+	assert.Equal(t, INPUT_PROMETHEUS, inputFormat(INPUT_PROMETHEUS, nil, []byte(`{}`)))
+	assert.Equal(t, INPUT_OPENMETRICS, inputFormat(INPUT_AUTO, map[string]string{"content-type": "application/openmetrics-text; version=1.0.0"}, nil))
+	assert.Equal(t, INPUT_PROMETHEUS, inputFormat(INPUT_AUTO, map[string]string{"Content-Type": "text/plain; version=0.0.4"}, nil))
+	assert.Equal(t, INPUT_JSON, inputFormat(INPUT_AUTO, map[string]string{"Content-Type": "application/json"}, nil))
+	// Without a known content type the body is sniffed
+	assert.Equal(t, INPUT_JSON, inputFormat(INPUT_AUTO, map[string]string{"Content-Type": "application/octet-stream"}, []byte(` {"name":"a"}`)))
+	assert.Equal(t, INPUT_JSON, inputFormat(INPUT_AUTO, nil, []byte(`[1]`)))
+	assert.Equal(t, INPUT_OPENMETRICS, inputFormat(INPUT_AUTO, nil, []byte(openMetricsBody)))
+	assert.Equal(t, INPUT_PROMETHEUS, inputFormat(INPUT_AUTO, nil, []byte(expositionBody)))
+}
+
+func TestParseExposition(t *testing.T) {
+	payloads, err := parseExposition([]byte(expositionBody))
+	assert.NoError(t, err)
+	assert.Len(t, payloads, 5)
+
+	assert.Equal(t, PrometheusPayload{Name: "anomaly_score", Type: "Untyped", Value: 0.49, Labels: map[string]string{"app": "web"}}, payloads[0])
+	assert.Equal(t, PrometheusPayload{Name: "http_request_duration_seconds", Help: "A histogram of the request duration.", Type: "Histogram",
+		Count: 144320, Sum: 53423, Buckets: map[string]uint64{"0.05": 24054, "0.1": 33444}}, payloads[1])
+	assert.Equal(t, PrometheusPayload{Name: "http_requests_total", Help: "The total number of HTTP requests.", Type: "Counter", Value: 1027,
+		TimestampMs: 1395066363000, Labels: map[string]string{"method": "post", "code": "200"}}, payloads[2])
+	assert.Equal(t, float64(3), payloads[3].Value)
+	assert.Equal(t, PrometheusPayload{Name: "rpc_duration_seconds", Type: "Summary", Count: 2693, Sum: 1.7560473e+07,
+		Quantiles: map[string]float64{"0.5": 4773, "0.99": 76656}}, payloads[4])
+	for _, payload := range payloads {
+		_, err := newCollector(payload, nil, true)
+		assert.NoError(t, err, payload.Name)
+	}
+
+	for body, msg := range map[string]string{
+		"anomaly_score{app=web} 1":                          "line 1",
+		"anomaly_score 1\nanomaly_score 2\nanomaly_score x": "line 3",
+		"# TYPE a counter\n# TYPE a gauge":                  "second TYPE line",
+	} {
+		_, err := parseExposition([]byte(body))
+		assert.ErrorContains(t, err, "invalid Prometheus text input", body)
+		assert.ErrorContains(t, err, msg, body)
+	}
+}
+
+func TestParseOpenMetrics(t *testing.T) {
+	payloads, err := parseOpenMetrics([]byte(openMetricsBody))
+	assert.NoError(t, err)
+	assert.Len(t, payloads, 5)
+
+	assert.Equal(t, PrometheusPayload{Name: "anomalies_total", Help: "Anomalies detected.", Type: "Counter", Value: 3, TimestampMs: 1680124991883,
+		Labels:    map[string]string{"app": "web"},
+		Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "abc"}, Value: 1, TimestampMs: 1680124991000}}}, payloads[0])
+	assert.Equal(t, PrometheusPayload{Name: "build_info", Type: "Gauge", Value: 1, Labels: map[string]string{"version": "1.2.3"}}, payloads[1])
+	assert.Equal(t, PrometheusPayload{Name: "cpu_temp", Type: "Untyped", Value: 42.5, Labels: map[string]string{"path": `a b"c}`}}, payloads[2])
+	assert.Equal(t, PrometheusPayload{Name: "latency_seconds", Type: "Histogram", Count: 2, Sum: 0.9, Buckets: map[string]uint64{"0.5": 1, "1": 2},
+		Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "def"}, Value: 0.7}}}, payloads[3])
+	// Gauge histograms become histograms
+	assert.Equal(t, PrometheusPayload{Name: "queue_depth", Type: "Histogram", Count: 5, Sum: 12, Buckets: map[string]uint64{"10": 4}}, payloads[4])
+
+	for body, msg := range map[string]string{
+		"a 1\n":                                "missing # EOF",
+		"# EOF\na 1\n# EOF":                    "line 1: # EOF must be the last line",
+		"# TYPE a counter\n\na_total 1\n# EOF": "line 2: empty lines are not allowed",
+		"# TYPE a histogram\na_bucket{le=\"1\" 1\n# EOF": "line 2: unterminated label set",
+		"# TYPE a stream\na 1\n# EOF":                    "line 1: unknown metric type",
+		"a 1 yesterday\n# EOF":                           "line 1: invalid timestamp",
+		"a_total 1 # trace 1\n# EOF":                     "line 1: invalid exemplar",
+		"a{b=\"1\"} x\n# EOF":                            "line 1",
+	} {
+		_, err := parseOpenMetrics([]byte(body))
+		assert.ErrorContains(t, err, msg, body)
+	}
+}
+
+func TestSink_InputFormats(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	ps := &prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1, inputFormat: INPUT_AUTO}
+	ps.metrics = NewMetricsServer(nil, "test_input")
+
+	results := sinkDatums(ps,
+		testDatum{id: "json", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1}`},
+		testDatum{id: "text", value: "# HELP requests_total Requests.\n# TYPE requests_total counter\nrequests_total 5\n"},
+		testDatum{id: "openmetrics", value: "# TYPE errors counter\nerrors_total 2\n# EOF\n"},
+		testDatum{id: "malformed", value: "# TYPE errors counter\nerrors_total two\n# EOF\n"},
+	)
+	assert.True(t, results["json"].Success)
+	assert.True(t, results["text"].Success)
+	assert.True(t, results["openmetrics"].Success)
+	assert.False(t, results["malformed"].Success)
+	assert.Contains(t, results["malformed"].Err, "invalid OpenMetrics input: text format parsing error in line 2")
+	if pushed := pgw.requests["/metrics/job/__requests_total"]; assert.Len(t, pushed, 1) {
+		assert.Equal(t, "Requests.", pushed[0].GetHelp())
+		assert.Equal(t, float64(5), pushed[0].GetMetric()[0].GetCounter().GetValue())
+	}
+	assert.Len(t, pgw.requests["/metrics/job/__errors_total"], 1)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type trackedGroup struct {
+	jobName  string
+	grouping map[string]string
+	updated  time.Time
+}
+
+// groupJanitor deletes the Pushgateway groups pushed by this sink once they are not updated within the timeout.
+// Only groups pushed since the sink started are known to it.
+type groupJanitor struct {
+	logger      *zap.SugaredLogger
+	timeout     time.Duration
+	interval    time.Duration
+	deleteGroup func(ctx context.Context, jobName string, grouping map[string]string) error
+
+	mu     sync.Mutex
+	groups map[string]*trackedGroup
+	now    func() time.Time
+}
+
+func newGroupJanitor(logger *zap.SugaredLogger, timeout, interval time.Duration, deleteGroup func(context.Context, string, map[string]string) error) *groupJanitor {
+	return &groupJanitor{
+		logger:      logger,
+		timeout:     timeout,
+		interval:    interval,
+		deleteGroup: deleteGroup,
+		groups:      make(map[string]*trackedGroup),
+		now:         time.Now,
+	}
+}
+
+// touch records that the group was pushed.
+func (j *groupJanitor) touch(group *pushGroup) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.groups[group.key] = &trackedGroup{jobName: group.jobName, grouping: group.grouping, updated: j.now()}
+}
+
+// forget stops tracking a group deleted by a payload.
+func (j *groupJanitor) forget(key string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.groups, key)
+}
+
+// sweep deletes the stale groups and returns how many were deleted. Groups failing to delete are retried on the next sweep.
+func (j *groupJanitor) sweep(ctx context.Context) int {
+	j.mu.Lock()
+	cutoff := j.now().Add(-j.timeout)
+	stale := make(map[string]*trackedGroup)
+	for key, group := range j.groups {
+		if group.updated.Before(cutoff) {
+			stale[key] = group
+		}
+	}
+	j.mu.Unlock()
+
+	deleted := 0
+	for key, group := range stale {
+		if err := j.deleteGroup(ctx, group.jobName, group.grouping); err != nil {
+			j.logger.Warnw("Failed to delete stale group", zap.String("job", group.jobName), zap.Any("grouping", group.grouping), zap.Error(err))
+			continue
+		}
+		deleted++
+		j.logger.Infow("Deleted stale group", zap.String("job", group.jobName), zap.Any("grouping", group.grouping))
+		j.mu.Lock()
+		// The group may have been pushed again while it was deleted
+		if current, ok := j.groups[key]; ok && current == group {
+			delete(j.groups, key)
+		}
+		j.mu.Unlock()
+	}
+	return deleted
+}
+
+// start sweeps the stale groups every interval until the context is cancelled.
+func (j *groupJanitor) start(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.sweep(ctx)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupJanitor_Sweep(t *testing.T) {
+	var deleted []string
+	fail := false
+	janitor := newGroupJanitor(logging.NewLogger().Named("janitor"), time.Minute, time.Minute, func(ctx context.Context, jobName string, grouping map[string]string) error {
+		if fail {
+			return fmt.Errorf("pushgateway unavailable")
+		}
+		deleted = append(deleted, jobName+"/"+grouping["app"])
+		return nil
+	})
+	now := time.Unix(1680124991, 0)
+	janitor.now = func() time.Time { return now }
+
+	janitor.touch(&pushGroup{key: "a", jobName: "job", grouping: map[string]string{"app": "a"}})
+	janitor.touch(&pushGroup{key: "b", jobName: "job", grouping: map[string]string{"app": "b"}})
+	janitor.touch(&pushGroup{key: "c", jobName: "job", grouping: map[string]string{"app": "c"}})
+	janitor.forget("c")
+	assert.Equal(t, 0, janitor.sweep(context.Background()))
+
+	now = now.Add(40 * time.Second)
+	janitor.touch(&pushGroup{key: "b", jobName: "job", grouping: map[string]string{"app": "b"}})
+	now = now.Add(40 * time.Second)
+	fail = true
+	assert.Equal(t, 0, janitor.sweep(context.Background()))
+	assert.Len(t, janitor.groups, 2)
+
+	// Failed deletes are retried on the next sweep
+	fail = false
+	assert.Equal(t, 1, janitor.sweep(context.Background()))
+	assert.Equal(t, []string{"job/a"}, deleted)
+	assert.Len(t, janitor.groups, 1)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 1, janitor.sweep(context.Background()))
+	assert.Equal(t, []string{"job/a", "job/b"}, deleted)
+	assert.Empty(t, janitor.groups)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	"go.uber.org/zap"
+
+	sharedexpr "github.com/numaproj/numaflow-sinks/prometheus-pusher/shared/expr"
+)
+
+// Reasons of the job name fallbacks counted in total_job_name_fallback
+const (
+	jobNameFallbackError   = "error"
+	jobNameFallbackType    = "type"
+	jobNameFallbackInvalid = "invalid"
+)
+
+// jobNameWarnInterval is the minimum interval between two warnings of a fallback reason, as an expression
+// failing for one payload usually fails for every payload of the same metrics.
+const jobNameWarnInterval = time.Minute
+
+// jobNamer renders the Pushgateway job name of a payload with the -jobNameExpr expression.
+type jobNamer struct {
+	program *vm.Program
+	logger  *zap.SugaredLogger
+	metrics *MetricsPublisher
+	now     func() time.Time
+
+	mu     sync.Mutex
+	warned map[string]time.Time
+}
+
+// jobNameEnv is the environment of the job name expression. join concatenates its non-empty arguments, so
+// join("_", namespace, subsystem, name) skips the fields a payload does not set.
+func jobNameEnv(payload PrometheusPayload) map[string]interface{} {
+	labels := payload.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	env := sharedexpr.GetFuncMap(map[string]interface{}{
+		"name":      payload.Name,
+		"namespace": payload.Namespace,
+		"subsystem": payload.Subsystem,
+		"type":      payload.Type,
+		"labels":    labels,
+	})
+	env["join"] = joinNonEmpty
+	env["printf"] = fmt.Sprintf
+	return env
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	nonEmpty := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
+
+func newJobNamer(logger *zap.SugaredLogger, expression string, metrics *MetricsPublisher) (*jobNamer, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	program, err := expr.Compile(expression, expr.Env(jobNameEnv(PrometheusPayload{})))
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile job name expression '%s': %w", expression, err)
+	}
+	return &jobNamer{program: program, logger: logger, metrics: metrics, now: time.Now, warned: make(map[string]time.Time)}, nil
+}
+
+// validateJobName checks the job name against the constraints of the Pushgateway URL path. The client encodes
+// slashes and escapes the other characters, but an empty name, dot segments and control characters cannot be pushed.
+func validateJobName(job string) error {
+	switch {
+	case job == "":
+		return fmt.Errorf("job name is empty")
+	case job == "." || job == "..":
+		return fmt.Errorf("job name %q is a dot path segment", job)
+	case !utf8.ValidString(job):
+		return fmt.Errorf("job name %q is not valid UTF-8", job)
+	case strings.IndexFunc(job, unicode.IsControl) >= 0:
+		return fmt.Errorf("job name %q contains control characters", job)
+	}
+	return nil
+}
+
+// fallbackJobName joins the non-empty namespace, subsystem and name of the payload.
+func fallbackJobName(payload PrometheusPayload) string {
+	return joinNonEmpty("_", payload.Namespace, payload.Subsystem, payload.Name)
+}
+
+// name renders the job name of the payload. Without an expression it is the namespace, subsystem and name joined
+// with underscores, empty fields included, so the groups pushed by earlier versions keep their job name. When the
+// expression fails, or renders an empty or invalid name, the fallback job name is used.
+func (n *jobNamer) name(payload PrometheusPayload) string {
+	if n == nil {
+		return jobName(payload)
+	}
+	result, err := expr.Run(n.program, jobNameEnv(payload))
+	if err != nil {
+		return n.fallback(payload, jobNameFallbackError, err)
+	}
+	job, ok := result.(string)
+	if !ok {
+		return n.fallback(payload, jobNameFallbackType, fmt.Errorf("job name expression returned %T, not a string", result))
+	}
+	if err := validateJobName(job); err != nil {
+		return n.fallback(payload, jobNameFallbackInvalid, err)
+	}
+	return job
+}
+
+// fallback counts the fallback and returns the fallback job name, warning at most once per jobNameWarnInterval
+// for each reason.
+func (n *jobNamer) fallback(payload PrometheusPayload, reason string, err error) string {
+	job := fallbackJobName(payload)
+	n.metrics.IncreaseJobNameFallback(reason)
+	n.mu.Lock()
+	now := n.now()
+	warn := now.Sub(n.warned[reason]) >= jobNameWarnInterval
+	if warn {
+		n.warned[reason] = now
+	}
+	n.mu.Unlock()
+	if warn {
+		n.logger.Warnw("Using the fallback job name", zap.String("metric", payload.Name), zap.String("job", job), zap.String("reason", reason), zap.Error(err))
+	}
+	return job
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestJobNamer(t *testing.T) {
+	payload := PrometheusPayload{Name: "score", Namespace: "ns", Subsystem: "none", Type: "Gauge", Labels: map[string]string{"app": "web"}}
+	transformed := PrometheusPayload{Name: "metric", Subsystem: "none", Labels: map[string]string{}}
+	tests := []struct {
+		name       string
+		expression string
+		payload    PrometheusPayload
+		job        string
+	}{
+		{name: "default", payload: payload, job: "ns_none_score"},
+		{name: "default keeps empty fields", payload: transformed, job: "_none_metric"},
+		{name: "join", expression: `join("_", namespace, labels.app, name)`, payload: payload, job: "ns_web_score"},
+		{name: "join skips empty fields", expression: `join("_", namespace, labels.app, name)`, payload: transformed, job: "metric"},
+		{name: "printf", expression: `printf("%s-%s", labels.app, type)`, payload: payload, job: "web-Gauge"},
+		{name: "conditional", expression: `labels.app != "" ? labels.app : name`, payload: transformed, job: "metric"},
+		{name: "slash", expression: `namespace + "/" + name`, payload: payload, job: "ns/score"},
+		{name: "empty falls back", expression: `labels.team`, payload: transformed, job: "none_metric"},
+		{name: "dot segment falls back", expression: `".."`, payload: payload, job: "ns_none_score"},
+		{name: "control character falls back", expression: `"a\nb"`, payload: payload, job: "ns_none_score"},
+		{name: "non-string falls back", expression: `len(labels)`, payload: payload, job: "ns_none_score"},
+	}
+	logger := logging.NewLogger().Named("job-name")
+	metrics := NewMetricsServer(nil, "test_job_namer")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namer, err := newJobNamer(logger, tt.expression, metrics)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.job, namer.name(tt.payload))
+		})
+	}
+
+	_, err := newJobNamer(logger, `join("_", team)`, metrics)
+	assert.ErrorContains(t, err, "unable to compile job name expression")
+}
+
+func TestJobNamer_Fallback(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	metrics := NewMetricsServer(nil, "test_job_name_fallback")
+	namer, err := newJobNamer(zap.New(core).Sugar(), `labels.kind == "count" ? len(labels) : labels.team + name`, metrics)
+	assert.NoError(t, err)
+	now := time.UnixMilli(1680124991883)
+	namer.now = func() time.Time { return now }
+	payload := PrometheusPayload{Name: "score", Namespace: "ns", Labels: map[string]string{"team": "\n"}}
+
+	// Every fallback is counted, the warning is only logged once per interval and reason
+	assert.Equal(t, "ns_score", namer.name(payload))
+	assert.Equal(t, "ns_score", namer.name(payload))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.jobNameTotalFallback.WithLabelValues(jobNameFallbackInvalid)))
+	if assert.Equal(t, 1, logs.Len()) {
+		assert.Equal(t, jobNameFallbackInvalid, logs.All()[0].ContextMap()["reason"])
+		assert.Equal(t, "ns_score", logs.All()[0].ContextMap()["job"])
+	}
+
+	now = now.Add(jobNameWarnInterval)
+	assert.Equal(t, "ns_score", namer.name(payload))
+	assert.Equal(t, 2, logs.Len())
+
+	// Another reason has its own warning
+	assert.Equal(t, "ns_score", namer.name(PrometheusPayload{Name: "score", Namespace: "ns", Labels: map[string]string{"kind": "count"}}))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.jobNameTotalFallback.WithLabelValues(jobNameFallbackType)))
+	assert.Equal(t, 3, logs.Len())
+}
+
+func TestValidateJobName(t *testing.T) {
+	assert.NoError(t, validateJobName("ns_none_score"))
+	assert.NoError(t, validateJobName("a/b c"))
+	assert.ErrorContains(t, validateJobName(""), "empty")
+	assert.ErrorContains(t, validateJobName("."), "dot path segment")
+	assert.ErrorContains(t, validateJobName("\xff"), "UTF-8")
+	assert.ErrorContains(t, validateJobName("a\tb"), "control characters")
+}
+
+func TestPushGroups_JobNameExpr(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	namer, err := newJobNamer(logging.NewLogger().Named("job-name"), `join("_", namespace, labels.app)`, NewMetricsServer(nil, "test_job_name_expr"))
+	assert.NoError(t, err)
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1,
+		grouping: groupingConfig{groupingLabels: []string{"app"}, jobNamer: namer}}
+	ps.metrics = NewMetricsServer(nil, "test_job_name")
+	payloads := []PrometheusPayload{
+		{Namespace: "ns", Name: "score", Type: "Gauge", Value: 1, Labels: map[string]string{"app": "web"}},
+		{Namespace: "ns", Name: "latency", Type: "Gauge", Value: 2, Labels: map[string]string{"app": "web"}},
+		{Name: "score", Type: "Gauge", Value: 3},
+	}
+	assert.NoError(t, ps.push(context.Background(), payloads))
+	// Metrics rendering the same job name share a group
+	assert.Len(t, pgw.requests["/metrics/job/ns_web/app/web"], 2)
+	assert.Len(t, pgw.requests["/metrics/job/score"], 1)
+}
@@ -6,15 +6,17 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	sinksdk "github.com/numaproj/numaflow-go/pkg/sinker"
 	numaflag "github.com/numaproj/numaflow-sinks/shared/flag"
 	"github.com/numaproj/numaflow/pkg/shared/logging"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/push"
 	"go.uber.org/zap"
 )
@@ -26,136 +28,380 @@ const (
 	METRICS_NAME           = "METRICS_NAME"
 	EXCLUDE_METRIC_LABELS  = "EXCLUDE_METRICS_LABELS"
 	OPEX_METRIC_PREFIX     = "OPEX_METRIC_PREFIX"
+	REMOTE_WRITE_TENANT    = "REMOTE_WRITE_TENANT"
+	INFLUX_TOKEN           = "INFLUX_TOKEN"
+)
+
+// Output modes selected with -outputMode
+const (
+	OUTPUT_PUSHGATEWAY  = "pushgateway"
+	OUTPUT_REMOTE_WRITE = "remote-write"
+	OUTPUT_PULL         = "pull"
+	OUTPUT_OTLP         = "otlp"
+	OUTPUT_INFLUX       = "influx"
+	OUTPUT_STATSD       = "statsd"
+)
+
+// Pushgateway methods selected with -pushMethod
+const (
+	PUSH_METHOD_PUSH = "push"
+	PUSH_METHOD_ADD  = "add"
 )
 
 type prometheusSink struct {
-	logger               *zap.SugaredLogger
-	skipFailed           bool
-	labels               map[string]string
-	excludeLabels        []string
-	metrics              *MetricsPublisher
-	ignoreMetricsTs      bool
-	metricsName          string
-	enableMsgTransformer bool
+	logger          *zap.SugaredLogger
+	skipFailed      bool
+	labels          map[string]string
+	excludeLabels   []string
+	metrics         *MetricsPublisher
+	ignoreMetricsTs bool
+	transformer     *transformer
+	aggregator      *aggregator
+	limiter         *cardinalityLimiter
+	relabeler       *relabeler
+	inputFormat     string
+	pushConcurrency int
+	outputMode      string
+	remoteWriter    *remoteWriter
+	otlpExporter    *otlpExporter
+	influxWriter    *influxWriter
+	statsdWriter    *statsdWriter
+	pullStore       *pullStore
+	grouping        groupingConfig
+	pushMethod      string
+	pushMaxRetries  int
+	pushMinBackoff  time.Duration
+	pushMaxBackoff  time.Duration
+	janitor         *groupJanitor
+	pushgateway     *pushgatewayClient
+	validation      string
+	buffer          *walBuffer
 }
 
-type myCollector struct {
-	metric     *prometheus.Desc
-	ts         time.Time
-	metricType prometheus.ValueType
-	value      float64
+// retryPush calls request until it succeeds, fails in a way that is not retryable or pushMaxRetries is reached.
+// The recorder is the client of the pusher used by request, it sends the requests with the context.
+func (p *prometheusSink) retryPush(ctx context.Context, recorder *statusRecorder, target string, request func() error) error {
+	return retryRequest(ctx, p.logger, target, p.pushMaxRetries, p.pushMinBackoff, p.pushMaxBackoff, func() (time.Duration, bool, error) {
+		*recorder = statusRecorder{ctx: ctx, client: recorder.client}
+		if err := request(); err != nil {
+			return recorder.retryAfter, recorder.retryable(), err
+		}
+		return 0, false, nil
+	})
 }
 
-func (c *myCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.metric
+func (p *prometheusSink) pushGroup(ctx context.Context, group *pushGroup) error {
+	p.logger.Debugw("Pushing group", zap.String("job", group.jobName), zap.Any("grouping", group.grouping), zap.Int("metrics", len(group.payloads)))
+	pusher, err := p.createPusher(group.jobName)
+	if err != nil {
+		return err
+	}
+	recorder := &statusRecorder{client: p.pushgateway.httpClient()}
+	pusher = pusher.Client(recorder)
+	for key, value := range group.grouping {
+		pusher.Grouping(key, value)
+	}
+	if group.delete {
+		if err := p.retryPush(ctx, recorder, "Delete of "+group.jobName, pusher.Delete); err != nil {
+			p.logger.Errorw("Failed to delete", zap.String("job", group.jobName), zap.Any("grouping", group.grouping), zap.Error(err))
+			return err
+		}
+		p.janitor.forget(group.key)
+		p.metrics.IncreaseGroupDeleted()
+		p.logger.Infow("Successfully deleted", zap.String("job", group.jobName), zap.Any("grouping", group.grouping))
+		if len(group.payloads) == 0 {
+			return nil
+		}
+	}
+	collectors := make(collectorSet, 0, len(group.payloads))
+	for _, payload := range group.payloads {
+		if len(payload.Exemplars) > 0 {
+			// The Pushgateway cannot store exemplars
+			p.logger.Debugf("Dropping %d exemplars of %s pushed to the Pushgateway", len(payload.Exemplars), payload.Name)
+			p.metrics.IncreaseExemplarsDropped(len(payload.Exemplars))
+			payload.Exemplars = nil
+		}
+		_, series := p.grouping.split(payload.Labels)
+		collector, err := newCollector(payload, series, p.ignoreMetricsTs)
+		if err != nil {
+			p.logger.Errorw("Invalid metric", zap.Any("payload", payload), zap.Error(err))
+			return err
+		}
+		p.logger.Debugf("Creating Collector %s", payload.Name)
+		collectors = append(collectors, collector)
+	}
+	pusher = pusher.Collector(collectors)
+	if p.pushMethod == PUSH_METHOD_ADD {
+		err = p.retryPush(ctx, recorder, "Push of "+group.jobName, pusher.Add)
+	} else {
+		err = p.retryPush(ctx, recorder, "Push of "+group.jobName, pusher.Push)
+	}
+	if err != nil {
+		p.logger.Errorw("Failed to push", zap.String("job", group.jobName), zap.Any("grouping", group.grouping), zap.Error(err))
+		return err
+	}
+	p.janitor.touch(group)
+	for _, payload := range group.payloads {
+		appName := payload.Labels["app"]
+		p.metrics.IncreaseAnomalyGenerated(payload.Namespace, appName, payload.Name)
+		p.metrics.IncreaseTotalSuccess()
+	}
+	p.logger.Infow("Successfully pushed", zap.String("job", group.jobName), zap.Any("grouping", group.grouping), zap.Int("metrics", len(group.payloads)))
+	return nil
 }
 
-func (c *myCollector) Collect(ch chan<- prometheus.Metric) {
-	var metric prometheus.Metric
-	if c.ts.IsZero() {
-		metric = prometheus.MustNewConstMetric(c.metric, c.metricType, c.value)
-	} else {
-		metric = prometheus.NewMetricWithTimestamp(c.ts, prometheus.MustNewConstMetric(c.metric, c.metricType, c.value))
+// deleteGroup deletes all metrics of a group from the Pushgateway, retrying like a push.
+func (p *prometheusSink) deleteGroup(ctx context.Context, jobName string, grouping map[string]string) error {
+	pusher, err := p.createPusher(jobName)
+	if err != nil {
+		return err
+	}
+	recorder := &statusRecorder{client: p.pushgateway.httpClient()}
+	pusher = pusher.Client(recorder)
+	for key, value := range grouping {
+		pusher.Grouping(key, value)
 	}
-	ch <- metric
+	if err := p.retryPush(ctx, recorder, "Delete of "+jobName, pusher.Delete); err != nil {
+		return err
+	}
+	p.metrics.IncreaseGroupDeleted()
+	return nil
 }
 
-func (p *prometheusSink) push(msgPayloads []PrometheusPayload) error {
-	for _, payload := range msgPayloads {
-		p.logger.Debugw("Pushing PrometheusPayload ", zap.Any("payload", payload))
-		pusher, err := p.createPusher(fmt.Sprintf("%s_%s_%s", payload.Namespace, payload.Subsystem, payload.Name))
-		if err != nil {
-			return err
-		}
-		switch payload.Type {
-		case "Gauge":
-			p.logger.Debugf("Creating Collector %s", payload.Name)
-			if p.ignoreMetricsTs {
-				pusher = pusher.Collector(&myCollector{
-					metric:     prometheus.NewDesc(payload.Name, "", nil, nil),
-					metricType: prometheus.GaugeValue,
-					value:      payload.Value,
-				})
-			} else {
-				pusher = pusher.Collector(&myCollector{
-					metric:     prometheus.NewDesc(payload.Name, "", nil, nil),
-					metricType: prometheus.GaugeValue,
-					value:      payload.Value,
-					ts:         time.UnixMilli(payload.TimestampMs),
-				})
+// validate checks the payloads according to the validation mode. Invalid payloads are sanitized or dropped,
+// in fail mode an invalid payload fails its datums and the other payloads of the datums are dropped too.
+func (p *prometheusSink) validate(payloads []PrometheusPayload, failed map[int]error) []PrometheusPayload {
+	valid := make([]PrometheusPayload, 0, len(payloads))
+	for _, payload := range payloads {
+		if verr := validatePayload(&payload, p.validation == VALIDATION_SANITIZE); verr != nil {
+			p.metrics.IncreaseTotalRejected(verr.reason)
+			if p.validation == VALIDATION_FAIL {
+				for _, datum := range payload.datums() {
+					if _, ok := failed[datum]; !ok {
+						failed[datum] = verr
+					}
+				}
+				continue
 			}
+			p.logger.Warnw("Rejected invalid metric", zap.Any("payload", payload), zap.String("reason", verr.reason), zap.Error(verr))
+			continue
+		}
+		valid = append(valid, payload)
+	}
+	if p.validation != VALIDATION_FAIL {
+		return valid
+	}
+	return succeeded(valid, failed)
+}
 
-			for key, value := range payload.Labels {
-				pusher.Grouping(key, value)
+// limitCardinality drops the new series beyond the cardinality limits, or moves them to the overflow series.
+func (p *prometheusSink) limitCardinality(payloads []PrometheusPayload) []PrometheusPayload {
+	payloads, rejected := p.limiter.limit(payloads)
+	for limit, count := range rejected {
+		p.metrics.IncreaseSeriesRejected(limit, count)
+	}
+	return payloads
+}
+
+// write sends the payloads to the backend selected by the output mode.
+func (p *prometheusSink) write(ctx context.Context, payloads []PrometheusPayload) error {
+	var err error
+	switch p.outputMode {
+	case OUTPUT_REMOTE_WRITE, OUTPUT_OTLP, OUTPUT_INFLUX, OUTPUT_STATSD:
+		// These backends have no way to delete series, delete markers are dropped
+		samples := make([]PrometheusPayload, 0, len(payloads))
+		for _, payload := range payloads {
+			if payload.Delete {
+				continue
 			}
-			appName := payload.Labels["app"]
-			p.metrics.IncreaseAnomalyGenerated(payload.Namespace, appName, payload.Name)
+			if len(payload.Exemplars) > 0 && (p.outputMode == OUTPUT_INFLUX || p.outputMode == OUTPUT_STATSD) {
+				// InfluxDB and StatsD cannot store exemplars
+				p.metrics.IncreaseExemplarsDropped(len(payload.Exemplars))
+				payload.Exemplars = nil
+			}
+			samples = append(samples, payload)
+		}
+		payloads = samples
+		switch p.outputMode {
+		case OUTPUT_OTLP:
+			err = p.otlpExporter.write(ctx, payloads)
+		case OUTPUT_INFLUX:
+			err = p.influxWriter.write(ctx, payloads)
+		case OUTPUT_STATSD:
+			err = p.statsdWriter.write(payloads)
 		default:
-			p.logger.Errorw("Unsupported Metrics Type", zap.Any("payload", payload))
-			return fmt.Errorf("unsupported Metrics Type")
+			err = p.remoteWriter.write(ctx, payloads)
 		}
-		err = pusher.Push()
-		if err != nil {
-			p.logger.Errorw("Failed to push", zap.Any("payload", payload), zap.Error(err))
-			return err
+	case OUTPUT_PULL:
+		err = p.pullStore.update(payloads)
+	default:
+		return p.push(ctx, payloads)
+	}
+	werr := &writeError{}
+	if err != nil {
+		werr.datums = failedDatums(err, payloads)
+	}
+	for _, payload := range payloads {
+		if !werr.failed(payload) {
+			p.metrics.IncreaseAnomalyGenerated(payload.Namespace, payload.Labels["app"], payload.Name)
+			p.metrics.IncreaseTotalSuccess()
 		}
-		p.logger.Infow("Successfully pushed", zap.Any("payload", payload))
-		p.metrics.IncreaseTotalSuccess()
+	}
+	return err
+}
 
+// flush writes the payloads through the write-ahead buffer when it is enabled.
+func (p *prometheusSink) flush(ctx context.Context, payloads []PrometheusPayload) error {
+	if p.buffer == nil {
+		return p.write(ctx, payloads)
+	}
+	return p.buffer.flush(ctx, payloads, p.write)
+}
+
+// push sends each group of payloads in a single Pushgateway request, running up to pushConcurrency groups at once.
+func (p *prometheusSink) push(ctx context.Context, msgPayloads []PrometheusPayload) error {
+	groups := groupPayloads(msgPayloads, p.grouping)
+	errs := make([]error, len(groups))
+	concurrency := p.pushConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, group *pushGroup) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = p.pushGroup(ctx, group)
+			if errs[i] != nil {
+				p.metrics.IncreaseGroupFailed()
+			} else {
+				p.metrics.IncreaseGroupSuccess()
+			}
+		}(i, group)
+	}
+	wg.Wait()
+	werr := &writeError{}
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", groups[i].jobName, err))
+			for _, datum := range groups[i].datums {
+				werr.fail(datum, err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		werr.err = fmt.Errorf("failed to push %d of %d groups: %s", len(failed), len(groups), strings.Join(failed, "; "))
+		return werr
 	}
 	return nil
 }
 
-func (p *prometheusSink) Sink(ctx context.Context, datumStreamCh <-chan sinksdk.Datum) sinksdk.Responses {
-	ok := sinksdk.ResponsesBuilder()
-	failed := sinksdk.ResponsesBuilder()
-	var payloads []string
-	for datum := range datumStreamCh {
-		payloads = append(payloads, string(datum.Value()))
-		ok = ok.Append(sinksdk.ResponseOK(datum.ID()))
-		failed = failed.Append(sinksdk.ResponseFailure(datum.ID(), "failed to push the metrics"))
+// decodeJSON converts a JSON datum with the message transformer, or decodes it as a PrometheusPayload.
+func (p *prometheusSink) decodeJSON(value []byte) ([]PrometheusPayload, error) {
+	if p.transformer != nil {
+		return p.transformer.transform(value)
+	}
+	var prometheusPayload PrometheusPayload
+	if err := json.Unmarshal(value, &prometheusPayload); err != nil {
+		return nil, err
+	}
+	return []PrometheusPayload{prometheusPayload}, nil
+}
+
+// decode converts a datum into its payloads, merges the configured labels and applies the relabel rules.
+func (p *prometheusSink) decode(value []byte, headers map[string]string) ([]PrometheusPayload, error) {
+	var payloads []PrometheusPayload
+	var err error
+	switch inputFormat(p.inputFormat, headers, value) {
+	case INPUT_PROMETHEUS:
+		if payloads, err = parseExposition(value); err != nil {
+			return nil, err
+		}
+	case INPUT_OPENMETRICS:
+		if payloads, err = parseOpenMetrics(value); err != nil {
+			return nil, err
+		}
+	default:
+		if payloads, err = p.decodeJSON(value); err != nil {
+			return nil, err
+		}
 	}
+	relabeled := payloads[:0]
+	for _, payload := range payloads {
+		// OTLP sends the configured labels as resource attributes
+		if p.outputMode != OUTPUT_OTLP {
+			payload.mergeLabels(p.labels)
+		}
+		if len(p.excludeLabels) > 0 {
+			payload.excludeLabels(p.excludeLabels)
+		}
+		if !p.relabeler.relabel(&payload) {
+			p.metrics.IncreaseTotalDropped()
+			continue
+		}
+		if !payload.Delete {
+			// Checks the value against the metric type before anything is written
+			if _, err := newCollector(payload, nil, true); err != nil {
+				return nil, err
+			}
+		}
+		relabeled = append(relabeled, payload)
+	}
+	return relabeled, nil
+}
+
+// Sink writes the metrics of all datums and acknowledges each datum on its own. A datum fails when
+// it cannot be decoded or when one of its payloads fails to write, the others are acknowledged.
+func (p *prometheusSink) Sink(ctx context.Context, datumStreamCh <-chan sinksdk.Datum) sinksdk.Responses {
+	var ids []string
 	var pls []PrometheusPayload
-	var prometheusPayloads []*PrometheusPayload
-	for _, payloadMsg := range payloads {
+	failed := make(map[int]error)
+	for datum := range datumStreamCh {
+		idx := len(ids)
+		ids = append(ids, datum.ID())
 		p.metrics.IncreaseTotalPushed()
-		if p.enableMsgTransformer {
-			var opl OriginalPayload
-			err := json.Unmarshal([]byte(payloadMsg), &opl)
-			if !p.skipFailed && err != nil {
-				p.metrics.IncreaseTotalSkipped()
-				return failed
-			}
-			prometheusPayloads = opl.ConvertToPrometheusPayload(p.metricsName)
-			for _, prometheusPayload := range prometheusPayloads {
-				prometheusPayload.mergeLabels(p.labels)
-				if len(p.excludeLabels) > 0 {
-					prometheusPayload.excludeLabels(p.excludeLabels)
-				}
-				pls = append(pls, *prometheusPayload)
-			}
-		} else {
-			var prometheusPayload PrometheusPayload
-			err := json.Unmarshal([]byte(payloadMsg), &prometheusPayload)
-			if !p.skipFailed && err != nil {
+		payloads, err := p.decode(datum.Value(), datum.Headers())
+		if err != nil {
+			if p.skipFailed {
 				p.metrics.IncreaseTotalSkipped()
-				return failed
-			}
-			prometheusPayload.mergeLabels(p.labels)
-			if len(p.excludeLabels) > 0 {
-				prometheusPayload.excludeLabels(p.excludeLabels)
+				p.logger.Warnw("Skipping invalid message", zap.String("id", datum.ID()), zap.Error(err))
+				continue
 			}
-			pls = append(pls, prometheusPayload)
+			p.logger.Errorw("Invalid message", zap.String("id", datum.ID()), zap.Error(err))
+			failed[idx] = err
+			continue
 		}
+		for i := range payloads {
+			payloads[i].datum = idx
+		}
+		pls = append(pls, payloads...)
 	}
-	err := p.push(pls)
-	if err != nil {
-		p.metrics.IncreaseTotalFailed()
-		p.logger.Errorf("Failed to push the Metrics", zap.Error(err))
-		return failed
+	// Aggregation runs first, so the companion series are validated and limited like the others
+	pls = p.aggregator.aggregate(pls)
+	pls = p.validate(pls, failed)
+	pls = p.limitCardinality(pls)
+	if err := p.flush(ctx, pls); err != nil {
+		p.logger.Errorw("Failed to push the Metrics", zap.Error(err))
+		for idx, err := range failedDatums(err, pls) {
+			failed[idx] = err
+		}
+	}
+	// Only the series written count towards the cardinality limits
+	p.limiter.record(succeeded(pls, failed))
+	responses := sinksdk.ResponsesBuilder()
+	for idx, id := range ids {
+		if err, ok := failed[idx]; ok {
+			p.metrics.IncreaseTotalFailed()
+			responses = responses.Append(sinksdk.ResponseFailure(id, err.Error()))
+			continue
+		}
+		responses = responses.Append(sinksdk.ResponseOK(id))
 	}
-	p.metrics.IncreaseTotalSuccess()
-	return ok
+	return responses
 }
 
 func (p *prometheusSink) createPusher(jobName string) (*push.Pusher, error) {
@@ -163,8 +409,7 @@ func (p *prometheusSink) createPusher(jobName string) (*push.Pusher, error) {
 	if !ok {
 		return nil, fmt.Errorf("Prometheus URL not found")
 	}
-	pusher := push.New(server, jobName)
-	return pusher, nil
+	return p.pushgateway.configure(push.New(server, jobName))
 }
 
 func parseStringToSlice(envValue string) []string {
@@ -207,13 +452,97 @@ func main() {
 	if opexMetricsPrefix == "" {
 		opexMetricsPrefix = "numaflow_prom_sink"
 	}
-	var metricPort int
+	var metricPort, pushConcurrency, pullPort, pushMaxRetries int
 	var ignoreMetricsTs, enableMsgTransformer bool
+	var aggregationCompanions bool
+	var outputMode, tenantHeader, pullPath, pushMethod, validation, transformerConfigFile, transformerPreset, aggregation string
+	var cardinalityMetricLimit, cardinalityGlobalLimit int
+	var cardinalityAction, relabelConfigFile, inputFormat string
+	var pullTTL, staleGroupTimeout, staleGroupInterval, cardinalityWindow, pushMinBackoff, pushMaxBackoff time.Duration
+	var bufferDir, jobNameExpr string
+	var bufferMaxBytes int64
+	var bufferReplayInterval time.Duration
+	rw := &remoteWriter{tenant: os.Getenv(REMOTE_WRITE_TENANT)}
+	otlp := &otlpExporter{headers: numaflag.MapFlag{}}
+	influx := &influxWriter{token: os.Getenv(INFLUX_TOKEN)}
+	var statsdFlavor, statsdPrefix string
+	var statsdMaxPacketSize int
+	var statsdCounterTTL time.Duration
 	meticslabels := numaflag.MapFlag{}
+	var groupingLabels, seriesLabels numaflag.ListFlag
+	var overrides transformerOverrides
+	pgwConfig := pushgatewayConfig{headers: numaflag.MapFlag{}}
 
+	flag.StringVar(&inputFormat, "inputFormat", INPUT_JSON, "Format of the datums, one of json,prometheus,openmetrics,auto. auto uses the datum Content-Type header or sniffs the body")
 	flag.BoolVar(&enableMsgTransformer, "enableMsgTransformer", false, "Enable Prometheus message Transformer")
+	flag.StringVar(&transformerConfigFile, "transformerConfig", "", "Message transformer config file, replaces the transformer preset")
+	flag.StringVar(&transformerPreset, "transformerPreset", "anomaly", "Built-in message transformer config")
+	flag.IntVar(&overrides.precision, "transformerPrecision", -1, "Decimal places of the transformed metric values, -1 keeps the config precision")
+	flag.IntVar(&overrides.labelPrecision, "transformerLabelPrecision", -1, "Decimal places of the numeric label values of the transformer, -1 keeps the config precision")
+	flag.Var((*numaflag.ListFlag)(&overrides.skipLabels), "transformerSkipLabels", "Metadata keys not turned into labels by the transformer, replaces the config ones E.g: artifact_versions")
+	flag.StringVar(&overrides.flattenSeparator, "transformerFlattenSeparator", "", "Separator of the label names flattened from nested metadata, replaces the config one E.g: _")
+	flag.StringVar(&relabelConfigFile, "relabelConfig", "", "File with the relabel_configs applied to each metric")
 	flag.BoolVar(&ignoreMetricsTs, "ignoreMetricsTs", true, "Ignore Metrics Timestamp")
 	flag.IntVar(&metricPort, "udsinkMetricsPort", 9090, "Metrics Port")
+	flag.IntVar(&pushConcurrency, "pushConcurrency", 4, "Number of groups pushed concurrently")
+	flag.StringVar(&validation, "validation", VALIDATION_SANITIZE, "Handling of invalid metric and label names, one of sanitize,reject,fail")
+	flag.StringVar(&aggregation, "aggregation", AGGREGATION_NONE, "Function reducing the samples of the same series in a batch, one of none,last,max,min,sum,avg,count")
+	flag.BoolVar(&aggregationCompanions, "aggregationCompanions", false, "Add the _aggregated_count and _aggregated_sum series of each aggregated series")
+	flag.IntVar(&cardinalityMetricLimit, "cardinalityMetricLimit", 0, "Max number of series of a metric name within the cardinality window, 0 disables")
+	flag.IntVar(&cardinalityGlobalLimit, "cardinalityGlobalLimit", 0, "Max number of series of all metrics within the cardinality window, 0 disables")
+	flag.DurationVar(&cardinalityWindow, "cardinalityWindow", time.Hour, "Time a series counts towards the cardinality limits after it was last written, 0 keeps it forever")
+	flag.StringVar(&cardinalityAction, "cardinalityAction", CARDINALITY_DROP, "Action on new series beyond the cardinality limits, one of drop,overflow")
+	flag.IntVar(&pushMaxRetries, "pushMaxRetries", 3, "Max retries of a Pushgateway push on connection errors, 5xx and 429")
+	flag.DurationVar(&pushMinBackoff, "pushMinBackoff", 100*time.Millisecond, "Initial Pushgateway push retry backoff")
+	flag.DurationVar(&pushMaxBackoff, "pushMaxBackoff", 5*time.Second, "Max Pushgateway push retry backoff")
+	flag.StringVar(&bufferDir, "bufferDir", "", "Directory of the write-ahead buffer keeping the metrics while the backend is unavailable, empty disables")
+	flag.Int64Var(&bufferMaxBytes, "bufferMaxBytes", 100<<20, "Max size of the write-ahead buffer in bytes, datums fail once it is full")
+	flag.DurationVar(&bufferReplayInterval, "bufferReplayInterval", 10*time.Second, "Interval between replays of the write-ahead buffer")
+	flag.StringVar(&pushMethod, "pushMethod", PUSH_METHOD_PUSH, "Pushgateway method, push replaces all metrics of a group, add only the pushed ones")
+	flag.DurationVar(&staleGroupTimeout, "staleGroupTimeout", 0, "Delete the groups pushed by the sink that are not updated within this time, 0 disables")
+	flag.DurationVar(&staleGroupInterval, "staleGroupCheckInterval", time.Minute, "Interval between stale group checks")
+	flag.StringVar(&pgwConfig.usernameFile, "pushgatewayUsernameFile", "", "File with the Pushgateway basic auth username")
+	flag.StringVar(&pgwConfig.passwordFile, "pushgatewayPasswordFile", "", "File with the Pushgateway basic auth password")
+	flag.StringVar(&pgwConfig.bearerTokenFile, "pushgatewayBearerTokenFile", "", "File with the Pushgateway bearer token")
+	flag.StringVar(&pgwConfig.caFile, "pushgatewayCAFile", "", "CA certificate file to verify the Pushgateway")
+	flag.StringVar(&pgwConfig.certFile, "pushgatewayCertFile", "", "Client certificate file for Pushgateway mTLS")
+	flag.StringVar(&pgwConfig.keyFile, "pushgatewayKeyFile", "", "Client key file for Pushgateway mTLS")
+	flag.BoolVar(&pgwConfig.insecureSkipVerify, "pushgatewayInsecureSkipVerify", false, "Skip the Pushgateway certificate verification")
+	flag.Var((*numaflag.MapFlag)(&pgwConfig.headers), "pushgatewayHeaders", "Headers sent to the Pushgateway E.g: X-Org=org1,X-Team=team1")
+	flag.DurationVar(&pgwConfig.timeout, "pushgatewayTimeout", 30*time.Second, "Pushgateway request timeout")
+	flag.StringVar(&outputMode, "outputMode", OUTPUT_PUSHGATEWAY, "Output mode, one of pushgateway,remote-write,pull,otlp,influx,statsd")
+	flag.IntVar(&rw.batchSize, "remoteWriteBatchSize", 500, "Max number of series in a remote write request")
+	flag.IntVar(&rw.maxRetries, "remoteWriteMaxRetries", 3, "Max retries of a remote write request on 5xx and 429")
+	flag.DurationVar(&rw.minBackoff, "remoteWriteMinBackoff", 100*time.Millisecond, "Initial remote write retry backoff")
+	flag.DurationVar(&rw.maxBackoff, "remoteWriteMaxBackoff", 5*time.Second, "Max remote write retry backoff")
+	remoteWriteTimeout := flag.Duration("remoteWriteTimeout", 30*time.Second, "Remote write request timeout")
+	flag.StringVar(&tenantHeader, "remoteWriteTenantHeader", "X-Scope-OrgID", "Header carrying the REMOTE_WRITE_TENANT")
+	flag.IntVar(&otlp.batchSize, "otlpBatchSize", 500, "Max number of data points in an OTLP export request")
+	flag.IntVar(&otlp.maxRetries, "otlpMaxRetries", 3, "Max retries of an OTLP export request on 429, 502, 503 and 504")
+	flag.DurationVar(&otlp.minBackoff, "otlpMinBackoff", 100*time.Millisecond, "Initial OTLP export retry backoff")
+	flag.DurationVar(&otlp.maxBackoff, "otlpMaxBackoff", 5*time.Second, "Max OTLP export retry backoff")
+	flag.DurationVar(&otlp.startTTL, "otlpStartTTL", time.Hour, "Time the start time of a cumulative OTLP series is kept after it was last written, 0 keeps it forever")
+	otlpTimeout := flag.Duration("otlpTimeout", 30*time.Second, "OTLP export request timeout")
+	flag.StringVar(&otlp.compression, "otlpCompression", OTLP_COMPRESSION_GZIP, "Compression of the OTLP export requests, one of gzip,none")
+	flag.Var((*numaflag.MapFlag)(&otlp.headers), "otlpHeaders", "Headers sent with the OTLP export requests E.g: Authorization=Bearer xyz")
+	flag.StringVar(&influx.org, "influxOrg", "", "InfluxDB organization the metrics are written to")
+	flag.StringVar(&influx.bucket, "influxBucket", "", "InfluxDB bucket the metrics are written to")
+	flag.StringVar(&influx.precision, "influxPrecision", INFLUX_PRECISION_MS, "Precision of the InfluxDB timestamps, one of ns,us,ms,s")
+	flag.IntVar(&influx.batchSize, "influxBatchSize", 500, "Max number of lines in an InfluxDB write request")
+	flag.IntVar(&influx.maxRetries, "influxMaxRetries", 3, "Max retries of an InfluxDB write request on 5xx and 429")
+	flag.DurationVar(&influx.minBackoff, "influxMinBackoff", 100*time.Millisecond, "Initial InfluxDB write retry backoff")
+	flag.DurationVar(&influx.maxBackoff, "influxMaxBackoff", 5*time.Second, "Max InfluxDB write retry backoff")
+	influxTimeout := flag.Duration("influxTimeout", 30*time.Second, "InfluxDB write request timeout")
+	flag.StringVar(&statsdFlavor, "statsdFlavor", STATSD_FLAVOR_DOGSTATSD, "StatsD tag format, dogstatsd for |#key:value tags, statsd for ;key=value Graphite tags")
+	flag.StringVar(&statsdPrefix, "statsdPrefix", "", "Prefix of the StatsD metric names E.g: numaflow.")
+	flag.IntVar(&statsdMaxPacketSize, "statsdMaxPacketSize", 1432, "Max size of a StatsD datagram in bytes")
+	flag.DurationVar(&statsdCounterTTL, "statsdCounterTTL", time.Hour, "Time the last value of a StatsD counter series is kept after it was last written, 0 keeps it forever")
+	flag.IntVar(&pullPort, "pullPort", 9091, "Port serving the received metrics in pull mode")
+	flag.StringVar(&pullPath, "pullPath", "/metrics", "Path serving the received metrics in pull mode")
+	flag.DurationVar(&pullTTL, "pullTTL", 5*time.Minute, "Time a series is served in pull mode after its last update, 0 keeps it forever")
+	flag.StringVar(&jobNameExpr, "jobNameExpr", "", `Expression rendering the Pushgateway job name from name, namespace, subsystem, type and labels E.g: join("_", namespace, labels.app, name)`)
+	flag.Var(&groupingLabels, "groupingLabels", "Labels forming the Pushgateway grouping key, other labels become series labels E.g: app,namespace")
+	flag.Var(&seriesLabels, "seriesLabels", "Labels always sent as series labels instead of grouping labels E.g: pod,instance")
 	flag.Var(&meticslabels, "udsinkMetricsLabels", "Sink Metrics Labels E.g: label=val1,label1=val2")
 	// Parse the flag
 	flag.Parse()
@@ -227,11 +556,143 @@ func main() {
 	}
 
 	ps := prometheusSink{logger: logger, skipFailed: skipFailed, labels: labels, excludeLabels: excludeLabels,
-		ignoreMetricsTs: ignoreMetricsTs, metricsName: metricName, enableMsgTransformer: enableMsgTransformer}
-
+		ignoreMetricsTs: ignoreMetricsTs,
+		pushConcurrency: pushConcurrency, outputMode: outputMode,
+		grouping: groupingConfig{groupingLabels: groupingLabels, seriesLabels: seriesLabels}}
 	ps.metrics = NewMetricsServer(labels, opexMetricsPrefix)
+	if enableMsgTransformer {
+		config, err := loadTransformerConfig(transformerConfigFile, transformerPreset)
+		if err != nil {
+			log.Panic("Failed to load the message transformer config: ", err)
+		}
+		overrides.apply(&config)
+		if ps.transformer, err = newTransformer(config, metricName); err != nil {
+			log.Panic("Invalid message transformer config: ", err)
+		}
+	}
+	switch inputFormat {
+	case INPUT_JSON, INPUT_PROMETHEUS, INPUT_OPENMETRICS, INPUT_AUTO:
+		ps.inputFormat = inputFormat
+	default:
+		log.Panicf("Unsupported input format %q", inputFormat)
+	}
+	if relabelConfigFile != "" {
+		if ps.relabeler, err = loadRelabelConfig(relabelConfigFile); err != nil {
+			log.Panic("Failed to load the relabel config: ", err)
+		}
+	}
+	if err := ps.grouping.validate(); err != nil {
+		log.Panic(err)
+	}
+	if ps.grouping.jobNamer, err = newJobNamer(logger.Named("job-name"), jobNameExpr, ps.metrics); err != nil {
+		log.Panic(err)
+	}
+	if ps.aggregator, err = newAggregator(aggregation, aggregationCompanions); err != nil {
+		log.Panic(err)
+	}
+	if ps.limiter, err = newCardinalityLimiter(logger.Named("cardinality"), cardinalityMetricLimit, cardinalityGlobalLimit, cardinalityWindow, cardinalityAction,
+		ps.grouping.groupingLabels); err != nil {
+		log.Panic(err)
+	}
+	switch validation {
+	case VALIDATION_SANITIZE, VALIDATION_REJECT, VALIDATION_FAIL:
+		ps.validation = validation
+	default:
+		log.Panicf("Unsupported validation mode %q", validation)
+	}
+	switch outputMode {
+	case OUTPUT_PUSHGATEWAY:
+		if pushMethod != PUSH_METHOD_PUSH && pushMethod != PUSH_METHOD_ADD {
+			log.Panicf("Unsupported push method %q", pushMethod)
+		}
+		ps.pushMethod = pushMethod
+		ps.pushMaxRetries, ps.pushMinBackoff, ps.pushMaxBackoff = pushMaxRetries, pushMinBackoff, pushMaxBackoff
+		ps.pushgateway, err = newPushgatewayClient(pgwConfig)
+		if err != nil {
+			log.Panic("Failed to configure the Pushgateway client: ", err)
+		}
+		if staleGroupTimeout > 0 {
+			ps.janitor = newGroupJanitor(logger.Named("janitor"), staleGroupTimeout, staleGroupInterval, ps.deleteGroup)
+		}
+	case OUTPUT_REMOTE_WRITE:
+		server, ok := os.LookupEnv(PROMETHEUS_SERVER)
+		if !ok {
+			log.Panic("Prometheus URL not found")
+		}
+		rw.logger = logger.Named("remote-write")
+		rw.client = &http.Client{Timeout: *remoteWriteTimeout}
+		rw.url = server
+		rw.tenantHeader = tenantHeader
+		ps.remoteWriter = rw
+	case OUTPUT_OTLP:
+		server, ok := os.LookupEnv(PROMETHEUS_SERVER)
+		if !ok {
+			log.Panic("OTLP metrics URL not found")
+		}
+		if otlp.compression != OTLP_COMPRESSION_GZIP && otlp.compression != OTLP_COMPRESSION_NONE {
+			log.Panicf("Unsupported OTLP compression %q", otlp.compression)
+		}
+		otlp.logger = logger.Named("otlp")
+		otlp.client = &http.Client{Timeout: *otlpTimeout}
+		otlp.url = server
+		otlp.resource = labels
+		ps.otlpExporter = otlp
+	case OUTPUT_INFLUX:
+		server, ok := os.LookupEnv(PROMETHEUS_SERVER)
+		if !ok {
+			log.Panic("InfluxDB URL not found")
+		}
+		if influx.bucket == "" {
+			log.Panic("InfluxDB bucket not set, use -influxBucket")
+		}
+		switch influx.precision {
+		case INFLUX_PRECISION_NS, INFLUX_PRECISION_US, INFLUX_PRECISION_MS, INFLUX_PRECISION_S:
+		default:
+			log.Panicf("Unsupported InfluxDB precision %q", influx.precision)
+		}
+		influx.logger = logger.Named("influx")
+		influx.client = &http.Client{Timeout: *influxTimeout}
+		influx.url = server
+		if _, err := influx.writeURL(); err != nil {
+			log.Panic("Invalid InfluxDB URL: ", err)
+		}
+		ps.influxWriter = influx
+	case OUTPUT_STATSD:
+		server, ok := os.LookupEnv(PROMETHEUS_SERVER)
+		if !ok {
+			log.Panic("StatsD address not found")
+		}
+		conn, err := net.Dial("udp", server)
+		if err != nil {
+			log.Panic("Failed to connect to StatsD: ", err)
+		}
+		if ps.statsdWriter, err = newStatsdWriter(logger.Named("statsd"), conn, statsdFlavor, statsdPrefix, statsdMaxPacketSize,
+			statsdCounterTTL); err != nil {
+			log.Panic(err)
+		}
+	case OUTPUT_PULL:
+		ps.pullStore = newPullStore(logger.Named("pull"), pullTTL, ignoreMetricsTs)
+		go func() {
+			if err := ps.pullStore.startServer(pullPort, pullPath); err != nil {
+				log.Panic("Failed to start pull server: ", err)
+			}
+		}()
+		ps.logger.Infof("Serving received metrics on port=%d path=%s", pullPort, pullPath)
+	default:
+		log.Panicf("Unsupported output mode %q", outputMode)
+	}
+
 	go ps.metrics.startMetricServer(metricPort)
 	ps.logger.Infof("Metrics publisher initialized with port=%d", metricPort)
+	if bufferDir != "" {
+		if ps.buffer, err = openWALBuffer(logger.Named("buffer"), bufferDir, bufferMaxBytes, ps.metrics); err != nil {
+			log.Panic("Failed to open the write-ahead buffer: ", err)
+		}
+		go ps.buffer.start(context.Background(), bufferReplayInterval, ps.write)
+	}
+	if ps.janitor != nil {
+		go ps.janitor.start(context.Background())
+	}
 	err = sinksdk.NewServer(&ps).Start(context.Background())
 	if err != nil {
 		log.Panic("Failed to start sink function server: ", err)
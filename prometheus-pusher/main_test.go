@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/numaproj/numaflow/pkg/shared/logging"
@@ -60,14 +61,14 @@ func TestPusher(t *testing.T) {
 	// Fake a Pushgateway that responds with 202 to DELETE and with 200 in
 	// all other cases.
 
-	var metrics []io_prometheus_client.MetricFamily
+	var metrics []*io_prometheus_client.MetricFamily
 
 	pgwOK := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
 
-			var mf io_prometheus_client.MetricFamily
-			dec.Decode(&mf)
+			mf := &io_prometheus_client.MetricFamily{}
+			dec.Decode(mf)
 			metrics = append(metrics, mf)
 			fmt.Println(mf)
 			w.Header().Set("Content-Type", `text/plain; charset=utf-8`)
@@ -97,8 +98,16 @@ func TestPusher(t *testing.T) {
 		Help:        "The total count of anomaly score generator",
 		ConstLabels: nil,
 	})
+	ps.metrics.groupsTotalSuccess = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "total_groups_success_1",
+		Help: "The total number of groups successfully pushed",
+	})
+	ps.metrics.groupsTotalFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "total_groups_failed_1",
+		Help: "The total number of groups failed push",
+	})
 	defer func() { ps.metrics.metricsAnomalyGenerated = nil }()
-	ps.push(pl)
+	ps.push(context.Background(), pl)
 
 	assert.Equal(t, pl[0].Name, metrics[0].GetName())
 	assert.Equal(t, strings.ToUpper(pl[0].Type), metrics[0].Type.String())
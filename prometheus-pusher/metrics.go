@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -15,6 +16,17 @@ type MetricsPublisher struct {
 	metricsTotalFailed      prometheus.Counter
 	metricsTotalSkipped     prometheus.Counter
 	metricsAnomalyGenerated *prometheus.CounterVec
+	metricsTotalRejected    *prometheus.CounterVec
+	groupsTotalSuccess      prometheus.Counter
+	groupsTotalFailed       prometheus.Counter
+	groupsTotalDeleted      prometheus.Counter
+	exemplarsTotalDropped   prometheus.Counter
+	seriesTotalRejected     *prometheus.CounterVec
+	metricsTotalDropped     prometheus.Counter
+	bufferDepth             prometheus.Gauge
+	bufferOldestAge         prometheus.Gauge
+	bufferTotalDropped      prometheus.Counter
+	jobNameTotalFallback    *prometheus.CounterVec
 	labels                  map[string]string
 	opexMetricPrefix        string
 }
@@ -46,6 +58,61 @@ func (mp *MetricsPublisher) registerMertics() {
 		Help:        "The total count of anomaly score generator",
 		ConstLabels: mp.labels,
 	}, []string{"namespace", "app", "metrics"})
+	mp.metricsTotalRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_metrics_rejected",
+		Help:        "The total number of metrics rejected by validation",
+		ConstLabels: mp.labels,
+	}, []string{"reason"})
+	mp.groupsTotalSuccess = promauto.NewCounter(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_groups_success",
+		Help:        "The total number of groups successfully pushed",
+		ConstLabels: mp.labels,
+	})
+	mp.groupsTotalFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_groups_failed",
+		Help:        "The total number of groups failed push",
+		ConstLabels: mp.labels,
+	})
+	mp.groupsTotalDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_groups_deleted",
+		Help:        "The total number of groups deleted",
+		ConstLabels: mp.labels,
+	})
+	mp.exemplarsTotalDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_exemplars_dropped",
+		Help:        "The total number of exemplars dropped because the output cannot store them",
+		ConstLabels: mp.labels,
+	})
+	mp.seriesTotalRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_series_rejected",
+		Help:        "The total number of new series beyond the cardinality limits",
+		ConstLabels: mp.labels,
+	}, []string{"limit"})
+	mp.metricsTotalDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_metrics_dropped",
+		Help:        "The total number of metrics dropped by relabel rules",
+		ConstLabels: mp.labels,
+	})
+	mp.bufferDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        mp.opexMetricPrefix + "_" + "buffer_depth",
+		Help:        "The number of metrics in the write-ahead buffer",
+		ConstLabels: mp.labels,
+	})
+	mp.bufferOldestAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        mp.opexMetricPrefix + "_" + "buffer_oldest_age_seconds",
+		Help:        "The age of the oldest entry of the write-ahead buffer",
+		ConstLabels: mp.labels,
+	})
+	mp.bufferTotalDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_buffer_dropped",
+		Help:        "The total number of buffered metrics rejected by the backend on replay",
+		ConstLabels: mp.labels,
+	})
+	mp.jobNameTotalFallback = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:        mp.opexMetricPrefix + "_" + "total_job_name_fallback",
+		Help:        "The total number of metrics pushed with the fallback job name because of the job name expression",
+		ConstLabels: mp.labels,
+	}, []string{"reason"})
 }
 
 func (mp *MetricsPublisher) IncreaseTotalPushed() {
@@ -60,6 +127,44 @@ func (mp *MetricsPublisher) IncreaseTotalFailed() {
 func (mp *MetricsPublisher) IncreaseTotalSkipped() {
 	mp.metricsTotalSkipped.Inc()
 }
+func (mp *MetricsPublisher) IncreaseTotalDropped() {
+	mp.metricsTotalDropped.Inc()
+}
+
+func (mp *MetricsPublisher) IncreaseTotalRejected(reason string) {
+	mp.metricsTotalRejected.WithLabelValues(reason).Inc()
+}
+
+func (mp *MetricsPublisher) IncreaseGroupSuccess() {
+	mp.groupsTotalSuccess.Inc()
+}
+func (mp *MetricsPublisher) IncreaseGroupFailed() {
+	mp.groupsTotalFailed.Inc()
+}
+func (mp *MetricsPublisher) IncreaseGroupDeleted() {
+	mp.groupsTotalDeleted.Inc()
+}
+
+func (mp *MetricsPublisher) IncreaseExemplarsDropped(count int) {
+	mp.exemplarsTotalDropped.Add(float64(count))
+}
+
+func (mp *MetricsPublisher) IncreaseSeriesRejected(limit string, count int) {
+	mp.seriesTotalRejected.WithLabelValues(limit).Add(float64(count))
+}
+
+func (mp *MetricsPublisher) SetBufferState(depth int, oldestAge time.Duration) {
+	mp.bufferDepth.Set(float64(depth))
+	mp.bufferOldestAge.Set(oldestAge.Seconds())
+}
+
+func (mp *MetricsPublisher) IncreaseBufferDropped(count int) {
+	mp.bufferTotalDropped.Add(float64(count))
+}
+
+func (mp *MetricsPublisher) IncreaseJobNameFallback(reason string) {
+	mp.jobNameTotalFallback.WithLabelValues(reason).Inc()
+}
 
 func (mp *MetricsPublisher) IncreaseAnomalyGenerated(namespace, app, metricName string) {
 	mp.metricsAnomalyGenerated.WithLabelValues(namespace, app, metricName).Inc()
@@ -4,6 +4,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestMetricsPublisher(t *testing.T) {
@@ -12,6 +13,16 @@ func TestMetricsPublisher(t *testing.T) {
 	mp.IncreaseTotalSuccess()
 	mp.IncreaseTotalSkipped()
 	mp.IncreaseTotalFailed()
+	mp.IncreaseGroupSuccess()
+	mp.IncreaseGroupFailed()
+	mp.IncreaseGroupDeleted()
+	mp.IncreaseExemplarsDropped(3)
+	mp.IncreaseTotalDropped()
+	mp.IncreaseSeriesRejected(limitMetric, 2)
+	mp.IncreaseTotalRejected(reasonInvalidMetricName)
+	mp.SetBufferState(4, 90*time.Second)
+	mp.IncreaseBufferDropped(2)
+	mp.IncreaseJobNameFallback(jobNameFallbackError)
 	mp.IncreaseAnomalyGenerated("test", "app1", "anomaly1")
 	mp.IncreaseAnomalyGenerated("test1", "app2", "anomaly1")
 	mp.IncreaseAnomalyGenerated("test", "app1", "anomaly1")
@@ -19,6 +30,17 @@ func TestMetricsPublisher(t *testing.T) {
 	assert.Equal(t, float64(1), testutil.ToFloat64(mp.metricsTotalSuccess))
 	assert.Equal(t, float64(1), testutil.ToFloat64(mp.metricsTotalFailed))
 	assert.Equal(t, float64(1), testutil.ToFloat64(mp.metricsTotalSkipped))
+	assert.Equal(t, float64(1), testutil.ToFloat64(mp.groupsTotalSuccess))
+	assert.Equal(t, float64(1), testutil.ToFloat64(mp.groupsTotalFailed))
+	assert.Equal(t, float64(1), testutil.ToFloat64(mp.groupsTotalDeleted))
+	assert.Equal(t, float64(3), testutil.ToFloat64(mp.exemplarsTotalDropped))
+	assert.Equal(t, float64(1), testutil.ToFloat64(mp.metricsTotalDropped))
+	assert.Equal(t, float64(4), testutil.ToFloat64(mp.bufferDepth))
+	assert.Equal(t, float64(90), testutil.ToFloat64(mp.bufferOldestAge))
+	assert.Equal(t, float64(2), testutil.ToFloat64(mp.bufferTotalDropped))
+	assert.Equal(t, float64(1), testutil.ToFloat64(mp.jobNameTotalFallback.WithLabelValues(jobNameFallbackError)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(mp.seriesTotalRejected.WithLabelValues(limitMetric)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(mp.metricsTotalRejected.WithLabelValues(reasonInvalidMetricName)))
 	assert.Equal(t, float64(2), testutil.ToFloat64(mp.metricsAnomalyGenerated.WithLabelValues("test", "app1", "anomaly1")))
 	assert.Equal(t, float64(0), testutil.ToFloat64(mp.metricsAnomalyGenerated.WithLabelValues("test1", "app1", "anomaly1")))
 	assert.Equal(t, float64(1), testutil.ToFloat64(mp.metricsAnomalyGenerated.WithLabelValues("test1", "app2", "anomaly1")))
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLP compressions selected with -otlpCompression
+const (
+	OTLP_COMPRESSION_GZIP = "gzip"
+	OTLP_COMPRESSION_NONE = "none"
+)
+
+// otlpScopeName is the instrumentation scope of the exported metrics
+const otlpScopeName = "numaflow-prometheus-sink"
+
+// Exemplar labels carrying the trace context, sent as the trace and span IDs of OTLP exemplars
+const (
+	exemplarTraceID = "trace_id"
+	exemplarSpanID  = "span_id"
+)
+
+// otlpAttributes returns the labels as attributes with string values, sorted by key.
+func otlpAttributes(labels map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attributes := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: labels[key]}}})
+	}
+	return attributes
+}
+
+// otlpExemplars returns the exemplars of the payload. Valid trace_id and span_id labels become the trace
+// context of the exemplar, the other labels its filtered attributes.
+func otlpExemplars(payload PrometheusPayload, timeNano uint64) []*metricspb.Exemplar {
+	var exemplars []*metricspb.Exemplar
+	for _, e := range payload.Exemplars {
+		attributes := make(map[string]string, len(e.Labels))
+		exemplar := &metricspb.Exemplar{TimeUnixNano: timeNano, Value: &metricspb.Exemplar_AsDouble{AsDouble: e.Value}}
+		for name, value := range e.Labels {
+			id, err := hex.DecodeString(value)
+			switch {
+			case name == exemplarTraceID && err == nil && len(id) == 16:
+				exemplar.TraceId = id
+			case name == exemplarSpanID && err == nil && len(id) == 8:
+				exemplar.SpanId = id
+			default:
+				attributes[name] = value
+			}
+		}
+		exemplar.FilteredAttributes = otlpAttributes(attributes)
+		if e.TimestampMs != 0 {
+			exemplar.TimeUnixNano = uint64(e.TimestampMs) * uint64(time.Millisecond)
+		}
+		exemplars = append(exemplars, exemplar)
+	}
+	return exemplars
+}
+
+// otlpSeriesStart is the start time of a cumulative series, its last value and when it was last seen.
+type otlpSeriesStart struct {
+	timeNano uint64
+	value    float64
+	lastSeen time.Time
+}
+
+// startTime returns the start_time_unix_nano of a cumulative series. It is the time the series was first seen, or
+// the time of the last reset, detected when the value goes down, so backends can tell a reset from an increase.
+func (e *otlpExporter) startTime(payload PrometheusPayload, timeNano uint64, value float64) uint64 {
+	key := groupKey(payload.metricType()+"\xff"+payload.Name, payload.Labels)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.starts == nil {
+		e.starts = make(map[string]otlpSeriesStart)
+	}
+	start, ok := e.starts[key]
+	if !ok || value < start.value {
+		start.timeNano = timeNano
+	}
+	start.value = value
+	start.lastSeen = time.Now()
+	e.starts[key] = start
+	return start.timeNano
+}
+
+// expireStarts forgets the start time of the series not seen within the start TTL, a series seen again later
+// starts anew.
+func (e *otlpExporter) expireStarts(now time.Time) {
+	if e.startTTL <= 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, start := range e.starts {
+		if now.Sub(start.lastSeen) > e.startTTL {
+			delete(e.starts, key)
+		}
+	}
+}
+
+// metric converts the payload into a metric with a single data point of its metric type. Gauges and untyped metrics
+// are OTLP gauges, counters cumulative monotonic sums.
+func (e *otlpExporter) metric(payload PrometheusPayload, timeNano uint64) (*metricspb.Metric, error) {
+	metric := &metricspb.Metric{Name: payload.Name, Description: payload.Help}
+	switch payload.metricType() {
+	case metricTypeGauge, metricTypeUntyped, metricTypeCounter:
+		if err := validateCounter(payload); err != nil {
+			return nil, err
+		}
+		dp := &metricspb.NumberDataPoint{
+			Attributes:   otlpAttributes(payload.Labels),
+			TimeUnixNano: timeNano,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: payload.Value},
+			Exemplars:    otlpExemplars(payload, timeNano),
+		}
+		if payload.metricType() != metricTypeCounter {
+			metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{dp}}}
+			return metric, nil
+		}
+		dp.StartTimeUnixNano = e.startTime(payload, timeNano, payload.Value)
+		metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			DataPoints:             []*metricspb.NumberDataPoint{dp},
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+		}}
+		return metric, nil
+	case metricTypeHistogram:
+		buckets, err := parseBuckets(payload)
+		if err != nil {
+			return nil, err
+		}
+		bounds := make([]float64, 0, len(buckets))
+		for bound := range buckets {
+			if !math.IsInf(bound, 1) {
+				bounds = append(bounds, bound)
+			}
+		}
+		sort.Float64s(bounds)
+		// OTLP bucket counts are not cumulative and end with the +Inf bucket
+		counts := make([]uint64, 0, len(bounds)+1)
+		var previous uint64
+		for _, bound := range bounds {
+			counts = append(counts, buckets[bound]-previous)
+			previous = buckets[bound]
+		}
+		counts = append(counts, payload.Count-previous)
+		sum := payload.Sum
+		dp := &metricspb.HistogramDataPoint{
+			Attributes:        otlpAttributes(payload.Labels),
+			StartTimeUnixNano: e.startTime(payload, timeNano, float64(payload.Count)),
+			TimeUnixNano:      timeNano,
+			Count:             payload.Count,
+			Sum:               &sum,
+			BucketCounts:      counts,
+			ExplicitBounds:    bounds,
+			Exemplars:         otlpExemplars(payload, timeNano),
+		}
+		metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			DataPoints:             []*metricspb.HistogramDataPoint{dp},
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		}}
+		return metric, nil
+	case metricTypeSummary:
+		quantiles, err := parseQuantiles(payload)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]float64, 0, len(quantiles))
+		for quantile := range quantiles {
+			keys = append(keys, quantile)
+		}
+		sort.Float64s(keys)
+		dp := &metricspb.SummaryDataPoint{
+			Attributes:        otlpAttributes(payload.Labels),
+			StartTimeUnixNano: e.startTime(payload, timeNano, float64(payload.Count)),
+			TimeUnixNano:      timeNano,
+			Count:             payload.Count,
+			Sum:               payload.Sum,
+		}
+		for _, quantile := range keys {
+			dp.QuantileValues = append(dp.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{Quantile: quantile, Value: quantiles[quantile]})
+		}
+		metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{dp}}}
+		return metric, nil
+	}
+	return nil, fmt.Errorf("unsupported Metrics Type %q", payload.Type)
+}
+
+// appendDataPoints adds the data points of src to dst, a metric with the same name and data type.
+func appendDataPoints(dst, src *metricspb.Metric) {
+	switch data := dst.Data.(type) {
+	case *metricspb.Metric_Gauge:
+		data.Gauge.DataPoints = append(data.Gauge.DataPoints, src.GetGauge().GetDataPoints()...)
+	case *metricspb.Metric_Sum:
+		data.Sum.DataPoints = append(data.Sum.DataPoints, src.GetSum().GetDataPoints()...)
+	case *metricspb.Metric_Histogram:
+		data.Histogram.DataPoints = append(data.Histogram.DataPoints, src.GetHistogram().GetDataPoints()...)
+	case *metricspb.Metric_Summary:
+		data.Summary.DataPoints = append(data.Summary.DataPoints, src.GetSummary().GetDataPoints()...)
+	}
+}
+
+// metricsRequest returns an ExportMetricsServiceRequest of the metrics with a single resource and scope.
+func metricsRequest(resource map[string]string, metrics []*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: otlpAttributes(resource)},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{
+			Scope:   &commonpb.InstrumentationScope{Name: otlpScopeName},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+// otlpExporter sends payloads to an OTLP/HTTP metrics endpoint such as the OpenTelemetry Collector.
+// The static labels are sent as resource attributes, the payload labels as data point attributes.
+type otlpExporter struct {
+	logger      *zap.SugaredLogger
+	client      *http.Client
+	url         string
+	headers     map[string]string
+	resource    map[string]string
+	compression string
+	batchSize   int
+	maxRetries  int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	startTTL    time.Duration
+
+	mu sync.Mutex
+	// starts is the start time of each cumulative series seen within startTTL
+	starts map[string]otlpSeriesStart
+}
+
+// write sends the payloads in batches of batchSize data points. A failed batch only fails the datums of its payloads,
+// the other batches are still sent.
+func (e *otlpExporter) write(ctx context.Context, payloads []PrometheusPayload) error {
+	type dataPoint struct {
+		payload PrometheusPayload
+		metric  *metricspb.Metric
+	}
+	var points []dataPoint
+	var firstErr error
+	werr := &writeError{}
+	e.expireStarts(time.Now())
+	now := uint64(time.Now().UnixNano())
+	for _, payload := range payloads {
+		ts := now
+		if payload.TimestampMs != 0 {
+			ts = uint64(payload.TimestampMs) * uint64(time.Millisecond)
+		}
+		metric, err := e.metric(payload, ts)
+		if err != nil {
+			e.logger.Errorw("Invalid metric", zap.Any("payload", payload), zap.Error(err))
+			for _, datum := range payload.datums() {
+				werr.fail(datum, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		points = append(points, dataPoint{payload: payload, metric: metric})
+	}
+	batchSize := e.batchSize
+	if batchSize < 1 {
+		batchSize = len(points)
+	}
+	failedBatches := 0
+	for start := 0; start < len(points); start += batchSize {
+		end := min(start+batchSize, len(points))
+		var metrics []*metricspb.Metric
+		byName := make(map[string]*metricspb.Metric)
+		for _, point := range points[start:end] {
+			key := fmt.Sprintf("%s\xff%T", point.metric.Name, point.metric.Data)
+			if metric, ok := byName[key]; ok {
+				appendDataPoints(metric, point.metric)
+				continue
+			}
+			byName[key] = point.metric
+			metrics = append(metrics, point.metric)
+		}
+		body, err := proto.Marshal(metricsRequest(e.resource, metrics))
+		if err == nil {
+			err = e.send(ctx, body)
+		}
+		if err != nil {
+			e.logger.Errorw("OTLP export failed", zap.Int("dataPoints", end-start), zap.Error(err))
+			failedBatches++
+			if firstErr == nil {
+				firstErr = err
+			}
+			for _, point := range points[start:end] {
+				for _, datum := range point.payload.datums() {
+					werr.fail(datum, err)
+				}
+			}
+			continue
+		}
+		e.logger.Debugf("OTLP export sent %d data points", end-start)
+	}
+	if len(werr.datums) > 0 {
+		werr.err = fmt.Errorf("OTLP export failed for %d datums in %d batches: %w", len(werr.datums), failedBatches, firstErr)
+		return werr
+	}
+	return nil
+}
+
+// send posts one ExportMetricsServiceRequest, retrying the responses the OTLP specification marks as retryable.
+func (e *otlpExporter) send(ctx context.Context, body []byte) error {
+	if e.compression == OTLP_COMPRESSION_GZIP {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	return retryRequest(ctx, e.logger, "OTLP export", e.maxRetries, e.minBackoff, e.maxBackoff, func() (time.Duration, bool, error) {
+		return e.sendOnce(ctx, body)
+	})
+}
+
+func (e *otlpExporter) sendOnce(ctx context.Context, body []byte) (time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "numaflow-prometheus-sink")
+	if e.compression == OTLP_COMPRESSION_GZIP {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer res.Body.Close()
+	retryAfter, retryable, err := responseError(res, "OTLP export", func(status int) bool {
+		switch status {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return retryAfter, retryable, err
+	}
+	if body, err := io.ReadAll(res.Body); err == nil {
+		var response colmetricspb.ExportMetricsServiceResponse
+		if err := proto.Unmarshal(body, &response); err == nil && response.GetPartialSuccess().GetRejectedDataPoints() > 0 {
+			e.logger.Warnw("OTLP export partially rejected", zap.Int64("rejectedDataPoints", response.GetPartialSuccess().GetRejectedDataPoints()),
+				zap.String("message", response.GetPartialSuccess().GetErrorMessage()))
+		}
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpTestMetrics returns the metrics of the request keyed by name.
+func otlpTestMetrics(request *colmetricspb.ExportMetricsServiceRequest) map[string]*metricspb.Metric {
+	metrics := make(map[string]*metricspb.Metric)
+	for _, rm := range request.GetResourceMetrics() {
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				metrics[metric.GetName()] = metric
+			}
+		}
+	}
+	return metrics
+}
+
+// otlpTestAttributes returns the string attributes as a map.
+func otlpTestAttributes(attributes []*commonpb.KeyValue) map[string]string {
+	result := make(map[string]string)
+	for _, kv := range attributes {
+		result[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	return result
+}
+
+// otlpReceiver is an OTLP/HTTP receiver decoding the requests with the OTLP protos.
+type otlpReceiver struct {
+	mu       sync.Mutex
+	requests []*colmetricspb.ExportMetricsServiceRequest
+	headers  []http.Header
+	statuses []int
+	response []byte
+}
+
+func (or *otlpReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+	if len(or.statuses) > 0 {
+		status := or.statuses[0]
+		or.statuses = or.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	var reader io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reader = zr
+	}
+	body, _ := io.ReadAll(reader)
+	request := &colmetricspb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	or.headers = append(or.headers, r.Header.Clone())
+	or.requests = append(or.requests, request)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(or.response)
+}
+
+func newTestOTLPExporter(url string) *otlpExporter {
+	return &otlpExporter{
+		logger:      logging.NewLogger().Named("otlp"),
+		client:      http.DefaultClient,
+		url:         url,
+		headers:     map[string]string{"Authorization": "Bearer token"},
+		resource:    map[string]string{"cluster": "prod"},
+		compression: OTLP_COMPRESSION_GZIP,
+		batchSize:   3,
+		maxRetries:  2,
+		minBackoff:  time.Millisecond,
+		maxBackoff:  time.Millisecond,
+		startTTL:    time.Hour,
+	}
+}
+
+func TestOTLPExporter_Write(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	exporter := newTestOTLPExporter(server.URL)
+	payloads := []PrometheusPayload{
+		{Name: "anomaly_score", Help: "Anomaly score.", Type: "Gauge", Value: 0.49, TimestampMs: 1680124991883, Labels: map[string]string{"app": "web"}},
+		{Name: "anomaly_score", Type: "Gauge", Value: 0.2, TimestampMs: 1680124991883, Labels: map[string]string{"app": "api"}},
+		{Name: "requests_total", Type: "Counter", Value: 7, TimestampMs: 1680124991884},
+		{Name: "latency_seconds", Type: "Histogram", Count: 10, Sum: 4.2, TimestampMs: 1680124991885, Buckets: map[string]uint64{"1": 9, "0.1": 2}},
+		{Name: "rpc_seconds", Type: "Summary", Count: 7, Sum: 1.4, TimestampMs: 1680124991886, Quantiles: map[string]float64{"0.5": 0.2, "0.99": 0.9}},
+	}
+	assert.NoError(t, exporter.write(context.Background(), payloads))
+
+	// 5 data points, batched by 3
+	assert.Len(t, receiver.requests, 2)
+	assert.Equal(t, "gzip", receiver.headers[0].Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", receiver.headers[0].Get("Content-Type"))
+	assert.Equal(t, "Bearer token", receiver.headers[0].Get("Authorization"))
+	rm := receiver.requests[0].GetResourceMetrics()
+	if assert.Len(t, rm, 1) && assert.Len(t, rm[0].GetScopeMetrics(), 1) {
+		assert.Equal(t, map[string]string{"cluster": "prod"}, otlpTestAttributes(rm[0].GetResource().GetAttributes()))
+		assert.Equal(t, otlpScopeName, rm[0].GetScopeMetrics()[0].GetScope().GetName())
+	}
+	metrics := otlpTestMetrics(receiver.requests[0])
+	for name, metric := range otlpTestMetrics(receiver.requests[1]) {
+		metrics[name] = metric
+	}
+
+	gauge := metrics["anomaly_score"]
+	assert.Equal(t, "Anomaly score.", gauge.GetDescription())
+	if points := gauge.GetGauge().GetDataPoints(); assert.Len(t, points, 2) {
+		assert.Equal(t, map[string]string{"app": "web"}, otlpTestAttributes(points[0].GetAttributes()))
+		assert.Equal(t, uint64(1680124991883000000), points[0].GetTimeUnixNano())
+		assert.Zero(t, points[0].GetStartTimeUnixNano())
+		assert.Equal(t, 0.49, points[0].GetAsDouble())
+		assert.Equal(t, map[string]string{"app": "api"}, otlpTestAttributes(points[1].GetAttributes()))
+		assert.Equal(t, 0.2, points[1].GetAsDouble())
+	}
+
+	counter := metrics["requests_total"].GetSum()
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, counter.GetAggregationTemporality())
+	assert.True(t, counter.GetIsMonotonic())
+	if assert.Len(t, counter.GetDataPoints(), 1) {
+		assert.Equal(t, float64(7), counter.GetDataPoints()[0].GetAsDouble())
+		assert.Equal(t, uint64(1680124991884000000), counter.GetDataPoints()[0].GetStartTimeUnixNano())
+	}
+
+	histogram := metrics["latency_seconds"].GetHistogram()
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, histogram.GetAggregationTemporality())
+	if assert.Len(t, histogram.GetDataPoints(), 1) {
+		point := histogram.GetDataPoints()[0]
+		assert.Empty(t, point.GetAttributes())
+		assert.Equal(t, uint64(1680124991885000000), point.GetTimeUnixNano())
+		assert.Equal(t, uint64(1680124991885000000), point.GetStartTimeUnixNano())
+		assert.Equal(t, uint64(10), point.GetCount())
+		assert.Equal(t, 4.2, point.GetSum())
+		assert.Equal(t, []uint64{2, 7, 1}, point.GetBucketCounts())
+		assert.Equal(t, []float64{0.1, 1}, point.GetExplicitBounds())
+	}
+
+	summary := metrics["rpc_seconds"].GetSummary()
+	if assert.Len(t, summary.GetDataPoints(), 1) {
+		point := summary.GetDataPoints()[0]
+		assert.Equal(t, uint64(7), point.GetCount())
+		assert.Equal(t, 1.4, point.GetSum())
+		quantiles := make(map[float64]float64)
+		for _, qv := range point.GetQuantileValues() {
+			quantiles[qv.GetQuantile()] = qv.GetValue()
+		}
+		assert.Equal(t, map[float64]float64{0.5: 0.2, 0.99: 0.9}, quantiles)
+	}
+}
+
+func TestOTLPExporter_StartTime(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	exporter := newTestOTLPExporter(server.URL)
+	startTimes := func(values ...float64) []uint64 {
+		var starts []uint64
+		for i, value := range values {
+			payload := PrometheusPayload{Name: "requests_total", Type: "Counter", Value: value, TimestampMs: int64(1000 * (i + 1)), Labels: map[string]string{"app": "web"}}
+			assert.NoError(t, exporter.write(context.Background(), []PrometheusPayload{payload}))
+			point := otlpTestMetrics(receiver.requests[len(receiver.requests)-1])["requests_total"].GetSum().GetDataPoints()[0]
+			starts = append(starts, point.GetStartTimeUnixNano())
+		}
+		return starts
+	}
+	// The start time is kept while the counter increases, and moves to the sample that goes down
+	second := uint64(time.Second)
+	assert.Equal(t, []uint64{second, second, second, 4 * second, 4 * second}, startTimes(1, 5, 5, 2, 3))
+
+	// Series not seen within the TTL are forgotten
+	assert.NoError(t, exporter.write(context.Background(), []PrometheusPayload{{Name: "errors_total", Type: "Counter", Value: 1}}))
+	assert.Len(t, exporter.starts, 2)
+	exporter.starts[groupKey("counter\xffrequests_total", map[string]string{"app": "web"})] = otlpSeriesStart{lastSeen: time.Now().Add(-2 * time.Hour)}
+	exporter.expireStarts(time.Now())
+	assert.Len(t, exporter.starts, 1)
+	assert.Contains(t, exporter.starts, groupKey("counter\xfferrors_total", nil))
+	exporter.startTTL = 0
+	exporter.expireStarts(time.Now().Add(24 * time.Hour))
+	assert.Len(t, exporter.starts, 1)
+}
+
+func TestOTLPExporter_Exemplars(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	exporter := newTestOTLPExporter(server.URL)
+	exporter.compression = OTLP_COMPRESSION_NONE
+	traceID, spanID := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	payloads := []PrometheusPayload{
+		{Name: "requests_total", Type: "Counter", Value: 7, TimestampMs: 1680124991884,
+			Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": traceID, "span_id": spanID, "user": "a"}, Value: 1, TimestampMs: 1680124991000}}},
+		// IDs that are not hex trace contexts stay attributes
+		{Name: "latency_seconds", Type: "Histogram", Count: 1, Sum: 0.5, TimestampMs: 1680124991885, Buckets: map[string]uint64{"1": 1},
+			Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "abc"}, Value: 0.5}}},
+	}
+	assert.NoError(t, exporter.write(context.Background(), payloads))
+	assert.Empty(t, receiver.headers[0].Get("Content-Encoding"))
+	metrics := otlpTestMetrics(receiver.requests[0])
+
+	if exemplars := metrics["requests_total"].GetSum().GetDataPoints()[0].GetExemplars(); assert.Len(t, exemplars, 1) {
+		assert.Equal(t, map[string]string{"user": "a"}, otlpTestAttributes(exemplars[0].GetFilteredAttributes()))
+		assert.Equal(t, uint64(1680124991000000000), exemplars[0].GetTimeUnixNano())
+		assert.Equal(t, float64(1), exemplars[0].GetAsDouble())
+		assert.Equal(t, traceID, hex.EncodeToString(exemplars[0].GetTraceId()))
+		assert.Equal(t, spanID, hex.EncodeToString(exemplars[0].GetSpanId()))
+	}
+	if exemplars := metrics["latency_seconds"].GetHistogram().GetDataPoints()[0].GetExemplars(); assert.Len(t, exemplars, 1) {
+		assert.Equal(t, map[string]string{"trace_id": "abc"}, otlpTestAttributes(exemplars[0].GetFilteredAttributes()))
+		assert.Equal(t, uint64(1680124991885000000), exemplars[0].GetTimeUnixNano())
+		assert.Equal(t, 0.5, exemplars[0].GetAsDouble())
+		assert.Empty(t, exemplars[0].GetTraceId())
+		assert.Empty(t, exemplars[0].GetSpanId())
+	}
+}
+
+func TestOTLPExporter_Retry(t *testing.T) {
+	receiver := &otlpReceiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	exporter := newTestOTLPExporter(server.URL)
+	payloads := []PrometheusPayload{{Name: "anomaly_score", Type: "Gauge", Value: 1}}
+	assert.NoError(t, exporter.write(context.Background(), payloads))
+	assert.Len(t, receiver.requests, 1)
+	assert.NotZero(t, otlpTestMetrics(receiver.requests[0])["anomaly_score"].GetGauge().GetDataPoints()[0].GetTimeUnixNano())
+
+	// 500 is not retryable for OTLP
+	receiver.statuses = []int{http.StatusInternalServerError}
+	assert.ErrorContains(t, exporter.write(context.Background(), payloads), "status code 500")
+	assert.Len(t, receiver.requests, 1)
+
+	receiver.statuses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	assert.ErrorContains(t, exporter.write(context.Background(), payloads), "status code 502")
+	assert.Len(t, receiver.requests, 1)
+}
+
+func TestOTLPExporter_PartialFailure(t *testing.T) {
+	receiver := &otlpReceiver{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	exporter := newTestOTLPExporter(server.URL)
+	payloads := []PrometheusPayload{
+		{Name: "a", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "b", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "c", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "d", Type: "Gauge", Value: 1, datum: 1},
+		{Name: "e", Type: "Counter", Value: -1, datum: 2},
+	}
+	err := exporter.write(context.Background(), payloads)
+	var werr *writeError
+	if assert.ErrorAs(t, err, &werr) {
+		assert.Len(t, werr.datums, 2)
+		assert.Contains(t, werr.datums, 0)
+		assert.Contains(t, werr.datums, 2)
+	}
+	assert.Len(t, receiver.requests, 1)
+	assert.Contains(t, otlpTestMetrics(receiver.requests[0]), "d")
+}
+
+func TestOTLPExporter_PartialSuccess(t *testing.T) {
+	response, err := proto.Marshal(&colmetricspb.ExportMetricsServiceResponse{
+		PartialSuccess: &colmetricspb.ExportMetricsPartialSuccess{RejectedDataPoints: 2, ErrorMessage: "invalid unit"}})
+	assert.NoError(t, err)
+	receiver := &otlpReceiver{response: response}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	// A partial success is logged, the datums succeed
+	assert.NoError(t, newTestOTLPExporter(server.URL).write(context.Background(), []PrometheusPayload{{Name: "a", Type: "Gauge", Value: 1}}))
+	assert.Len(t, receiver.requests, 1)
+}
+
+func TestSink_OTLP(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	ps := &prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, outputMode: OUTPUT_OTLP,
+		labels: map[string]string{"cluster": "prod"}, otlpExporter: newTestOTLPExporter(server.URL)}
+	ps.metrics = NewMetricsServer(nil, "test_otlp")
+
+	results := sinkDatums(ps,
+		testDatum{id: "1", value: `{"name":"score","type":"Gauge","value":1,"labels":{"app":"web"}}`},
+		testDatum{id: "2", value: `{"name":"score","type":"Gauge","delete":true,"labels":{"app":"api"}}`},
+	)
+	assert.True(t, results["1"].Success)
+	assert.True(t, results["2"].Success)
+	if assert.Len(t, receiver.requests, 1) {
+		// The static labels are resource attributes, not data point attributes
+		assert.Equal(t, map[string]string{"cluster": "prod"}, otlpTestAttributes(receiver.requests[0].GetResourceMetrics()[0].GetResource().GetAttributes()))
+		if points := otlpTestMetrics(receiver.requests[0])["score"].GetGauge().GetDataPoints(); assert.Len(t, points, 1) {
+			assert.Equal(t, map[string]string{"app": "web"}, otlpTestAttributes(points[0].GetAttributes()))
+			assert.Equal(t, float64(1), points[0].GetAsDouble())
+		}
+	}
+}
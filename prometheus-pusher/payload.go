@@ -1,18 +1,56 @@
 package main
 
-import (
-	"fmt"
-	"strconv"
+import "strings"
+
+// Metric types accepted in PrometheusPayload.Type, matched case-insensitively.
+const (
+	metricTypeGauge     = "gauge"
+	metricTypeCounter   = "counter"
+	metricTypeUntyped   = "untyped"
+	metricTypeHistogram = "histogram"
+	metricTypeSummary   = "summary"
 )
 
+// Exemplar links a sample to the event that produced it, e.g. with a trace_id label.
+type Exemplar struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Value       float64           `json:"value"`
+	TimestampMs int64             `json:"timestampMs,omitempty"`
+}
+
 type PrometheusPayload struct {
 	TimestampMs int64             `json:"timestampMs,omitempty"`
 	Name        string            `json:"name,omitempty"`
 	Namespace   string            `json:"namespace,omitempty"`
 	Subsystem   string            `json:"subsystem,omitempty"`
 	Type        string            `json:"type,omitempty"`
+	Help        string            `json:"help,omitempty"`
 	Value       float64           `json:"value,omitempty"`
 	Labels      map[string]string `json:"labels,omitempty"`
+	// Count and Sum of the observations of a Histogram or Summary
+	Count uint64  `json:"count,omitempty"`
+	Sum   float64 `json:"sum,omitempty"`
+	// Buckets maps the upper bound of a Histogram bucket to its cumulative count, e.g. {"0.5": 3, "1": 5}
+	Buckets map[string]uint64 `json:"buckets,omitempty"`
+	// Quantiles maps a Summary quantile to its value, e.g. {"0.5": 0.12, "0.99": 0.4}
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+	// Exemplars are written by remote write and pull, the Pushgateway cannot store them so they are dropped there
+	Exemplars []Exemplar `json:"exemplars,omitempty"`
+	// Delete removes the Pushgateway group of the payload, or the series in pull mode, instead of writing a sample
+	Delete bool `json:"delete,omitempty"`
+	// datum is the index in the batch of the datum the payload was decoded from
+	datum int
+	// merged are the datums of the samples aggregated into the payload
+	merged []int
+}
+
+func (p *PrometheusPayload) metricType() string {
+	return strings.ToLower(p.Type)
+}
+
+// datums returns the index of every datum the payload was built from.
+func (p *PrometheusPayload) datums() []int {
+	return append([]int{p.datum}, p.merged...)
 }
 
 func (p *PrometheusPayload) mergeLabels(labels map[string]string) {
@@ -40,64 +78,3 @@ func (p *PrometheusPayload) excludeLabels(labels []string) {
 
 	}
 }
-
-type OriginalPayload struct {
-	UUID           string                 `json:"uuid"`
-	ConfigID       string                 `json:"config_id"`
-	CompositeKeys  []string               `json:"composite_keys"`
-	Timestamp      int64                  `json:"timestamp"`
-	UnifiedAnomaly any                    `json:"unified_anomaly"`
-	Data           map[string]interface{} `json:"data"`
-	Metadata       map[string]interface{} `json:"metadata"`
-}
-
-func (op *OriginalPayload) ConvertToPrometheusPayload(metricName string) []*PrometheusPayload {
-	payloads := make([]*PrometheusPayload, 0)
-
-	value, err := strconv.ParseFloat(fmt.Sprintf("%.4f", op.UnifiedAnomaly), 64)
-	if err != nil {
-		value = 0
-	}
-	var labels map[string]string
-	labels = make(map[string]string)
-	for key, val := range op.Metadata {
-		if key == "artifact_versions" {
-			continue
-		}
-		if key == "model_version" {
-			labels[key] = fmt.Sprintf("%.1f", val)
-
-		} else {
-			labels[key] = fmt.Sprintf("%s", val)
-		}
-	}
-	namespace := op.Metadata["namespace"]
-	if namespace == nil {
-		namespace = ""
-	}
-	payloads = append(payloads, &PrometheusPayload{
-		Name:        metricName,
-		TimestampMs: op.Timestamp,
-		Namespace:   namespace.(string),
-		Subsystem:   "none",
-		Type:        "Gauge",
-		Value:       value,
-		Labels:      labels,
-	})
-	for name, metricVal := range op.Data {
-		value, err := strconv.ParseFloat(fmt.Sprintf("%.4f", metricVal), 64)
-		if err != nil {
-			value = 0
-		}
-		payloads = append(payloads, &PrometheusPayload{
-			Name:        fmt.Sprintf("%s_anomaly", name),
-			TimestampMs: op.Timestamp,
-			Namespace:   namespace.(string),
-			Subsystem:   "none",
-			Type:        "Gauge",
-			Value:       value,
-			Labels:      labels,
-		})
-	}
-	return payloads
-}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+type pullEntry struct {
+	collector *myCollector
+	updated   time.Time
+}
+
+// pullStore keeps the latest sample of every received series for Prometheus to scrape.
+// Series that are not updated within the TTL are dropped.
+type pullStore struct {
+	logger   *zap.SugaredLogger
+	ttl      time.Duration
+	ignoreTs bool
+
+	mu     sync.Mutex
+	series map[string]*pullEntry
+	now    func() time.Time
+}
+
+func newPullStore(logger *zap.SugaredLogger, ttl time.Duration, ignoreTs bool) *pullStore {
+	return &pullStore{
+		logger:   logger,
+		ttl:      ttl,
+		ignoreTs: ignoreTs,
+		series:   make(map[string]*pullEntry),
+		now:      time.Now,
+	}
+}
+
+// update stores the payloads in order, a payload with the delete marker removes its series.
+// No payload of a datum is stored if any of its payloads is invalid.
+func (s *pullStore) update(payloads []PrometheusPayload) error {
+	collectors := make([]*myCollector, len(payloads))
+	werr := &writeError{}
+	for i, payload := range payloads {
+		if payload.Delete {
+			continue
+		}
+		collector, err := newCollector(payload, payload.Labels, s.ignoreTs)
+		if err != nil {
+			s.logger.Errorw("Invalid metric", zap.Any("payload", payload), zap.Error(err))
+			for _, datum := range payload.datums() {
+				werr.fail(datum, err)
+			}
+			continue
+		}
+		collectors[i] = collector
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for i, payload := range payloads {
+		if werr.failed(payload) {
+			continue
+		}
+		key := groupKey(payload.Name, payload.Labels)
+		if payload.Delete {
+			delete(s.series, key)
+			continue
+		}
+		s.series[key] = &pullEntry{collector: collectors[i], updated: now}
+	}
+	s.expire(now)
+	if len(werr.datums) > 0 {
+		werr.err = fmt.Errorf("%d datums have invalid metrics", len(werr.datums))
+		return werr
+	}
+	return nil
+}
+
+func (s *pullStore) expire(now time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+	cutoff := now.Add(-s.ttl)
+	for key, entry := range s.series {
+		if entry.updated.Before(cutoff) {
+			delete(s.series, key)
+		}
+	}
+}
+
+// Describe sends no descriptors, the series are only known once received.
+func (s *pullStore) Describe(chan<- *prometheus.Desc) {}
+
+func (s *pullStore) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(s.now())
+	for _, entry := range s.series {
+		entry.collector.Collect(ch)
+	}
+}
+
+func (s *pullStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(s.now())
+	return len(s.series)
+}
+
+// handler serves the stored series from their own registry, apart from the sink's operational metrics.
+func (s *pullStore) handler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		ErrorLog:          zap.NewStdLog(s.logger.Desugar()),
+		ErrorHandling:     promhttp.ContinueOnError,
+		EnableOpenMetrics: true,
+	})
+}
+
+func (s *pullStore) startServer(port int, path string) error {
+	mux := http.NewServeMux()
+	mux.Handle(path, s.handler())
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullStore_Update(t *testing.T) {
+	store := newPullStore(logging.NewLogger().Named("pull"), time.Minute, true)
+	now := time.Unix(1680124991, 0)
+	store.now = func() time.Time { return now }
+
+	err := store.update([]PrometheusPayload{
+		{Name: "anomaly_score", Type: "Gauge", Value: 1, Labels: map[string]string{"app": "a"}},
+		{Name: "anomaly_score", Type: "Gauge", Value: 2, Labels: map[string]string{"app": "b"}},
+		{Name: "latency_seconds", Type: "Histogram", Count: 3, Sum: 1.5, Buckets: map[string]uint64{"1": 2}},
+	})
+	assert.NoError(t, err)
+	// The latest value replaces the previous one of the same series
+	err = store.update([]PrometheusPayload{{Name: "anomaly_score", Type: "Gauge", Value: 5, Labels: map[string]string{"app": "a"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, store.len())
+
+	srv := httptest.NewServer(store.handler())
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Contains(t, string(body), `anomaly_score{app="a"} 5`)
+	assert.Contains(t, string(body), `anomaly_score{app="b"} 2`)
+	assert.Contains(t, string(body), `latency_seconds_bucket{le="1"} 2`)
+	assert.Contains(t, string(body), `latency_seconds_count 3`)
+
+	// Series not updated within the TTL are no longer served
+	now = now.Add(30 * time.Second)
+	assert.NoError(t, store.update([]PrometheusPayload{{Name: "anomaly_score", Type: "Gauge", Value: 6, Labels: map[string]string{"app": "b"}}}))
+	now = now.Add(45 * time.Second)
+	assert.Equal(t, 1, testutil.CollectAndCount(store))
+	assert.Equal(t, 1, store.len())
+}
+
+func TestPullStore_Invalid(t *testing.T) {
+	store := newPullStore(logging.NewLogger().Named("pull"), 0, true)
+	err := store.update([]PrometheusPayload{
+		{Name: "anomaly_score", Type: "Gauge", Value: 1},
+		{Name: "requests_total", Type: "Counter", Value: -1},
+	})
+	assert.Error(t, err)
+	// Nothing of an invalid batch is stored
+	assert.Equal(t, 0, store.len())
+
+	// Only the datum with the invalid payload fails
+	err = store.update([]PrometheusPayload{
+		{Name: "anomaly_score", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "requests_total", Type: "Counter", Value: -1, datum: 1},
+		{Name: "errors_total", Type: "Counter", Value: 1, datum: 1},
+	})
+	var werr *writeError
+	if assert.ErrorAs(t, err, &werr) {
+		assert.Len(t, werr.datums, 1)
+		assert.Contains(t, werr.datums, 1)
+	}
+	assert.Equal(t, 1, store.len())
+}
+
+func TestPullStore_Timestamp(t *testing.T) {
+	store := newPullStore(logging.NewLogger().Named("pull"), 0, false)
+	assert.NoError(t, store.update([]PrometheusPayload{{Name: "anomaly_score", Type: "Gauge", Value: 1, TimestampMs: 1680124991883}}))
+	mf := gatherCollector(t, store)
+	assert.Equal(t, int64(1680124991883), mf.GetMetric()[0].GetTimestampMs())
+}
+
+func TestPullStore_Delete(t *testing.T) {
+	store := newPullStore(logging.NewLogger().Named("pull"), 0, true)
+	assert.NoError(t, store.update([]PrometheusPayload{
+		{Name: "anomaly_score", Type: "Gauge", Value: 1, Labels: map[string]string{"app": "a"}},
+		{Name: "anomaly_score", Type: "Gauge", Value: 2, Labels: map[string]string{"app": "b"}},
+	}))
+	assert.NoError(t, store.update([]PrometheusPayload{{Name: "anomaly_score", Delete: true, Labels: map[string]string{"app": "a"}}}))
+	assert.Equal(t, 1, store.len())
+}
+
+func TestPullStore_Exemplars(t *testing.T) {
+	store := newPullStore(logging.NewLogger().Named("pull"), time.Minute, true)
+	assert.NoError(t, store.update([]PrometheusPayload{{Name: "anomalies_total", Type: "Counter", Value: 3,
+		Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "abc"}, Value: 1, TimestampMs: 1680124991883}}}}))
+
+	srv := httptest.NewServer(store.handler())
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Contains(t, string(body), `anomalies_total 3.0 # {trace_id="abc"} 1.0`)
+}
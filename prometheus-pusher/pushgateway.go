@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushgatewayConfig configures the HTTP client talking to the Pushgateway. Credentials are read from files
+// on every push, so rotated secrets are picked up without a restart.
+type pushgatewayConfig struct {
+	usernameFile       string
+	passwordFile       string
+	bearerTokenFile    string
+	caFile             string
+	certFile           string
+	keyFile            string
+	insecureSkipVerify bool
+	headers            map[string]string
+	timeout            time.Duration
+}
+
+func (c pushgatewayConfig) validate() error {
+	if (c.usernameFile == "") != (c.passwordFile == "") {
+		return fmt.Errorf("both the Pushgateway username and password files must be set")
+	}
+	if c.usernameFile != "" && c.bearerTokenFile != "" {
+		return fmt.Errorf("only one of basic auth and bearer token can be set for the Pushgateway")
+	}
+	if (c.certFile == "") != (c.keyFile == "") {
+		return fmt.Errorf("both the Pushgateway client certificate and key files must be set")
+	}
+	return nil
+}
+
+func (c pushgatewayConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.insecureSkipVerify}
+	if c.caFile != "" {
+		ca, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the Pushgateway CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in the Pushgateway CA file %s", c.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.certFile != "" {
+		if _, err := tls.LoadX509KeyPair(c.certFile, c.keyFile); err != nil {
+			return nil, fmt.Errorf("failed to load the Pushgateway client certificate: %w", err)
+		}
+		// Loaded on every handshake to pick up renewed certificates
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// pushgatewayClient applies the client, credentials and headers to each pusher.
+type pushgatewayClient struct {
+	config pushgatewayConfig
+	client *http.Client
+}
+
+func newPushgatewayClient(config pushgatewayConfig) (*pushgatewayClient, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &pushgatewayClient{
+		config: config,
+		client: &http.Client{Transport: transport, Timeout: config.timeout},
+	}, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (pc *pushgatewayClient) configure(pusher *push.Pusher) (*push.Pusher, error) {
+	if pc == nil {
+		return pusher, nil
+	}
+	header := make(http.Header, len(pc.config.headers)+1)
+	for key, value := range pc.config.headers {
+		header.Set(key, value)
+	}
+	switch {
+	case pc.config.bearerTokenFile != "":
+		token, err := readSecretFile(pc.config.bearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the Pushgateway bearer token: %w", err)
+		}
+		header.Set("Authorization", "Bearer "+token)
+	case pc.config.usernameFile != "":
+		username, err := readSecretFile(pc.config.usernameFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the Pushgateway username: %w", err)
+		}
+		password, err := readSecretFile(pc.config.passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the Pushgateway password: %w", err)
+		}
+		pusher = pusher.BasicAuth(username, password)
+	}
+	return pusher.Client(pc.client).Header(header), nil
+}
+
+// httpClient returns the client used for the Pushgateway requests.
+func (pc *pushgatewayClient) httpClient() push.HTTPDoer {
+	if pc == nil {
+		return http.DefaultClient
+	}
+	return pc.client
+}
+
+// statusRecorder records the outcome of the last Pushgateway request, as the pusher errors do not expose
+// the status code needed to decide on a retry. The requests are sent with its context when it is set,
+// as the pusher has no context for deletes.
+type statusRecorder struct {
+	ctx        context.Context
+	client     push.HTTPDoer
+	requested  bool
+	err        error
+	status     int
+	retryAfter time.Duration
+}
+
+func (r *statusRecorder) Do(req *http.Request) (*http.Response, error) {
+	if r.ctx != nil {
+		req = req.WithContext(r.ctx)
+	}
+	res, err := r.client.Do(req)
+	r.requested, r.err = true, err
+	if err == nil {
+		r.status, r.retryAfter = res.StatusCode, retryAfter(res.Header)
+	}
+	return res, err
+}
+
+// retryable reports whether the failed request should be retried. Connection errors, 5xx and 429 responses are
+// retried, errors raised before the request is sent are not.
+func (r *statusRecorder) retryable() bool {
+	return r.requested && (r.err != nil || r.status == http.StatusTooManyRequests || r.status/100 == 5)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// newTLSPushgateway starts a TLS Pushgateway stand-in and writes its certificate to a CA file.
+func newTLSPushgateway(t *testing.T, handler http.HandlerFunc, tlsConfig *tls.Config) (*httptest.Server, string) {
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	t.Setenv("PROMETHEUS_SERVER", srv.URL)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	return srv, writeFile(t, "ca.pem", string(ca))
+}
+
+// newClientCert creates a self-signed client certificate and returns its cert and key files.
+func newClientCert(t *testing.T) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "prometheus-sink"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	certFile := writeFile(t, "client.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile := writeFile(t, "client-key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})))
+	return cert, certFile, keyFile
+}
+
+func TestPushgatewayConfig_Validate(t *testing.T) {
+	assert.NoError(t, pushgatewayConfig{}.validate())
+	assert.Error(t, pushgatewayConfig{usernameFile: "user"}.validate())
+	assert.Error(t, pushgatewayConfig{usernameFile: "user", passwordFile: "pass", bearerTokenFile: "token"}.validate())
+	assert.Error(t, pushgatewayConfig{certFile: "cert"}.validate())
+	_, err := newPushgatewayClient(pushgatewayConfig{caFile: writeFile(t, "ca.pem", "not a certificate")})
+	assert.ErrorContains(t, err, "no certificates found")
+}
+
+func TestPushgatewayClient_BearerToken(t *testing.T) {
+	var auth, org string
+	_, caFile := newTLSPushgateway(t, func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		org = r.Header.Get("X-Org")
+		w.WriteHeader(http.StatusOK)
+	}, nil)
+	tokenFile := writeFile(t, "token", "secret-token\n")
+
+	client, err := newPushgatewayClient(pushgatewayConfig{bearerTokenFile: tokenFile, caFile: caFile,
+		headers: map[string]string{"X-Org": "org1"}, timeout: 5 * time.Second})
+	assert.NoError(t, err)
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1, pushgateway: client}
+	ps.metrics = NewMetricsServer(nil, "test_bearer")
+	payloads := []PrometheusPayload{{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 1}}
+	assert.NoError(t, ps.push(context.Background(), payloads))
+	assert.Equal(t, "Bearer secret-token", auth)
+	assert.Equal(t, "org1", org)
+
+	// A rotated token is read on the next push
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("rotated-token"), 0600))
+	assert.NoError(t, ps.push(context.Background(), payloads))
+	assert.Equal(t, "Bearer rotated-token", auth)
+}
+
+func TestPushgatewayClient_BasicAuth(t *testing.T) {
+	_, caFile := newTLSPushgateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "sink" || password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}, nil)
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1}
+	ps.metrics = NewMetricsServer(nil, "test_basic_auth")
+	payloads := []PrometheusPayload{{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 1}}
+
+	// The default client does not trust the private CA
+	assert.Error(t, ps.push(context.Background(), payloads))
+
+	client, err := newPushgatewayClient(pushgatewayConfig{caFile: caFile,
+		usernameFile: writeFile(t, "username", "sink"), passwordFile: writeFile(t, "password", "wrong")})
+	assert.NoError(t, err)
+	ps.pushgateway = client
+	assert.ErrorContains(t, ps.push(context.Background(), payloads), "401")
+
+	client.config.passwordFile = writeFile(t, "password", "pass")
+	assert.NoError(t, ps.push(context.Background(), payloads))
+}
+
+func TestPushgatewayClient_MutualTLS(t *testing.T) {
+	clientCert, certFile, keyFile := newClientCert(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	_, caFile := newTLSPushgateway(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool})
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1}
+	ps.metrics = NewMetricsServer(nil, "test_mtls")
+	payloads := []PrometheusPayload{{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 1}}
+
+	client, err := newPushgatewayClient(pushgatewayConfig{caFile: caFile})
+	assert.NoError(t, err)
+	ps.pushgateway = client
+	assert.Error(t, ps.push(context.Background(), payloads))
+
+	client, err = newPushgatewayClient(pushgatewayConfig{caFile: caFile, certFile: certFile, keyFile: keyFile})
+	assert.NoError(t, err)
+	ps.pushgateway = client
+	assert.NoError(t, ps.push(context.Background(), payloads))
+}
+
+func TestPushgatewayClient_Retry(t *testing.T) {
+	var statuses []int
+	var requests int
+	var retryAfter string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		status := http.StatusOK
+		if r.Method == http.MethodDelete {
+			status = http.StatusAccepted
+		}
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	t.Setenv("PROMETHEUS_SERVER", srv.URL)
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1,
+		pushMaxRetries: 2, pushMinBackoff: time.Millisecond, pushMaxBackoff: time.Millisecond}
+	ps.metrics = NewMetricsServer(nil, "test_push_retry")
+	payloads := []PrometheusPayload{{Namespace: "ns", Subsystem: "none", Name: "score", Type: "Gauge", Value: 1}}
+
+	statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	assert.NoError(t, ps.push(context.Background(), payloads))
+	assert.Equal(t, 3, requests)
+
+	// Client errors are not retried
+	requests, statuses = 0, []int{http.StatusBadRequest}
+	err := ps.push(context.Background(), payloads)
+	assert.ErrorContains(t, err, "400")
+	assert.False(t, isUnavailable(failedDatums(err, payloads)[0]))
+	assert.Equal(t, 1, requests)
+
+	// An unavailable Pushgateway is reported once the retries are exhausted
+	requests, statuses = 0, []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	err = ps.push(context.Background(), payloads)
+	assert.ErrorContains(t, err, "502")
+	assert.True(t, isUnavailable(failedDatums(err, payloads)[0]))
+	assert.Equal(t, 3, requests)
+
+	// Retry-After is capped by the max backoff
+	requests, statuses, retryAfter = 0, []int{http.StatusTooManyRequests}, "3600"
+	start := time.Now()
+	assert.NoError(t, ps.push(context.Background(), payloads))
+	assert.Less(t, time.Since(start), time.Minute)
+	assert.Equal(t, 2, requests)
+	retryAfter = ""
+
+	// Deletes of stale groups are retried like pushes
+	requests, statuses = 0, []int{http.StatusServiceUnavailable}
+	assert.NoError(t, ps.deleteGroup(context.Background(), "ns_none_score", map[string]string{"app": "a"}))
+	assert.Equal(t, 2, requests)
+
+	// The wait stops once the context is done, the error is not reported as unavailable
+	ps.pushMinBackoff, ps.pushMaxBackoff = time.Hour, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	requests, statuses = 0, []int{http.StatusServiceUnavailable}
+	err = ps.push(ctx, payloads)
+	assert.ErrorContains(t, err, "retry cancelled")
+	assert.False(t, isUnavailable(failedDatums(err, payloads)[0]))
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Relabel actions, with the semantics of the Prometheus relabel_configs
+const (
+	RELABEL_REPLACE   = "replace"
+	RELABEL_KEEP      = "keep"
+	RELABEL_DROP      = "drop"
+	RELABEL_KEEPEQUAL = "keepequal"
+	RELABEL_DROPEQUAL = "dropequal"
+	RELABEL_HASHMOD   = "hashmod"
+	RELABEL_LABELMAP  = "labelmap"
+	RELABEL_LABELDROP = "labeldrop"
+	RELABEL_LABELKEEP = "labelkeep"
+	RELABEL_LOWERCASE = "lowercase"
+	RELABEL_UPPERCASE = "uppercase"
+)
+
+// metricNameLabel holds the payload name while the rules are applied
+const metricNameLabel = "__name__"
+
+var relabelTargetRegexp = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`)
+
+// relabelConfig is a rule of the relabel config file, unset fields take the Prometheus defaults.
+type relabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    string   `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	Modulus      uint64   `yaml:"modulus"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+}
+
+var defaultRelabelConfig = relabelConfig{
+	Separator:   ";",
+	Regex:       "(.*)",
+	Replacement: "$1",
+	Action:      RELABEL_REPLACE,
+}
+
+// relabelConfigFields are the keys of a relabel rule.
+var relabelConfigFields = map[string]bool{"source_labels": true, "separator": true, "regex": true, "modulus": true,
+	"target_label": true, "replacement": true, "action": true}
+
+func (c *relabelConfig) UnmarshalYAML(value *yaml.Node) error {
+	// value.Decode does not inherit the KnownFields of the file decoder, the rule keys are checked here
+	if value.Kind == yaml.MappingNode {
+		for i := 0; i < len(value.Content); i += 2 {
+			if key := value.Content[i]; !relabelConfigFields[key.Value] {
+				return fmt.Errorf("line %d: field %s not found in relabel rule", key.Line, key.Value)
+			}
+		}
+	}
+	*c = defaultRelabelConfig
+	type plain relabelConfig
+	return value.Decode((*plain)(c))
+}
+
+type relabelFile struct {
+	RelabelConfigs []relabelConfig `yaml:"relabel_configs"`
+}
+
+// relabelRule is a validated relabelConfig with its anchored regex.
+type relabelRule struct {
+	relabelConfig
+	regex *regexp.Regexp
+}
+
+// relabeler applies the relabel rules in order to the name and labels of each payload.
+type relabeler struct {
+	rules []relabelRule
+}
+
+func loadRelabelConfig(path string) (*relabeler, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Unknown fields are rejected, a misspelled field would otherwise turn into a default replace rule
+	var file relabelFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid relabel config: %w", err)
+	}
+	return newRelabeler(file.RelabelConfigs)
+}
+
+func newRelabeler(configs []relabelConfig) (*relabeler, error) {
+	rules := make([]relabelRule, 0, len(configs))
+	for i, config := range configs {
+		config.Action = strings.ToLower(config.Action)
+		if err := config.validate(); err != nil {
+			return nil, fmt.Errorf("relabel rule %d: %w", i, err)
+		}
+		regex, err := regexp.Compile("^(?:" + config.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("relabel rule %d: invalid regex: %w", i, err)
+		}
+		rules = append(rules, relabelRule{relabelConfig: config, regex: regex})
+	}
+	return &relabeler{rules: rules}, nil
+}
+
+func (c relabelConfig) validate() error {
+	switch c.Action {
+	case RELABEL_REPLACE, RELABEL_KEEP, RELABEL_DROP, RELABEL_KEEPEQUAL, RELABEL_DROPEQUAL, RELABEL_HASHMOD,
+		RELABEL_LABELMAP, RELABEL_LABELDROP, RELABEL_LABELKEEP, RELABEL_LOWERCASE, RELABEL_UPPERCASE:
+	default:
+		return fmt.Errorf("unknown relabel action %q", c.Action)
+	}
+	switch c.Action {
+	case RELABEL_REPLACE, RELABEL_HASHMOD, RELABEL_LOWERCASE, RELABEL_UPPERCASE, RELABEL_KEEPEQUAL, RELABEL_DROPEQUAL:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s requires a target_label", c.Action)
+		}
+	}
+	switch c.Action {
+	case RELABEL_REPLACE:
+		if !relabelTargetRegexp.MatchString(c.TargetLabel) {
+			return fmt.Errorf("invalid target_label %q for relabel action replace", c.TargetLabel)
+		}
+	case RELABEL_HASHMOD, RELABEL_LOWERCASE, RELABEL_UPPERCASE, RELABEL_KEEPEQUAL, RELABEL_DROPEQUAL:
+		if !labelNameRegexp.MatchString(c.TargetLabel) {
+			return fmt.Errorf("invalid target_label %q for relabel action %s", c.TargetLabel, c.Action)
+		}
+	}
+	if c.Action == RELABEL_HASHMOD && c.Modulus == 0 {
+		return fmt.Errorf("relabel action hashmod requires a modulus")
+	}
+	if c.Action == RELABEL_LABELDROP || c.Action == RELABEL_LABELKEEP {
+		if len(c.SourceLabels) > 0 || c.TargetLabel != "" || c.Separator != defaultRelabelConfig.Separator ||
+			c.Replacement != defaultRelabelConfig.Replacement {
+			return fmt.Errorf("relabel action %s only uses the regex", c.Action)
+		}
+	}
+	return nil
+}
+
+// relabel applies the rules to the payload. It reports false when a rule drops the payload, or when no name is left.
+func (r *relabeler) relabel(payload *PrometheusPayload) bool {
+	if r == nil {
+		return true
+	}
+	labels := make(map[string]string, len(payload.Labels)+1)
+	for name, value := range payload.Labels {
+		setLabel(labels, name, value)
+	}
+	setLabel(labels, metricNameLabel, payload.Name)
+	for _, rule := range r.rules {
+		if !rule.apply(labels) {
+			return false
+		}
+	}
+	payload.Name = labels[metricNameLabel]
+	delete(labels, metricNameLabel)
+	payload.Labels = labels
+	return payload.Name != ""
+}
+
+// setLabel sets the label, an empty value removes it as in Prometheus.
+func setLabel(labels map[string]string, name, value string) {
+	if value == "" {
+		delete(labels, name)
+		return
+	}
+	labels[name] = value
+}
+
+// apply runs the rule on the labels, it reports false when the series is dropped.
+func (rule relabelRule) apply(labels map[string]string) bool {
+	values := make([]string, 0, len(rule.SourceLabels))
+	for _, name := range rule.SourceLabels {
+		values = append(values, labels[name])
+	}
+	value := strings.Join(values, rule.Separator)
+	switch rule.Action {
+	case RELABEL_KEEP:
+		return rule.regex.MatchString(value)
+	case RELABEL_DROP:
+		return !rule.regex.MatchString(value)
+	case RELABEL_KEEPEQUAL:
+		return value == labels[rule.TargetLabel]
+	case RELABEL_DROPEQUAL:
+		return value != labels[rule.TargetLabel]
+	case RELABEL_REPLACE:
+		indexes := rule.regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			break
+		}
+		target := string(rule.regex.ExpandString(nil, rule.TargetLabel, value, indexes))
+		if !labelNameRegexp.MatchString(target) {
+			break
+		}
+		setLabel(labels, target, string(rule.regex.ExpandString(nil, rule.Replacement, value, indexes)))
+	case RELABEL_LOWERCASE:
+		setLabel(labels, rule.TargetLabel, strings.ToLower(value))
+	case RELABEL_UPPERCASE:
+		setLabel(labels, rule.TargetLabel, strings.ToUpper(value))
+	case RELABEL_HASHMOD:
+		// The last 8 bytes of the MD5 sum, as Prometheus does
+		hash := md5.Sum([]byte(value))
+		labels[rule.TargetLabel] = fmt.Sprint(binary.BigEndian.Uint64(hash[8:]) % rule.Modulus)
+	case RELABEL_LABELMAP:
+		mapped := make(map[string]string)
+		for name, value := range labels {
+			if rule.regex.MatchString(name) {
+				mapped[rule.regex.ReplaceAllString(name, rule.Replacement)] = value
+			}
+		}
+		for name, value := range mapped {
+			setLabel(labels, name, value)
+		}
+	case RELABEL_LABELDROP:
+		for name := range labels {
+			if rule.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case RELABEL_LABELKEEP:
+		for name := range labels {
+			if !rule.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// rule returns a relabel config with the Prometheus defaults for the unset fields.
+func rule(config relabelConfig) relabelConfig {
+	defaults := defaultRelabelConfig
+	if config.Separator == "" {
+		config.Separator = defaults.Separator
+	}
+	if config.Regex == "" {
+		config.Regex = defaults.Regex
+	}
+	if config.Replacement == "" {
+		config.Replacement = defaults.Replacement
+	}
+	if config.Action == "" {
+		config.Action = defaults.Action
+	}
+	return config
+}
+
+// The cases follow the relabel tests of Prometheus
+func TestRelabeler_Relabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   map[string]string
+		configs []relabelConfig
+		output  map[string]string
+		dropped bool
+	}{
+		{
+			name:    "replace with capture group",
+			input:   map[string]string{"a": "foo", "b": "bar", "c": "baz"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, Regex: "f(.*)", TargetLabel: "d", Replacement: "ch${1}-ch${1}"})},
+			output:  map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "choo-choo"},
+		},
+		{
+			name:  "replace chained with separator",
+			input: map[string]string{"a": "foo", "b": "bar", "c": "baz"},
+			configs: []relabelConfig{
+				rule(relabelConfig{SourceLabels: []string{"a", "b"}, Regex: "f(.*);(.*)r", TargetLabel: "a", Replacement: "b${1}${2}m"}),
+				rule(relabelConfig{SourceLabels: []string{"c", "a"}, Regex: "(b).*b(.*)ba(.*)", TargetLabel: "d", Replacement: "$1$2$2$3"}),
+			},
+			output: map[string]string{"a": "boobam", "b": "bar", "c": "baz", "d": "boooom"},
+		},
+		{
+			name:  "drop on match",
+			input: map[string]string{"a": "foo"},
+			configs: []relabelConfig{
+				rule(relabelConfig{SourceLabels: []string{"a"}, Regex: ".*o.*", Action: RELABEL_DROP}),
+				rule(relabelConfig{SourceLabels: []string{"a"}, Regex: "f(.*)", TargetLabel: "d", Replacement: "ch$1-ch$1"}),
+			},
+			dropped: true,
+		},
+		{
+			name:    "keep without match drops",
+			input:   map[string]string{"a": "foo"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, Regex: "no-match", Action: RELABEL_KEEP})},
+			dropped: true,
+		},
+		{
+			name:    "keep with match",
+			input:   map[string]string{"a": "foo"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, Regex: "f.*", Action: RELABEL_KEEP})},
+			output:  map[string]string{"a": "foo"},
+		},
+		{
+			name:    "regex is anchored",
+			input:   map[string]string{"a": "boo"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, Regex: "f", TargetLabel: "b", Replacement: "bar"})},
+			output:  map[string]string{"a": "boo"},
+		},
+		{
+			name:    "empty replacement removes the target",
+			input:   map[string]string{"a": "foo", "b": "bar"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, Regex: "(f).*", TargetLabel: "b", Replacement: "$2"})},
+			output:  map[string]string{"a": "foo"},
+		},
+		{
+			name:    "target label from capture group",
+			input:   map[string]string{"a": "some-name-value"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, Regex: "some-([^-]+)-([^,]+)", TargetLabel: "${1}", Replacement: "${2}"})},
+			output:  map[string]string{"a": "some-name-value", "name": "value"},
+		},
+		{
+			name:    "invalid expanded target is ignored",
+			input:   map[string]string{"a": "some-name-0"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, Regex: "some-([^-]+)-([^,]+)", TargetLabel: "${3}", Replacement: "${1}"})},
+			output:  map[string]string{"a": "some-name-0"},
+		},
+		{
+			name:    "hashmod",
+			input:   map[string]string{"a": "foo", "b": "bar", "c": "baz"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"c"}, TargetLabel: "d", Action: RELABEL_HASHMOD, Modulus: 1000})},
+			output:  map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "976"},
+		},
+		{
+			name:    "labelmap",
+			input:   map[string]string{"a": "foo", "b1": "bar", "b2": "baz"},
+			configs: []relabelConfig{rule(relabelConfig{Regex: "(b.*)", Replacement: "bar_${1}", Action: RELABEL_LABELMAP})},
+			output:  map[string]string{"a": "foo", "b1": "bar", "b2": "baz", "bar_b1": "bar", "bar_b2": "baz"},
+		},
+		{
+			name:    "labelmap strips a prefix",
+			input:   map[string]string{"__meta_my_bar": "aaa", "__meta_my_baz": "bbb", "__meta_other": "ccc"},
+			configs: []relabelConfig{rule(relabelConfig{Regex: "__meta_(my.*)", Replacement: "${1}", Action: RELABEL_LABELMAP})},
+			output:  map[string]string{"__meta_my_bar": "aaa", "__meta_my_baz": "bbb", "__meta_other": "ccc", "my_bar": "aaa", "my_baz": "bbb"},
+		},
+		{
+			name:    "labeldrop",
+			input:   map[string]string{"a": "foo", "b": "bar", "c": "baz"},
+			configs: []relabelConfig{rule(relabelConfig{Regex: "(b|c)", Action: RELABEL_LABELDROP})},
+			output:  map[string]string{"a": "foo"},
+		},
+		{
+			name:  "labelkeep",
+			input: map[string]string{"a": "foo", "aa": "bar", "c": "baz"},
+			// __name__ is a label too and must be kept
+			configs: []relabelConfig{rule(relabelConfig{Regex: "(a.*|__name__)", Action: RELABEL_LABELKEEP})},
+			output:  map[string]string{"a": "foo", "aa": "bar"},
+		},
+		{
+			name:    "lowercase and uppercase",
+			input:   map[string]string{"foo": "bAr123Foo"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"foo"}, TargetLabel: "foo_lower", Action: RELABEL_LOWERCASE}), rule(relabelConfig{SourceLabels: []string{"foo"}, TargetLabel: "foo_upper", Action: RELABEL_UPPERCASE})},
+			output:  map[string]string{"foo": "bAr123Foo", "foo_lower": "bar123foo", "foo_upper": "BAR123FOO"},
+		},
+		{
+			name:    "keepequal",
+			input:   map[string]string{"a": "foo", "b": "foo"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, TargetLabel: "b", Action: RELABEL_KEEPEQUAL})},
+			output:  map[string]string{"a": "foo", "b": "foo"},
+		},
+		{
+			name:    "dropequal",
+			input:   map[string]string{"a": "foo", "b": "foo"},
+			configs: []relabelConfig{rule(relabelConfig{SourceLabels: []string{"a"}, TargetLabel: "b", Action: RELABEL_DROPEQUAL})},
+			dropped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newRelabeler(tt.configs)
+			assert.NoError(t, err)
+			payload := PrometheusPayload{Name: "metric", Labels: tt.input}
+			kept := r.relabel(&payload)
+			assert.Equal(t, !tt.dropped, kept)
+			if kept {
+				assert.Equal(t, "metric", payload.Name)
+				assert.Equal(t, tt.output, payload.Labels)
+			}
+		})
+	}
+}
+
+func TestRelabeler_MetricName(t *testing.T) {
+	r, err := newRelabeler([]relabelConfig{
+		rule(relabelConfig{SourceLabels: []string{"__name__"}, Regex: "debug_.*", Action: RELABEL_DROP}),
+		rule(relabelConfig{SourceLabels: []string{"__name__", "app"}, Regex: "(.*);(.*)", TargetLabel: "__name__", Replacement: "${2}_${1}"}),
+	})
+	assert.NoError(t, err)
+	payload := PrometheusPayload{Name: "anomaly_score", Labels: map[string]string{"app": "web"}}
+	assert.True(t, r.relabel(&payload))
+	assert.Equal(t, "web_anomaly_score", payload.Name)
+	assert.Equal(t, map[string]string{"app": "web"}, payload.Labels)
+
+	payload = PrometheusPayload{Name: "debug_score"}
+	assert.False(t, r.relabel(&payload))
+
+	// A payload without a name left is dropped
+	r, err = newRelabeler([]relabelConfig{rule(relabelConfig{Regex: "__name__", Action: RELABEL_LABELDROP})})
+	assert.NoError(t, err)
+	payload = PrometheusPayload{Name: "anomaly_score"}
+	assert.False(t, r.relabel(&payload))
+}
+
+func TestLoadRelabelConfig(t *testing.T) {
+	path := writeFile(t, "relabel.yaml", `
+relabel_configs:
+  - source_labels: [pod]
+    regex: (.+)-[a-z0-9]+-[a-z0-9]+
+    target_label: deployment
+  - action: LabelDrop
+    regex: pod
+  - source_labels: [namespace]
+    separator: ""
+    target_label: shard
+    action: hashmod
+    modulus: 4
+`)
+	r, err := loadRelabelConfig(path)
+	assert.NoError(t, err)
+	assert.Len(t, r.rules, 3)
+	assert.Equal(t, "$1", r.rules[0].Replacement)
+	assert.Equal(t, RELABEL_REPLACE, r.rules[0].Action)
+	assert.Equal(t, RELABEL_LABELDROP, r.rules[1].Action)
+	assert.Equal(t, "", r.rules[2].Separator)
+	payload := PrometheusPayload{Name: "score", Labels: map[string]string{"pod": "web-7d4b9c-x2z9q", "namespace": "ns"}}
+	assert.True(t, r.relabel(&payload))
+	assert.Equal(t, "web", payload.Labels["deployment"])
+	assert.NotContains(t, payload.Labels, "pod")
+	assert.Contains(t, payload.Labels, "shard")
+
+	for config, msg := range map[string]string{
+		"relabel_configs: [{action: explode}]":                         "unknown relabel action",
+		"relabel_configs: [{source_labels: [a]}]":                      "requires a target_label",
+		"relabel_configs: [{action: hashmod, target_label: b}]":        "requires a modulus",
+		"relabel_configs: [{action: labeldrop, source_labels: [a]}]":   "only uses the regex",
+		"relabel_configs: [{target_label: b, regex: '('}]":             "invalid regex",
+		"relabel_configs: [{target_label: 'b-c'}]":                     "invalid target_label",
+		"relabel_configs: [{action: lowercase, target_label: '${1}'}]": "invalid target_label",
+		"relabel_configs: {}":                                          "invalid relabel config",
+		"relabel_configs: [{sourcelabels: [a], target_label: b}]":      "field sourcelabels not found",
+		"relabel_configs: [{source_labels: [a], target: b}]":           "field target not found",
+		"relabel_config: [{source_labels: [a], target_label: b}]":      "field relabel_config not found",
+	} {
+		_, err := loadRelabelConfig(writeFile(t, "relabel.yaml", config))
+		assert.ErrorContains(t, err, msg, config)
+	}
+}
+
+func TestSink_Relabel(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	ps := &prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1}
+	ps.metrics = NewMetricsServer(nil, "test_relabel")
+	ps.relabeler, _ = newRelabeler([]relabelConfig{
+		rule(relabelConfig{SourceLabels: []string{"env"}, Regex: "dev", Action: RELABEL_DROP}),
+		rule(relabelConfig{Regex: "request_id", Action: RELABEL_LABELDROP}),
+	})
+	results := sinkDatums(ps,
+		testDatum{id: "1", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1,"labels":{"request_id":"1"}}`},
+		testDatum{id: "2", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1,"labels":{"env":"dev"}}`},
+	)
+	// Dropped metrics are acknowledged
+	assert.True(t, results["1"].Success)
+	assert.True(t, results["2"].Success)
+	assert.Len(t, pgw.requests, 1)
+	assert.Contains(t, pgw.requests, "/metrics/job/ns_none_score")
+}
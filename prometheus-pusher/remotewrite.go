@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"go.uber.org/zap"
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	value       float64
+	timestampMs int64
+}
+
+type exemplar struct {
+	labels      []label
+	value       float64
+	timestampMs int64
+}
+
+type timeSeries struct {
+	labels    []label
+	samples   []sample
+	exemplars []exemplar
+	// datums are the index of the datums the series was expanded from
+	datums []int
+}
+
+func newExemplars(payload PrometheusPayload, timestampMs int64) []exemplar {
+	exemplars := make([]exemplar, 0, len(payload.Exemplars))
+	for _, e := range payload.Exemplars {
+		labels := make([]label, 0, len(e.Labels))
+		for name, value := range e.Labels {
+			labels = append(labels, label{name: name, value: value})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		ts := e.TimestampMs
+		if ts == 0 {
+			ts = timestampMs
+		}
+		exemplars = append(exemplars, exemplar{labels: labels, value: e.Value, timestampMs: ts})
+	}
+	return exemplars
+}
+
+// payloadSeries expands a payload into Prometheus series. Histograms and summaries
+// become their _bucket/quantile, _sum and _count series. Exemplars are attached to the series,
+// for histograms to the first bucket holding their value. Summaries do not keep exemplars.
+func payloadSeries(payload PrometheusPayload, timestampMs int64) ([]timeSeries, error) {
+	if _, err := parseExemplars(payload); err != nil {
+		return nil, err
+	}
+	exemplars := newExemplars(payload, timestampMs)
+	newSeries := func(name string, value float64, extra ...label) timeSeries {
+		labels := make([]label, 0, len(payload.Labels)+len(extra)+1)
+		labels = append(labels, label{name: "__name__", value: name})
+		for key, val := range payload.Labels {
+			labels = append(labels, label{name: key, value: val})
+		}
+		labels = append(labels, extra...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		return timeSeries{labels: labels, samples: []sample{{value: value, timestampMs: timestampMs}}, datums: payload.datums()}
+	}
+	switch payload.metricType() {
+	case metricTypeGauge, metricTypeUntyped, metricTypeCounter:
+		if err := validateCounter(payload); err != nil {
+			return nil, err
+		}
+		series := newSeries(payload.Name, payload.Value)
+		series.exemplars = exemplars
+		return []timeSeries{series}, nil
+	case metricTypeHistogram:
+		buckets, err := parseBuckets(payload)
+		if err != nil {
+			return nil, err
+		}
+		bounds := make([]float64, 0, len(buckets))
+		for bound := range buckets {
+			if !math.IsInf(bound, 1) {
+				bounds = append(bounds, bound)
+			}
+		}
+		sort.Float64s(bounds)
+		series := make([]timeSeries, 0, len(bounds)+3)
+		for _, bound := range bounds {
+			series = append(series, newSeries(payload.Name+"_bucket", float64(buckets[bound]),
+				label{name: "le", value: strconv.FormatFloat(bound, 'g', -1, 64)}))
+		}
+		series = append(series, newSeries(payload.Name+"_bucket", float64(payload.Count), label{name: "le", value: "+Inf"}))
+		for _, e := range exemplars {
+			i := sort.SearchFloat64s(bounds, e.value)
+			series[i].exemplars = append(series[i].exemplars, e)
+		}
+		series = append(series,
+			newSeries(payload.Name+"_sum", payload.Sum),
+			newSeries(payload.Name+"_count", float64(payload.Count)))
+		return series, nil
+	case metricTypeSummary:
+		quantiles, err := parseQuantiles(payload)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]float64, 0, len(quantiles))
+		for quantile := range quantiles {
+			keys = append(keys, quantile)
+		}
+		sort.Float64s(keys)
+		series := make([]timeSeries, 0, len(keys)+2)
+		for _, quantile := range keys {
+			series = append(series, newSeries(payload.Name, quantiles[quantile],
+				label{name: "quantile", value: strconv.FormatFloat(quantile, 'g', -1, 64)}))
+		}
+		series = append(series,
+			newSeries(payload.Name+"_sum", payload.Sum),
+			newSeries(payload.Name+"_count", float64(payload.Count)))
+		return series, nil
+	default:
+		return nil, fmt.Errorf("unsupported Metrics Type %q", payload.Type)
+	}
+}
+
+func promLabels(labels []label) []prompb.Label {
+	result := make([]prompb.Label, 0, len(labels))
+	for _, l := range labels {
+		result = append(result, prompb.Label{Name: l.name, Value: l.value})
+	}
+	return result
+}
+
+// encodeWriteRequest encodes the series as a remote write prometheus.WriteRequest protobuf message.
+func encodeWriteRequest(series []timeSeries) ([]byte, error) {
+	request := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(series))}
+	for _, ts := range series {
+		promSeries := prompb.TimeSeries{Labels: promLabels(ts.labels), Samples: make([]prompb.Sample, 0, len(ts.samples))}
+		for _, s := range ts.samples {
+			promSeries.Samples = append(promSeries.Samples, prompb.Sample{Value: s.value, Timestamp: s.timestampMs})
+		}
+		for _, e := range ts.exemplars {
+			promSeries.Exemplars = append(promSeries.Exemplars, prompb.Exemplar{Labels: promLabels(e.labels), Value: e.value, Timestamp: e.timestampMs})
+		}
+		request.Timeseries = append(request.Timeseries, promSeries)
+	}
+	return request.Marshal()
+}
+
+// remoteWriter sends payloads to a Prometheus remote_write endpoint such as Prometheus, Mimir or Thanos receive.
+type remoteWriter struct {
+	logger       *zap.SugaredLogger
+	client       *http.Client
+	url          string
+	batchSize    int
+	maxRetries   int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	tenant       string
+	tenantHeader string
+}
+
+// write sends the series of the payloads in batches. A failed batch only fails the datums of its series,
+// the other batches are still sent.
+func (rw *remoteWriter) write(ctx context.Context, payloads []PrometheusPayload) error {
+	var series []timeSeries
+	var firstErr error
+	werr := &writeError{}
+	now := time.Now().UnixMilli()
+	for _, payload := range payloads {
+		ts := payload.TimestampMs
+		if ts == 0 {
+			ts = now
+		}
+		s, err := payloadSeries(payload, ts)
+		if err != nil {
+			rw.logger.Errorw("Invalid metric", zap.Any("payload", payload), zap.Error(err))
+			for _, datum := range payload.datums() {
+				werr.fail(datum, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		series = append(series, s...)
+	}
+	batchSize := rw.batchSize
+	if batchSize < 1 {
+		batchSize = len(series)
+	}
+	failedBatches := 0
+	for start := 0; start < len(series); start += batchSize {
+		end := start + batchSize
+		if end > len(series) {
+			end = len(series)
+		}
+		body, err := encodeWriteRequest(series[start:end])
+		if err == nil {
+			err = rw.send(ctx, snappy.Encode(nil, body))
+		}
+		if err != nil {
+			rw.logger.Errorw("Remote write failed", zap.Int("series", end-start), zap.Error(err))
+			failedBatches++
+			if firstErr == nil {
+				firstErr = err
+			}
+			for _, ts := range series[start:end] {
+				for _, datum := range ts.datums {
+					werr.fail(datum, err)
+				}
+			}
+			continue
+		}
+		rw.logger.Debugf("Remote write sent %d series", end-start)
+	}
+	if len(werr.datums) > 0 {
+		werr.err = fmt.Errorf("remote write failed for %d datums in %d batches: %w", len(werr.datums), failedBatches, firstErr)
+		return werr
+	}
+	return nil
+}
+
+// send posts one compressed WriteRequest, retrying 5xx and 429 responses with exponential backoff.
+func (rw *remoteWriter) send(ctx context.Context, body []byte) error {
+	return retryRequest(ctx, rw.logger, "Remote write", rw.maxRetries, rw.minBackoff, rw.maxBackoff, func() (time.Duration, bool, error) {
+		return rw.sendOnce(ctx, body)
+	})
+}
+
+func (rw *remoteWriter) sendOnce(ctx context.Context, body []byte) (time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rw.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "numaflow-prometheus-sink")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if rw.tenant != "" {
+		req.Header.Set(rw.tenantHeader, rw.tenant)
+	}
+	res, err := rw.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer res.Body.Close()
+	return responseError(res, "remote write", func(status int) bool {
+		return status == http.StatusTooManyRequests || status/100 == 5
+	})
+}
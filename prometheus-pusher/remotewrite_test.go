@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+)
+
+func testLabels(labels []prompb.Label) []label {
+	result := make([]label, 0, len(labels))
+	for _, l := range labels {
+		result = append(result, label{name: l.Name, value: l.Value})
+	}
+	return result
+}
+
+// decodeWriteRequest decodes a remote write request into series keyed by their __name__ label.
+func decodeWriteRequest(body []byte) (map[string][]timeSeries, error) {
+	var request prompb.WriteRequest
+	if err := request.Unmarshal(body); err != nil {
+		return nil, err
+	}
+	result := make(map[string][]timeSeries)
+	for _, promSeries := range request.Timeseries {
+		ts := timeSeries{labels: testLabels(promSeries.Labels)}
+		var name string
+		for _, l := range ts.labels {
+			if l.name == "__name__" {
+				name = l.value
+			}
+		}
+		for _, s := range promSeries.Samples {
+			ts.samples = append(ts.samples, sample{value: s.Value, timestampMs: s.Timestamp})
+		}
+		for _, e := range promSeries.Exemplars {
+			ts.exemplars = append(ts.exemplars, exemplar{labels: testLabels(e.Labels), value: e.Value, timestampMs: e.Timestamp})
+		}
+		result[name] = append(result[name], ts)
+	}
+	return result, nil
+}
+
+type remoteWriteReceiver struct {
+	mu       sync.Mutex
+	requests []map[string][]timeSeries
+	headers  []http.Header
+	statuses []int
+}
+
+func (rr *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if len(rr.statuses) > 0 {
+		status := rr.statuses[0]
+		rr.statuses = rr.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	compressed, _ := io.ReadAll(r.Body)
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	request, err := decodeWriteRequest(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rr.headers = append(rr.headers, r.Header.Clone())
+	rr.requests = append(rr.requests, request)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newTestRemoteWriter(url string) *remoteWriter {
+	return &remoteWriter{
+		logger:       logging.NewLogger().Named("remote-write"),
+		client:       http.DefaultClient,
+		url:          url,
+		batchSize:    3,
+		maxRetries:   2,
+		minBackoff:   time.Millisecond,
+		maxBackoff:   time.Millisecond,
+		tenant:       "team-a",
+		tenantHeader: "X-Scope-OrgID",
+	}
+}
+
+func TestRemoteWriter_Write(t *testing.T) {
+	receiver := &remoteWriteReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	rw := newTestRemoteWriter(server.URL)
+	payloads := []PrometheusPayload{
+		{Name: "anomaly_score", Type: "Gauge", Value: 0.49, TimestampMs: 1680124991883, Labels: map[string]string{"app": "web", "namespace": "ns"}},
+		{Name: "latency_seconds", Type: "Histogram", Count: 10, Sum: 4.2, TimestampMs: 1680124991884, Buckets: map[string]uint64{"0.1": 2, "1": 9}},
+	}
+	assert.NoError(t, rw.write(context.Background(), payloads))
+
+	// 1 gauge + 3 buckets + sum + count, batched by 3
+	assert.Len(t, receiver.requests, 2)
+	assert.Equal(t, "snappy", receiver.headers[0].Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", receiver.headers[0].Get("Content-Type"))
+	assert.Equal(t, "0.1.0", receiver.headers[0].Get("X-Prometheus-Remote-Write-Version"))
+	assert.Equal(t, "team-a", receiver.headers[0].Get("X-Scope-OrgID"))
+
+	gauge := receiver.requests[0]["anomaly_score"][0]
+	assert.Equal(t, []label{{"__name__", "anomaly_score"}, {"app", "web"}, {"namespace", "ns"}}, gauge.labels)
+	assert.Equal(t, []sample{{value: 0.49, timestampMs: 1680124991883}}, gauge.samples)
+
+	buckets := append(receiver.requests[0]["latency_seconds_bucket"], receiver.requests[1]["latency_seconds_bucket"]...)
+	assert.Len(t, buckets, 3)
+	assert.Equal(t, []label{{"__name__", "latency_seconds_bucket"}, {"le", "0.1"}}, buckets[0].labels)
+	assert.Equal(t, float64(2), buckets[0].samples[0].value)
+	assert.Equal(t, []label{{"__name__", "latency_seconds_bucket"}, {"le", "+Inf"}}, buckets[2].labels)
+	assert.Equal(t, float64(10), buckets[2].samples[0].value)
+	assert.Equal(t, 4.2, receiver.requests[1]["latency_seconds_sum"][0].samples[0].value)
+	assert.Equal(t, int64(1680124991884), receiver.requests[1]["latency_seconds_count"][0].samples[0].timestampMs)
+}
+
+func TestRemoteWriter_Retry(t *testing.T) {
+	receiver := &remoteWriteReceiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	rw := newTestRemoteWriter(server.URL)
+	payloads := []PrometheusPayload{{Name: "anomaly_score", Type: "Gauge", Value: 1}}
+	assert.NoError(t, rw.write(context.Background(), payloads))
+	assert.Len(t, receiver.requests, 1)
+	assert.NotZero(t, receiver.requests[0]["anomaly_score"][0].samples[0].timestampMs)
+
+	receiver.statuses = []int{http.StatusBadRequest}
+	assert.ErrorContains(t, rw.write(context.Background(), payloads), "status code 400")
+	assert.Len(t, receiver.requests, 1)
+
+	receiver.statuses = []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}
+	assert.ErrorContains(t, rw.write(context.Background(), payloads), "status code 500")
+	assert.Len(t, receiver.requests, 1)
+}
+
+func TestRemoteWriter_PartialFailure(t *testing.T) {
+	receiver := &remoteWriteReceiver{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	rw := newTestRemoteWriter(server.URL)
+	payloads := []PrometheusPayload{
+		{Name: "a", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "b", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "c", Type: "Gauge", Value: 1, datum: 0},
+		{Name: "d", Type: "Gauge", Value: 1, datum: 1},
+		{Name: "e", Type: "Counter", Value: -1, datum: 2},
+	}
+	err := rw.write(context.Background(), payloads)
+	var werr *writeError
+	if assert.ErrorAs(t, err, &werr) {
+		assert.Len(t, werr.datums, 2)
+		assert.Contains(t, werr.datums, 0)
+		assert.Contains(t, werr.datums, 2)
+	}
+	// The second batch is sent after the first one failed
+	assert.Len(t, receiver.requests, 1)
+	assert.Contains(t, receiver.requests[0], "d")
+}
+
+func TestPayloadSeries_Summary(t *testing.T) {
+	series, err := payloadSeries(PrometheusPayload{Name: "rpc", Type: "Summary", Count: 7, Sum: 1.4, Quantiles: map[string]float64{"0.99": 0.9, "0.5": 0.2}}, 1)
+	assert.NoError(t, err)
+	assert.Len(t, series, 4)
+	assert.Equal(t, []label{{"__name__", "rpc"}, {"quantile", "0.5"}}, series[0].labels)
+	assert.Equal(t, 0.9, series[1].samples[0].value)
+
+	_, err = payloadSeries(PrometheusPayload{Name: "c", Type: "Counter", Value: -1}, 1)
+	assert.ErrorContains(t, err, "non-negative")
+}
+
+func TestPayloadSeries_InfBucket(t *testing.T) {
+	series, err := payloadSeries(PrometheusPayload{Name: "latency", Type: "Histogram", Count: 3, Sum: 1, Buckets: map[string]uint64{"1": 2, "+Inf": 3}}, 1)
+	assert.NoError(t, err)
+	// The +Inf bucket is only written once
+	assert.Len(t, series, 4)
+	assert.Equal(t, []label{{"__name__", "latency_bucket"}, {"le", "+Inf"}}, series[1].labels)
+}
+
+func TestRemoteWriter_Exemplars(t *testing.T) {
+	receiver := &remoteWriteReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	rw := newTestRemoteWriter(server.URL)
+	payloads := []PrometheusPayload{
+		{Name: "anomaly_score", Type: "Gauge", Value: 0.9, TimestampMs: 1680124991883,
+			Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "abc", "span_id": "01"}, Value: 0.9}}},
+		{Name: "latency_seconds", Type: "Histogram", Count: 10, Sum: 4.2, TimestampMs: 1680124991884, Buckets: map[string]uint64{"0.1": 2, "1": 9},
+			Exemplars: []Exemplar{{Labels: map[string]string{"trace_id": "def"}, Value: 0.5, TimestampMs: 1680124991000},
+				{Labels: map[string]string{"trace_id": "ghi"}, Value: 3}}},
+	}
+	assert.NoError(t, rw.write(context.Background(), payloads))
+
+	gauge := receiver.requests[0]["anomaly_score"][0]
+	// Without a timestamp the exemplar uses the sample timestamp
+	assert.Equal(t, []exemplar{{labels: []label{{"span_id", "01"}, {"trace_id", "abc"}}, value: 0.9, timestampMs: 1680124991883}}, gauge.exemplars)
+
+	buckets := append(receiver.requests[0]["latency_seconds_bucket"], receiver.requests[1]["latency_seconds_bucket"]...)
+	assert.Empty(t, buckets[0].exemplars)
+	assert.Equal(t, []exemplar{{labels: []label{{"trace_id", "def"}}, value: 0.5, timestampMs: 1680124991000}}, buckets[1].exemplars)
+	assert.Equal(t, []exemplar{{labels: []label{{"trace_id", "ghi"}}, value: 3, timestampMs: 1680124991884}}, buckets[2].exemplars)
+
+	_, err := payloadSeries(PrometheusPayload{Name: "g", Type: "Gauge", Exemplars: []Exemplar{{Labels: map[string]string{"trace-id": "x"}}}}, 1)
+	assert.ErrorContains(t, err, "trace-id")
+}
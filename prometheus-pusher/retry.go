@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// unavailableError is returned when a request still fails with a retryable error after all retries,
+// the backend is considered unavailable rather than rejecting the request.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+// isUnavailable reports whether the error comes from an unavailable backend.
+func isUnavailable(err error) bool {
+	var uerr *unavailableError
+	return errors.As(err, &uerr)
+}
+
+// retryRequest calls sendOnce until it succeeds, fails with an error that is not retryable, or maxRetries is reached.
+// The backoff starts at minBackoff and doubles up to maxBackoff, a Retry-After returned by sendOnce replaces it but is
+// capped at maxBackoff. A retryable error left after the last retry is returned as an unavailableError. The wait
+// stops when the context is done, the last error is then returned with the cancellation rather than as unavailable.
+func retryRequest(ctx context.Context, logger *zap.SugaredLogger, target string, maxRetries int, minBackoff, maxBackoff time.Duration, sendOnce func() (time.Duration, bool, error)) error {
+	backoff := minBackoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, retryable, err := sendOnce()
+		if err == nil {
+			return nil
+		}
+		if !retryable {
+			return err
+		}
+		if attempt >= maxRetries {
+			return &unavailableError{err: err}
+		}
+		wait := backoff
+		if retryAfter > 0 {
+			wait = min(retryAfter, maxBackoff)
+		}
+		logger.Warnf("%s failed, retrying in %s. %v", target, wait, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, retry cancelled: %v", err, ctx.Err())
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// responseError returns nil for a 2xx response, otherwise an error with the start of the response body,
+// whether the status is retryable and the Retry-After delay of the response.
+func responseError(res *http.Response, target string, retryable func(status int) bool) (time.Duration, bool, error) {
+	if res.StatusCode/100 == 2 {
+		return 0, false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+	err := fmt.Errorf("%s returned status code %d: %s", target, res.StatusCode, bytes.TrimSpace(msg))
+	if !retryable(res.StatusCode) {
+		return 0, false, err
+	}
+	return retryAfter(res.Header), true, err
+}
+
+// retryAfter returns the delay in seconds of the Retry-After header, or 0.
+func retryAfter(header http.Header) time.Duration {
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StatsD flavors selected with -statsdFlavor
+const (
+	STATSD_FLAVOR_DOGSTATSD = "dogstatsd"
+	STATSD_FLAVOR_STATSD    = "statsd"
+)
+
+var (
+	statsdNameEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", ";", "_", "=", "_", " ", "_", "\n", "_", "\r", "_")
+	// DogStatsD tags are key:value pairs separated by commas, a colon is allowed in the value
+	dogstatsdTagKeyEscaper   = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_", "\r", "_")
+	dogstatsdTagValueEscaper = strings.NewReplacer("|", "_", "@", "_", "#", "_", ",", "_", "\n", "_", "\r", "_")
+	// Graphite tags are ;key=value pairs appended to the name
+	graphiteTagEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ";", "_", "=", "_", "~", "_", " ", "_", "\n", "_", "\r", "_")
+)
+
+// statsdLine is a StatsD line with the datums it was converted from. A counter line also holds the key of its series
+// and the value before the increase it sends.
+type statsdLine struct {
+	line     string
+	datums   []int
+	counter  string
+	previous float64
+}
+
+// statsdCounter is the last value of a counter series and when it was last seen.
+type statsdCounter struct {
+	value    float64
+	lastSeen time.Time
+}
+
+// statsdWriter sends payloads as StatsD gauges and counters over UDP. Histograms and summaries are sent as gauges of
+// their _bucket/quantile, _sum and _count series. StatsD counters are increments, so counters send the increase since
+// the previous sample of the series, the first sample of a series only sets the baseline.
+type statsdWriter struct {
+	logger        *zap.SugaredLogger
+	conn          net.Conn
+	flavor        string
+	prefix        string
+	maxPacketSize int
+	counterTTL    time.Duration
+
+	mu sync.Mutex
+	// counters is the last value sent of each counter series seen within counterTTL
+	counters map[string]statsdCounter
+}
+
+func newStatsdWriter(logger *zap.SugaredLogger, conn net.Conn, flavor, prefix string, maxPacketSize int, counterTTL time.Duration) (*statsdWriter, error) {
+	if flavor != STATSD_FLAVOR_DOGSTATSD && flavor != STATSD_FLAVOR_STATSD {
+		return nil, fmt.Errorf("unsupported StatsD flavor %q", flavor)
+	}
+	return &statsdWriter{logger: logger, conn: conn, flavor: flavor, prefix: prefix, maxPacketSize: maxPacketSize,
+		counterTTL: counterTTL, counters: make(map[string]statsdCounter)}, nil
+}
+
+// expireCounters forgets the counter series not seen within the counter TTL, the next sample of a forgotten series
+// sets the baseline again.
+func (sw *statsdWriter) expireCounters(now time.Time) {
+	if sw.counterTTL <= 0 {
+		return
+	}
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for key, counter := range sw.counters {
+		if now.Sub(counter.lastSeen) > sw.counterTTL {
+			delete(sw.counters, key)
+		}
+	}
+}
+
+// formatLine formats a StatsD line of the given type with the labels as tags.
+func (sw *statsdWriter) formatLine(name string, labels []label, value float64, kind string) string {
+	var sb strings.Builder
+	sb.WriteString(statsdNameEscaper.Replace(sw.prefix + name))
+	if sw.flavor == STATSD_FLAVOR_STATSD {
+		for _, l := range labels {
+			sb.WriteString(";")
+			sb.WriteString(graphiteTagEscaper.Replace(l.name))
+			sb.WriteString("=")
+			sb.WriteString(graphiteTagEscaper.Replace(l.value))
+		}
+	}
+	sb.WriteString(":")
+	sb.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	sb.WriteString("|")
+	sb.WriteString(kind)
+	if sw.flavor == STATSD_FLAVOR_DOGSTATSD {
+		for i, l := range labels {
+			if i == 0 {
+				sb.WriteString("|#")
+			} else {
+				sb.WriteString(",")
+			}
+			sb.WriteString(dogstatsdTagKeyEscaper.Replace(l.name))
+			sb.WriteString(":")
+			sb.WriteString(dogstatsdTagValueEscaper.Replace(l.value))
+		}
+	}
+	return sb.String()
+}
+
+// lines converts the payload into StatsD lines. The counter values of the batch are kept in pending, the increase
+// of a counter is relative to its previous value in the batch or, for its first sample, to the value last sent.
+func (sw *statsdWriter) lines(payload PrometheusPayload, pending map[string]float64) ([]statsdLine, error) {
+	series, err := payloadSeries(payload, 0)
+	if err != nil {
+		return nil, err
+	}
+	var lines []statsdLine
+	for _, s := range series {
+		var name string
+		labels := s.labels
+		for i, l := range s.labels {
+			if l.name == "__name__" {
+				name = l.value
+				labels = append(append([]label{}, s.labels[:i]...), s.labels[i+1:]...)
+				break
+			}
+		}
+		value := s.samples[0].value
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("%s is %v, which StatsD does not support", name, value)
+		}
+		if payload.metricType() != metricTypeCounter {
+			if value < 0 && sw.flavor == STATSD_FLAVOR_STATSD {
+				// A signed gauge value is a relative change in StatsD, the gauge is set to 0 first
+				lines = append(lines, statsdLine{line: sw.formatLine(name, labels, 0, "g")})
+			}
+			lines = append(lines, statsdLine{line: sw.formatLine(name, labels, value, "g")})
+			continue
+		}
+		key := name
+		for _, l := range labels {
+			key += "\xff" + l.name + "\xff" + l.value
+		}
+		previous, seen := pending[key]
+		if !seen {
+			sw.mu.Lock()
+			var counter statsdCounter
+			counter, seen = sw.counters[key]
+			previous = counter.value
+			sw.mu.Unlock()
+		}
+		pending[key] = value
+		increase := value - previous
+		if value < previous {
+			// The counter was reset
+			increase = value
+		}
+		if seen && increase > 0 {
+			lines = append(lines, statsdLine{line: sw.formatLine(name, labels, increase, "c"), counter: key, previous: previous})
+		}
+	}
+	return lines, nil
+}
+
+// write packs the lines of the payloads into datagrams of up to maxPacketSize bytes. A failed datagram only fails
+// the datums of its lines. The counter values are stored once the lines sending their increase were written.
+func (sw *statsdWriter) write(payloads []PrometheusPayload) error {
+	now := time.Now()
+	sw.expireCounters(now)
+	var lines []statsdLine
+	var firstErr error
+	werr := &writeError{}
+	pending := make(map[string]float64)
+	for _, payload := range payloads {
+		payloadLines, err := sw.lines(payload, pending)
+		if err != nil {
+			sw.logger.Errorw("Invalid metric", zap.Any("payload", payload), zap.Error(err))
+			for _, datum := range payload.datums() {
+				werr.fail(datum, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, line := range payloadLines {
+			line.datums = payload.datums()
+			lines = append(lines, line)
+		}
+	}
+	failedPackets := 0
+	reverted := make(map[string]struct{})
+	for start := 0; start < len(lines); {
+		// A line longer than maxPacketSize is sent on its own
+		size := len(lines[start].line)
+		end := start + 1
+		for end < len(lines) && size+1+len(lines[end].line) <= sw.maxPacketSize {
+			size += 1 + len(lines[end].line)
+			end++
+		}
+		packet := make([]string, 0, end-start)
+		for _, line := range lines[start:end] {
+			packet = append(packet, line.line)
+		}
+		if _, err := sw.conn.Write([]byte(strings.Join(packet, "\n"))); err != nil {
+			sw.logger.Errorw("StatsD write failed", zap.Int("lines", end-start), zap.Error(err))
+			failedPackets++
+			if firstErr == nil {
+				firstErr = err
+			}
+			// A counter keeps the value before its first failed line, so the retried datums send the increase again
+			for _, line := range lines[start:end] {
+				for _, datum := range line.datums {
+					werr.fail(datum, err)
+				}
+				if _, ok := reverted[line.counter]; line.counter != "" && !ok {
+					reverted[line.counter] = struct{}{}
+					pending[line.counter] = line.previous
+				}
+			}
+		}
+		start = end
+	}
+	sw.mu.Lock()
+	for key, value := range pending {
+		sw.counters[key] = statsdCounter{value: value, lastSeen: now}
+	}
+	sw.mu.Unlock()
+	if len(werr.datums) > 0 {
+		werr.err = fmt.Errorf("StatsD write failed for %d datums in %d packets: %w", len(werr.datums), failedPackets, firstErr)
+		return werr
+	}
+	return nil
+}
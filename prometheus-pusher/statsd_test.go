@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestStatsdWriter returns a writer sending to a local UDP listener.
+func newTestStatsdWriter(t *testing.T, flavor string, maxPacketSize int) (*statsdWriter, net.PacketConn) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	sw, err := newStatsdWriter(logging.NewLogger().Named("statsd"), conn, flavor, "numaflow.", maxPacketSize, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sw, listener
+}
+
+// failingConn is a connection whose writes fail while err is set.
+type failingConn struct {
+	net.Conn
+	err error
+}
+
+func (fc *failingConn) Write(b []byte) (int, error) {
+	if fc.err != nil {
+		return 0, fc.err
+	}
+	return fc.Conn.Write(b)
+}
+
+// lineStrings returns the text of the lines.
+func lineStrings(lines []statsdLine) []string {
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.line)
+	}
+	return texts
+}
+
+// readPackets reads the datagrams received by the listener until none arrives for a short while.
+func readPackets(t *testing.T, listener net.PacketConn) []string {
+	var packets []string
+	buf := make([]byte, 65535)
+	for {
+		_ = listener.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func TestStatsdWriter_FormatLine(t *testing.T) {
+	dog, _ := newStatsdWriter(nil, nil, STATSD_FLAVOR_DOGSTATSD, "", 1432, time.Hour)
+	plain, _ := newStatsdWriter(nil, nil, STATSD_FLAVOR_STATSD, "", 1432, time.Hour)
+	tests := []struct {
+		name      string
+		metric    string
+		labels    []label
+		value     float64
+		dogstatsd string
+		statsd    string
+	}{
+		{
+			name:      "tags",
+			metric:    "anomaly_score",
+			labels:    []label{{"app", "web"}, {"namespace", "ns"}},
+			value:     0.49,
+			dogstatsd: "anomaly_score:0.49|g|#app:web,namespace:ns",
+			statsd:    "anomaly_score;app=web;namespace=ns:0.49|g",
+		},
+		{
+			name:      "no tags",
+			metric:    "anomaly_score",
+			value:     1e6,
+			dogstatsd: "anomaly_score:1000000|g",
+			statsd:    "anomaly_score:1000000|g",
+		},
+		{
+			name:      "escaping",
+			metric:    "a:b|c@d#e",
+			labels:    []label{{"k:1", "v:1,x|y#z"}, {"k;2", "v=2 ;~"}},
+			value:     2,
+			dogstatsd: "a_b_c_d_e:2|g|#k_1:v:1_x_y_z,k;2:v=2 ;~",
+			statsd:    "a_b_c_d_e;k_1=v_1,x_y_z;k_2=v_2___:2|g",
+		},
+		{
+			name:      "newline",
+			metric:    "a",
+			labels:    []label{{"msg", "x\ny"}},
+			value:     3,
+			dogstatsd: "a:3|g|#msg:x_y",
+			statsd:    "a;msg=x_y:3|g",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.dogstatsd, dog.formatLine(tt.metric, tt.labels, tt.value, "g"))
+			assert.Equal(t, tt.statsd, plain.formatLine(tt.metric, tt.labels, tt.value, "g"))
+		})
+	}
+
+	_, err := newStatsdWriter(nil, nil, "graphite", "", 1432, time.Hour)
+	assert.ErrorContains(t, err, "unsupported StatsD flavor")
+}
+
+func TestStatsdWriter_Lines(t *testing.T) {
+	sw, _ := newStatsdWriter(nil, nil, STATSD_FLAVOR_STATSD, "", 1432, time.Hour)
+	pending := make(map[string]float64)
+	lines, err := sw.lines(PrometheusPayload{Name: "latency_seconds", Type: "Histogram", Count: 10, Sum: 4.2, Buckets: map[string]uint64{"0.1": 2}}, pending)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"latency_seconds_bucket;le=0.1:2|g", "latency_seconds_bucket;le=+Inf:10|g", "latency_seconds_sum:4.2|g", "latency_seconds_count:10|g"}, lineStrings(lines))
+
+	// Negative gauges are set to 0 first, as a signed value is a relative change
+	lines, err = sw.lines(PrometheusPayload{Name: "delta", Type: "Gauge", Value: -3}, pending)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"delta:0|g", "delta:-3|g"}, lineStrings(lines))
+
+	// Counters send their increase, the first sample is the baseline
+	counter := PrometheusPayload{Name: "requests_total", Type: "Counter", Value: 5, Labels: map[string]string{"app": "web"}}
+	lines, _ = sw.lines(counter, pending)
+	assert.Empty(t, lines)
+	counter.Value = 8
+	lines, _ = sw.lines(counter, pending)
+	assert.Equal(t, []string{"requests_total;app=web:3|c"}, lineStrings(lines))
+	assert.Equal(t, float64(5), lines[0].previous)
+	counter.Value = 8
+	lines, _ = sw.lines(counter, pending)
+	assert.Empty(t, lines)
+	// A reset sends the new value
+	counter.Value = 2
+	lines, _ = sw.lines(counter, pending)
+	assert.Equal(t, []string{"requests_total;app=web:2|c"}, lineStrings(lines))
+}
+
+func TestStatsdWriter_Counters(t *testing.T) {
+	sw, listener := newTestStatsdWriter(t, STATSD_FLAVOR_DOGSTATSD, 1432)
+	conn := &failingConn{Conn: sw.conn}
+	sw.conn = conn
+	counter := func(value float64) []PrometheusPayload {
+		return []PrometheusPayload{{Name: "requests_total", Type: "Counter", Value: value}}
+	}
+	assert.NoError(t, sw.write(counter(5)))
+
+	// The value is only stored once its increase was sent, so the retry sends it again
+	conn.err = net.ErrClosed
+	assert.Error(t, sw.write(counter(8)))
+	assert.Equal(t, float64(5), sw.counters["requests_total"].value)
+	conn.err = nil
+	assert.NoError(t, sw.write(counter(8)))
+	assert.Equal(t, []string{"numaflow.requests_total:3|c"}, readPackets(t, listener))
+
+	// Counters not seen within the TTL are forgotten
+	sw.expireCounters(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, sw.counters)
+	assert.NoError(t, sw.write(counter(10)))
+	assert.Empty(t, readPackets(t, listener))
+}
+
+func TestStatsdWriter_Write(t *testing.T) {
+	sw, listener := newTestStatsdWriter(t, STATSD_FLAVOR_DOGSTATSD, 1432)
+	payloads := []PrometheusPayload{
+		{Name: "anomaly_score", Type: "Gauge", Value: 0.49, Labels: map[string]string{"app": "web"}},
+		{Name: "anomaly_score", Type: "Gauge", Value: 0.2, Labels: map[string]string{"app": "api"}},
+	}
+	assert.NoError(t, sw.write(payloads))
+	assert.Equal(t, []string{"numaflow.anomaly_score:0.49|g|#app:web\nnumaflow.anomaly_score:0.2|g|#app:api"}, readPackets(t, listener))
+}
+
+func TestStatsdWriter_PacketSize(t *testing.T) {
+	sw, listener := newTestStatsdWriter(t, STATSD_FLAVOR_DOGSTATSD, 40)
+	payloads := []PrometheusPayload{
+		{Name: "a", Type: "Gauge", Value: 1},
+		{Name: "b", Type: "Gauge", Value: 2},
+		{Name: "c", Type: "Gauge", Value: 3, Labels: map[string]string{"long": strings.Repeat("x", 50)}},
+		{Name: "d", Type: "Gauge", Value: 4},
+		{Name: "e", Type: "Counter", Value: -1, datum: 1},
+	}
+	err := sw.write(payloads)
+	var werr *writeError
+	if assert.ErrorAs(t, err, &werr) {
+		assert.Len(t, werr.datums, 1)
+		assert.Contains(t, werr.datums, 1)
+	}
+	packets := readPackets(t, listener)
+	assert.Equal(t, []string{"numaflow.a:1|g\nnumaflow.b:2|g", "numaflow.c:3|g|#long:" + strings.Repeat("x", 50), "numaflow.d:4|g"}, packets)
+}
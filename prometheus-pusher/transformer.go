@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	"gopkg.in/yaml.v3"
+
+	sharedexpr "github.com/numaproj/numaflow-sinks/prometheus-pusher/shared/expr"
+)
+
+// transformerPresets are the built-in transformer configurations selected with -transformerPreset.
+var transformerPresets = map[string]string{
+	// anomaly maps the numalogic anomaly schema: the unified anomaly score and one score per entry of data,
+	// labelled with the metadata. model_version is rendered like the other metadata labels.
+	"anomaly": `
+metrics:
+  - nameExpr: metricsName
+    value: number(payload.unified_anomaly)
+    precision: 4
+    timestamp: payload.timestamp
+    namespace: 'payload.metadata == nil ? "" : payload.metadata.namespace'
+    subsystem: none
+    type: Gauge
+    labelsFrom: payload.metadata
+    skipLabels: [artifact_versions]
+  - forEach: payload.data
+    nameExpr: key + "_anomaly"
+    value: number(value)
+    precision: 4
+    timestamp: payload.timestamp
+    namespace: 'payload.metadata == nil ? "" : payload.metadata.namespace'
+    subsystem: none
+    type: Gauge
+    labelsFrom: payload.metadata
+    skipLabels: [artifact_versions]
+`,
+}
+
+// transformerConfig maps a JSON message to metrics. Expressions are expr expressions evaluated with
+// the message as payload, e.g. payload.metadata.app.
+type transformerConfig struct {
+	Metrics []metricMapping `yaml:"metrics"`
+}
+
+type metricMapping struct {
+	// ForEach is an expression returning a map, the mapping produces a metric per entry with key and value set
+	ForEach string `yaml:"forEach"`
+	// Name is the metric name, NameExpr an expression returning it
+	Name     string `yaml:"name"`
+	NameExpr string `yaml:"nameExpr"`
+	// Value, Timestamp (in milliseconds) and Namespace are expressions
+	Value     string `yaml:"value"`
+	Timestamp string `yaml:"timestamp"`
+	Namespace string `yaml:"namespace"`
+	Subsystem string `yaml:"subsystem"`
+	Type      string `yaml:"type"`
+	// Labels maps a label name to an expression, a nil result omits the label
+	Labels map[string]string `yaml:"labels"`
+	// LabelsFrom is an expression returning a map whose entries become labels, except SkipLabels.
+	// Nested maps are flattened, their keys joined with FlattenSeparator (default "_").
+	LabelsFrom       string   `yaml:"labelsFrom"`
+	SkipLabels       []string `yaml:"skipLabels"`
+	FlattenSeparator string   `yaml:"flattenSeparator"`
+	// Precision rounds the value to the number of decimal places, the value is kept as is when unset
+	Precision *int `yaml:"precision"`
+	// LabelPrecision is the number of decimal places of numeric label values, the shortest representation when unset
+	LabelPrecision *int `yaml:"labelPrecision"`
+}
+
+// transformerOverrides replace the settings of every metric of a transformer config, so presets can be tuned with flags.
+type transformerOverrides struct {
+	precision        int
+	labelPrecision   int
+	skipLabels       []string
+	flattenSeparator string
+}
+
+func (o transformerOverrides) apply(config *transformerConfig) {
+	for i := range config.Metrics {
+		if o.precision >= 0 {
+			precision := o.precision
+			config.Metrics[i].Precision = &precision
+		}
+		if o.labelPrecision >= 0 {
+			labelPrecision := o.labelPrecision
+			config.Metrics[i].LabelPrecision = &labelPrecision
+		}
+		if len(o.skipLabels) > 0 {
+			config.Metrics[i].SkipLabels = o.skipLabels
+		}
+		if o.flattenSeparator != "" {
+			config.Metrics[i].FlattenSeparator = o.flattenSeparator
+		}
+	}
+}
+
+type compiledMapping struct {
+	mapping    metricMapping
+	forEach    *vm.Program
+	name       *vm.Program
+	value      *vm.Program
+	timestamp  *vm.Program
+	namespace  *vm.Program
+	labelsFrom *vm.Program
+	labels     map[string]*vm.Program
+}
+
+// transformer converts messages to payloads with the compiled mappings of a transformerConfig.
+type transformer struct {
+	metricsName string
+	mappings    []compiledMapping
+}
+
+func loadTransformerConfig(path, preset string) (transformerConfig, error) {
+	var data []byte
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return transformerConfig{}, err
+		}
+	} else {
+		config, ok := transformerPresets[preset]
+		if !ok {
+			return transformerConfig{}, fmt.Errorf("unknown transformer preset %q", preset)
+		}
+		data = []byte(config)
+	}
+	// Unknown fields are rejected, a misspelled field would otherwise be dropped silently
+	var config transformerConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return transformerConfig{}, fmt.Errorf("invalid transformer config: %w", err)
+	}
+	return config, nil
+}
+
+func compileExpression(expression string) (*vm.Program, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	program, err := expr.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile expression '%s': %w", expression, err)
+	}
+	return program, nil
+}
+
+func newTransformer(config transformerConfig, metricsName string) (*transformer, error) {
+	if len(config.Metrics) == 0 {
+		return nil, fmt.Errorf("transformer config has no metrics")
+	}
+	t := &transformer{metricsName: metricsName}
+	for i, mapping := range config.Metrics {
+		if (mapping.Name == "") == (mapping.NameExpr == "") {
+			return nil, fmt.Errorf("metric %d must have one of name and nameExpr", i)
+		}
+		if mapping.Value == "" {
+			return nil, fmt.Errorf("metric %d has no value", i)
+		}
+		if mapping.Type == "" {
+			mapping.Type = "Gauge"
+		}
+		if mapping.FlattenSeparator == "" {
+			mapping.FlattenSeparator = "_"
+		}
+		c := compiledMapping{mapping: mapping, labels: make(map[string]*vm.Program, len(mapping.Labels))}
+		var err error
+		for _, field := range []struct {
+			program    **vm.Program
+			expression string
+		}{
+			{&c.forEach, mapping.ForEach},
+			{&c.name, mapping.NameExpr},
+			{&c.value, mapping.Value},
+			{&c.timestamp, mapping.Timestamp},
+			{&c.namespace, mapping.Namespace},
+			{&c.labelsFrom, mapping.LabelsFrom},
+		} {
+			if *field.program, err = compileExpression(field.expression); err != nil {
+				return nil, fmt.Errorf("metric %d: %w", i, err)
+			}
+		}
+		for name, expression := range mapping.Labels {
+			if c.labels[name], err = compileExpression(expression); err != nil {
+				return nil, fmt.Errorf("metric %d label %s: %w", i, name, err)
+			}
+		}
+		t.mappings = append(t.mappings, c)
+	}
+	return t, nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch w := v.(type) {
+	case float64:
+		return w, nil
+	case int:
+		return float64(w), nil
+	case int64:
+		return float64(w), nil
+	case string:
+		return strconv.ParseFloat(w, 64)
+	default:
+		return 0, fmt.Errorf("cannot convert %v to a number", v)
+	}
+}
+
+// number converts a value to a number, anything that is not a number is 0.
+func number(v interface{}) float64 {
+	f, err := toFloat(v)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+func roundFloat(f float64, digits int) float64 {
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'f', digits, 64), 64)
+	return f
+}
+
+// round rounds a number to the digits, anything that is not a number is 0.
+func round(v interface{}, digits int) float64 {
+	return roundFloat(number(v), digits)
+}
+
+// renderLabel renders a label value by its type. Numbers use the precision, or their shortest representation
+// when it is negative, lists and maps are rendered as JSON.
+func renderLabel(v interface{}, precision int) string {
+	switch w := v.(type) {
+	case string:
+		return w
+	case float64:
+		return strconv.FormatFloat(w, 'f', precision, 64)
+	case int:
+		return strconv.Itoa(w)
+	case bool:
+		return strconv.FormatBool(w)
+	case []interface{}, map[string]interface{}:
+		b, err := json.Marshal(w)
+		if err != nil {
+			return fmt.Sprint(w)
+		}
+		return string(b)
+	default:
+		return fmt.Sprint(w)
+	}
+}
+
+// flattenLabels adds the entries of a map as labels, joining the keys of nested maps with the separator.
+// An entry is skipped when its flattened name or its dotted path is in skip.
+func flattenLabels(labels map[string]string, prefix, path string, values map[string]interface{}, c *compiledMapping) {
+	for key, val := range values {
+		name, dotted := key, key
+		if prefix != "" {
+			name = prefix + c.mapping.FlattenSeparator + key
+			dotted = path + "." + key
+		}
+		if slices.Contains(c.mapping.SkipLabels, name) || slices.Contains(c.mapping.SkipLabels, dotted) {
+			continue
+		}
+		switch w := val.(type) {
+		case nil:
+		case map[string]interface{}:
+			flattenLabels(labels, name, dotted, w, c)
+		default:
+			labels[name] = renderLabel(w, c.labelPrecision())
+		}
+	}
+}
+
+func (c *compiledMapping) labelPrecision() int {
+	if c.mapping.LabelPrecision == nil {
+		return -1
+	}
+	return *c.mapping.LabelPrecision
+}
+
+func (t *transformer) env(payload map[string]interface{}) map[string]interface{} {
+	env := sharedexpr.GetFuncMap(map[string]interface{}{sharedexpr.JsonRoot: payload})
+	env["metricsName"] = t.metricsName
+	env["round"] = round
+	env["number"] = number
+	env["printf"] = fmt.Sprintf
+	return env
+}
+
+// transform converts a JSON message into payloads.
+func (t *transformer) transform(msg []byte) ([]PrometheusPayload, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(msg, &payload); err != nil {
+		return nil, err
+	}
+	env := t.env(payload)
+	var payloads []PrometheusPayload
+	for _, c := range t.mappings {
+		if c.forEach == nil {
+			pl, err := c.apply(env)
+			if err != nil {
+				return nil, err
+			}
+			payloads = append(payloads, pl)
+			continue
+		}
+		result, err := expr.Run(c.forEach, env)
+		if err != nil {
+			return nil, err
+		}
+		entries, ok := result.(map[string]interface{})
+		if !ok && result != nil {
+			return nil, fmt.Errorf("forEach '%s' returned %T instead of a map", c.mapping.ForEach, result)
+		}
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			env["key"], env["value"] = key, entries[key]
+			pl, err := c.apply(env)
+			if err != nil {
+				return nil, err
+			}
+			payloads = append(payloads, pl)
+		}
+		delete(env, "key")
+		delete(env, "value")
+	}
+	return payloads, nil
+}
+
+func (c *compiledMapping) apply(env map[string]interface{}) (PrometheusPayload, error) {
+	pl := PrometheusPayload{Name: c.mapping.Name, Subsystem: c.mapping.Subsystem, Type: c.mapping.Type}
+	if c.name != nil {
+		name, err := expr.Run(c.name, env)
+		if err != nil {
+			return pl, err
+		}
+		pl.Name = renderLabel(name, -1)
+	}
+	value, err := expr.Run(c.value, env)
+	if err != nil {
+		return pl, err
+	}
+	if pl.Value, err = toFloat(value); err != nil {
+		return pl, fmt.Errorf("metric %s value: %w", pl.Name, err)
+	}
+	if c.mapping.Precision != nil {
+		pl.Value = roundFloat(pl.Value, *c.mapping.Precision)
+	}
+	if c.timestamp != nil {
+		ts, err := expr.Run(c.timestamp, env)
+		if err != nil {
+			return pl, err
+		}
+		if ts != nil {
+			f, err := toFloat(ts)
+			if err != nil {
+				return pl, fmt.Errorf("metric %s timestamp: %w", pl.Name, err)
+			}
+			pl.TimestampMs = int64(f)
+		}
+	}
+	if c.namespace != nil {
+		namespace, err := expr.Run(c.namespace, env)
+		if err != nil {
+			return pl, err
+		}
+		if namespace != nil {
+			pl.Namespace = renderLabel(namespace, -1)
+		}
+	}
+	pl.Labels = make(map[string]string)
+	if c.labelsFrom != nil {
+		result, err := expr.Run(c.labelsFrom, env)
+		if err != nil {
+			return pl, err
+		}
+		labels, ok := result.(map[string]interface{})
+		if !ok && result != nil {
+			return pl, fmt.Errorf("labelsFrom '%s' returned %T instead of a map", c.mapping.LabelsFrom, result)
+		}
+		flattenLabels(pl.Labels, "", "", labels, c)
+	}
+	for name, program := range c.labels {
+		val, err := expr.Run(program, env)
+		if err != nil {
+			return pl, err
+		}
+		if val != nil {
+			pl.Labels[name] = renderLabel(val, c.labelPrecision())
+		}
+	}
+	return pl, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformer_AnomalyPreset(t *testing.T) {
+	msg := `{"timestamp":1701201827,"unified_anomaly":1.23456,"data":{"cpu":0.517299409015888,"latency":null},
+		"metadata":{"model_version":0,"artifact_versions":{"LSTMAE":"0"},"app":"test-app","namespace":"test-namespace","replicas":2}}`
+	payloads := anomalyPayloads(t, msg)
+	assert.Len(t, payloads, 3)
+	assert.Equal(t, PrometheusPayload{Name: "test", Namespace: "test-namespace", Subsystem: "none", Type: "Gauge", Value: 1.2346, TimestampMs: 1701201827,
+		Labels: map[string]string{"app": "test-app", "namespace": "test-namespace", "model_version": "0", "replicas": "2"}}, payloads[0])
+	assert.Equal(t, "cpu_anomaly", payloads[1].Name)
+	assert.Equal(t, 0.5173, payloads[1].Value)
+	assert.Equal(t, "latency_anomaly", payloads[2].Name)
+	assert.Equal(t, float64(0), payloads[2].Value)
+
+	// model_version is rendered by type like the other labels
+	for version, label := range map[string]string{`1.25`: "1.25", `"v1.2.3"`: "v1.2.3", `2`: "2"} {
+		payloads = anomalyPayloads(t, `{"timestamp":1701201827,"unified_anomaly":0.5,"data":{"cpu":0.5},"metadata":{"model_version":`+version+`}}`)
+		for _, payload := range payloads {
+			assert.Equal(t, label, payload.Labels["model_version"])
+		}
+	}
+
+	// -transformerLabelPrecision keeps the former one decimal place format
+	config, err := loadTransformerConfig("", "anomaly")
+	assert.NoError(t, err)
+	transformerOverrides{precision: -1, labelPrecision: 1}.apply(&config)
+	tr, err := newTransformer(config, "test")
+	assert.NoError(t, err)
+	payloads, err = tr.transform([]byte(`{"timestamp":1701201827,"unified_anomaly":0.5,"metadata":{"model_version":1}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0", payloads[0].Labels["model_version"])
+
+	// Metadata is optional
+	payloads = anomalyPayloads(t, `{"timestamp":1701201827,"unified_anomaly":0.5}`)
+	assert.Len(t, payloads, 1)
+	assert.Equal(t, "", payloads[0].Namespace)
+	assert.Empty(t, payloads[0].Labels)
+}
+
+func TestTransformer_Config(t *testing.T) {
+	path := writeFile(t, "transformer.yaml", `
+metrics:
+  - name: http_requests_total
+    type: Counter
+    value: payload.stats.requests
+    timestamp: payload.ts * 1000
+    labels:
+      service: payload.service
+      region: payload.region
+  - forEach: payload.latencies
+    nameExpr: '"latency_" + key + "_seconds"'
+    value: value / 1000
+    labelsFrom: payload.tags
+    skipLabels: [internal]
+`)
+	config, err := loadTransformerConfig(path, "anomaly")
+	assert.NoError(t, err)
+	tr, err := newTransformer(config, "")
+	assert.NoError(t, err)
+	payloads, err := tr.transform([]byte(`{"ts":1701201827,"service":"web","stats":{"requests":"42"},
+		"latencies":{"p99":250,"p50":20},"tags":{"team":"a","internal":"x","shard":3}}`))
+	assert.NoError(t, err)
+	assert.Len(t, payloads, 3)
+	assert.Equal(t, PrometheusPayload{Name: "http_requests_total", Type: "Counter", Value: 42, TimestampMs: 1701201827000,
+		Labels: map[string]string{"service": "web"}}, payloads[0])
+	assert.Equal(t, "latency_p50_seconds", payloads[1].Name)
+	assert.Equal(t, "Gauge", payloads[1].Type)
+	assert.Equal(t, map[string]string{"team": "a", "shard": "3"}, payloads[1].Labels)
+	assert.Equal(t, 0.25, payloads[2].Value)
+
+	_, err = tr.transform([]byte(`{"stats":{"requests":"many"}}`))
+	assert.ErrorContains(t, err, "value")
+	_, err = tr.transform([]byte(`{"ts":1,"stats":{"requests":1},"latencies":[1]}`))
+	assert.ErrorContains(t, err, "instead of a map")
+}
+
+func TestTransformer_InvalidConfig(t *testing.T) {
+	_, err := loadTransformerConfig("", "unknown")
+	assert.ErrorContains(t, err, "unknown transformer preset")
+	_, err = loadTransformerConfig(writeFile(t, "transformer.yaml", "metrics:\n  - name: m\n    value: payload.v\n    label:\n      app: payload.app\n"), "")
+	assert.ErrorContains(t, err, "field label not found")
+	_, err = newTransformer(transformerConfig{}, "")
+	assert.Error(t, err)
+	_, err = newTransformer(transformerConfig{Metrics: []metricMapping{{Value: "1"}}}, "")
+	assert.ErrorContains(t, err, "name")
+	_, err = newTransformer(transformerConfig{Metrics: []metricMapping{{Name: "m"}}}, "")
+	assert.ErrorContains(t, err, "no value")
+	_, err = newTransformer(transformerConfig{Metrics: []metricMapping{{Name: "m", Value: "payload.("}}}, "")
+	assert.ErrorContains(t, err, "unable to compile")
+}
+
+func TestTransformer_Labels(t *testing.T) {
+	labelPrecision := 2
+	config := transformerConfig{Metrics: []metricMapping{{
+		Name:           "score",
+		Value:          "payload.score",
+		LabelsFrom:     "payload.metadata",
+		SkipLabels:     []string{"build.commit"},
+		LabelPrecision: &labelPrecision,
+	}}}
+	msg := []byte(`{"score":0.123456789,"metadata":{"replicas":3,"ratio":0.3333,"canary":true,"owner":null,
+		"zones":["a","b"],"build":{"version":"1.2","commit":"abc","flags":{"debug":false}}}}`)
+
+	tr, err := newTransformer(config, "")
+	assert.NoError(t, err)
+	payloads, err := tr.transform(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.123456789, payloads[0].Value)
+	assert.Equal(t, map[string]string{"replicas": "3.00", "ratio": "0.33", "canary": "true", "zones": `["a","b"]`,
+		"build_version": "1.2", "build_flags_debug": "false"}, payloads[0].Labels)
+
+	// Flags replace the settings of the config
+	transformerOverrides{precision: 3, skipLabels: []string{"build", "zones"}, flattenSeparator: "."}.apply(&config)
+	config.Metrics[0].LabelPrecision = nil
+	tr, err = newTransformer(config, "")
+	assert.NoError(t, err)
+	payloads, err = tr.transform(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.123, payloads[0].Value)
+	assert.Equal(t, map[string]string{"replicas": "3", "ratio": "0.3333", "canary": "true"}, payloads[0].Labels)
+
+	config.Metrics[0].SkipLabels = nil
+	tr, err = newTransformer(config, "")
+	assert.NoError(t, err)
+	payloads, err = tr.transform(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", payloads[0].Labels["build.commit"])
+	assert.Equal(t, "false", payloads[0].Labels["build.flags.debug"])
+}
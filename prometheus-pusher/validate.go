@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validation modes selected with -validation
+const (
+	VALIDATION_SANITIZE = "sanitize"
+	VALIDATION_REJECT   = "reject"
+	VALIDATION_FAIL     = "fail"
+)
+
+// Reasons a payload is rejected, used as the reason label of total_metrics_rejected
+const (
+	reasonInvalidMetricName  = "invalid_metric_name"
+	reasonInvalidLabelName   = "invalid_label_name"
+	reasonInvalidLabelValue  = "invalid_label_value"
+	reasonReservedLabelName  = "reserved_label_name"
+	reasonDuplicateLabelName = "duplicate_label_name"
+)
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	invalidLabelChar = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+type validationError struct {
+	reason string
+	msg    string
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+// sanitizeName replaces the characters not allowed by the pattern with underscores and prefixes a leading digit.
+func sanitizeName(name string, invalid *regexp.Regexp) string {
+	name = invalid.ReplaceAllString(strings.ToValidUTF8(name, "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// reservedLabel reports whether the label name is reserved for the metric type.
+func reservedLabel(payload *PrometheusPayload, name string) bool {
+	switch {
+	case strings.HasPrefix(name, "__"):
+		return true
+	case name == "le":
+		return payload.metricType() == metricTypeHistogram
+	case name == "quantile":
+		return payload.metricType() == metricTypeSummary
+	}
+	return false
+}
+
+// validatePayload checks the metric name and labels of the payload. When sanitize is set, invalid names and
+// values are fixed in place and only problems that cannot be fixed are returned.
+func validatePayload(payload *PrometheusPayload, sanitize bool) *validationError {
+	if !metricNameRegexp.MatchString(payload.Name) {
+		if !sanitize {
+			return &validationError{reason: reasonInvalidMetricName, msg: fmt.Sprintf("invalid metric name %q", payload.Name)}
+		}
+		payload.Name = sanitizeName(payload.Name, invalidNameChars)
+	}
+	var labels map[string]string
+	for name, value := range payload.Labels {
+		validName := labelNameRegexp.MatchString(name) && !reservedLabel(payload, name)
+		if validName && utf8.ValidString(value) {
+			continue
+		}
+		if !sanitize {
+			switch {
+			case !labelNameRegexp.MatchString(name):
+				return &validationError{reason: reasonInvalidLabelName, msg: fmt.Sprintf("metric %s has an invalid label name %q", payload.Name, name)}
+			case !validName:
+				return &validationError{reason: reasonReservedLabelName, msg: fmt.Sprintf("metric %s has a reserved label name %q", payload.Name, name)}
+			default:
+				return &validationError{reason: reasonInvalidLabelValue, msg: fmt.Sprintf("metric %s label %s has an invalid UTF-8 value", payload.Name, name)}
+			}
+		}
+		if labels == nil {
+			labels = make(map[string]string, len(payload.Labels))
+		}
+	}
+	if labels == nil {
+		return nil
+	}
+	// The labels map can be shared between payloads, so the sanitized labels are written to a new one
+	for name, value := range payload.Labels {
+		if reservedLabel(payload, name) {
+			name = "exported_" + strings.TrimLeft(name, "_")
+		}
+		if !labelNameRegexp.MatchString(name) {
+			name = sanitizeName(strings.TrimLeft(name, "_"), invalidLabelChar)
+		}
+		if _, ok := labels[name]; ok {
+			return &validationError{reason: reasonDuplicateLabelName, msg: fmt.Sprintf("metric %s has several labels sanitized to %q", payload.Name, name)}
+		}
+		labels[name] = strings.ToValidUTF8(value, "�")
+	}
+	payload.Labels = labels
+	return nil
+}
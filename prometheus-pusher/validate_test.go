@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePayload(t *testing.T) {
+	payload := PrometheusPayload{Name: "cpu_anomaly", Type: "Gauge", Labels: map[string]string{"app": "web"}}
+	assert.Nil(t, validatePayload(&payload, false))
+
+	tests := []struct {
+		payload PrometheusPayload
+		reason  string
+	}{
+		{PrometheusPayload{Name: "cpu-usage anomaly", Type: "Gauge"}, reasonInvalidMetricName},
+		{PrometheusPayload{Name: "5xx_anomaly", Type: "Gauge"}, reasonInvalidMetricName},
+		{PrometheusPayload{Name: "m", Type: "Gauge", Labels: map[string]string{"pod-name": "p1"}}, reasonInvalidLabelName},
+		{PrometheusPayload{Name: "m", Type: "Gauge", Labels: map[string]string{"__name__": "n"}}, reasonReservedLabelName},
+		{PrometheusPayload{Name: "m", Type: "Histogram", Labels: map[string]string{"le": "1"}}, reasonReservedLabelName},
+		{PrometheusPayload{Name: "m", Type: "Gauge", Labels: map[string]string{"app": "\xff"}}, reasonInvalidLabelValue},
+	}
+	for _, test := range tests {
+		err := validatePayload(&test.payload, false)
+		if assert.NotNil(t, err, test.payload.Name) {
+			assert.Equal(t, test.reason, err.reason)
+		}
+	}
+	// A le label is only reserved for histograms
+	payload = PrometheusPayload{Name: "m", Type: "Gauge", Labels: map[string]string{"le": "1"}}
+	assert.Nil(t, validatePayload(&payload, false))
+}
+
+func TestValidatePayload_Sanitize(t *testing.T) {
+	labels := map[string]string{"pod-name": "p1", "app": "\xffweb", "__name__": "n", "le": "1", "9lives": "y"}
+	payload := PrometheusPayload{Name: "5xx-rate anomaly", Type: "Histogram", Labels: labels}
+	assert.Nil(t, validatePayload(&payload, true))
+	assert.Equal(t, "_5xx_rate_anomaly", payload.Name)
+	assert.Equal(t, map[string]string{"pod_name": "p1", "app": "�web", "exported_name__": "n", "exported_le": "1", "_9lives": "y"}, payload.Labels)
+	// The original labels are not modified
+	assert.Equal(t, "p1", labels["pod-name"])
+
+	payload = PrometheusPayload{Name: "m", Type: "Gauge", Labels: map[string]string{"pod-name": "a", "pod_name": "b"}}
+	err := validatePayload(&payload, true)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, reasonDuplicateLabelName, err.reason)
+	}
+}
+
+func TestPrometheusSink_Validate(t *testing.T) {
+	ps := prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), validation: VALIDATION_REJECT}
+	ps.metrics = NewMetricsServer(nil, "test_validate")
+	payloads := []PrometheusPayload{
+		{Name: "cpu_anomaly", Type: "Gauge"},
+		{Name: "mem-anomaly", Type: "Gauge"},
+		{Name: "disk_anomaly", Type: "Gauge", Labels: map[string]string{"pod name": "p1"}},
+	}
+	failed := make(map[int]error)
+	valid := ps.validate(payloads, failed)
+	assert.Len(t, valid, 1)
+	assert.Empty(t, failed)
+	assert.Equal(t, float64(1), testutil.ToFloat64(ps.metrics.metricsTotalRejected.WithLabelValues(reasonInvalidMetricName)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ps.metrics.metricsTotalRejected.WithLabelValues(reasonInvalidLabelName)))
+
+	ps.validation = VALIDATION_SANITIZE
+	valid = ps.validate(payloads, failed)
+	assert.Len(t, valid, 3)
+	assert.Equal(t, "mem_anomaly", valid[1].Name)
+
+	// In fail mode the other payloads of a failed datum are dropped too
+	ps.validation = VALIDATION_FAIL
+	payloads[1].datum = 1
+	payloads[2].datum = 1
+	valid = ps.validate(payloads, failed)
+	assert.Len(t, valid, 1)
+	assert.Equal(t, "cpu_anomaly", valid[0].Name)
+	assert.ErrorContains(t, failed[1], "invalid metric name")
+	assert.Equal(t, float64(2), testutil.ToFloat64(ps.metrics.metricsTotalRejected.WithLabelValues(reasonInvalidMetricName)))
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	walSegmentExt = ".json"
+	walTmpExt     = ".tmp"
+	walCorruptExt = ".corrupt"
+)
+
+// walSegment is a file of the write-ahead buffer, holding the payloads of one batch.
+type walSegment struct {
+	CreatedMs int64               `json:"createdMs"`
+	Payloads  []PrometheusPayload `json:"payloads"`
+}
+
+// walEntry is a segment kept in memory without its payloads.
+type walEntry struct {
+	path      string
+	createdMs int64
+	payloads  int
+	size      int64
+}
+
+// walBuffer is a bounded on-disk buffer of the payloads that could not be written because the backend is
+// unavailable. The datums of buffered payloads are acknowledged, and the payloads are replayed in order in the
+// background once the backend recovers. While the buffer is not empty, new payloads are buffered behind it so they
+// are never written before older ones.
+type walBuffer struct {
+	logger   *zap.SugaredLogger
+	dir      string
+	maxBytes int64
+	metrics  *MetricsPublisher
+	now      func() time.Time
+
+	// replayMu serializes the replays, flushMu the flushes, mu guards the entries
+	replayMu sync.Mutex
+	flushMu  sync.Mutex
+	mu       sync.Mutex
+	entries  []walEntry
+	size     int64
+	nextSeq  uint64
+}
+
+// openWALBuffer opens the buffer in dir, loading the segments left by a previous run.
+func openWALBuffer(logger *zap.SugaredLogger, dir string, maxBytes int64, metrics *MetricsPublisher) (*walBuffer, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("write-ahead buffer size must be positive, got %d", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	b := &walBuffer{logger: logger, dir: dir, maxBytes: maxBytes, metrics: metrics, now: time.Now}
+	// os.ReadDir sorts the files by name, the zero-padded sequence keeps the segments in write order
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		switch filepath.Ext(file.Name()) {
+		case walTmpExt:
+			// A segment that was not completely written
+			_ = os.Remove(path)
+			continue
+		case walSegmentExt:
+		default:
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), walSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segment, size, err := readWALSegment(path)
+		if err != nil {
+			logger.Errorw("Skipping corrupt write-ahead buffer segment", zap.String("path", path), zap.Error(err))
+			_ = os.Rename(path, path+walCorruptExt)
+			continue
+		}
+		b.entries = append(b.entries, walEntry{path: path, createdMs: segment.CreatedMs, payloads: len(segment.Payloads), size: size})
+		b.size += size
+		b.nextSeq = max(b.nextSeq, seq+1)
+	}
+	if len(b.entries) > 0 {
+		logger.Infof("Loaded %d write-ahead buffer segments with %d bytes", len(b.entries), b.size)
+	}
+	b.updateMetrics()
+	return b, nil
+}
+
+func readWALSegment(path string) (walSegment, int64, error) {
+	var segment walSegment
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return segment, 0, err
+	}
+	if err := json.Unmarshal(data, &segment); err != nil {
+		return segment, 0, err
+	}
+	return segment, int64(len(data)), nil
+}
+
+// writeSegment writes the segment to path through a temporary file, so a crash never leaves a partial segment.
+func writeSegment(path string, segment walSegment) (int64, error) {
+	data, err := json.Marshal(segment)
+	if err != nil {
+		return 0, err
+	}
+	tmp := path + walTmpExt
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// append adds the payloads as a new segment. Payloads without a timestamp get the current time, so a replay
+// does not move them. It fails when the buffer would exceed maxBytes.
+func (b *walBuffer) append(payloads []PrometheusPayload) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+	now := b.now()
+	segment := walSegment{CreatedMs: now.UnixMilli(), Payloads: make([]PrometheusPayload, 0, len(payloads))}
+	for _, payload := range payloads {
+		if payload.TimestampMs == 0 {
+			payload.TimestampMs = now.UnixMilli()
+		}
+		segment.Payloads = append(segment.Payloads, payload)
+	}
+	data, err := json.Marshal(segment)
+	if err != nil {
+		return err
+	}
+	if b.size+int64(len(data)) > b.maxBytes {
+		return fmt.Errorf("write-ahead buffer is full, %d of %d bytes used", b.size, b.maxBytes)
+	}
+	path := filepath.Join(b.dir, fmt.Sprintf("%020d%s", b.nextSeq, walSegmentExt))
+	size, err := writeSegment(path, segment)
+	if err != nil {
+		return fmt.Errorf("failed to write the write-ahead buffer: %w", err)
+	}
+	b.nextSeq++
+	b.entries = append(b.entries, walEntry{path: path, createdMs: segment.CreatedMs, payloads: len(segment.Payloads), size: size})
+	b.size += size
+	b.logger.Infow("Buffered metrics while the backend is unavailable", zap.Int("metrics", len(segment.Payloads)), zap.Int("segments", len(b.entries)))
+	return nil
+}
+
+// replay writes the segments in order and reports whether the buffer is empty. It stops at the first segment with
+// payloads failing because the backend is unavailable, keeping only these payloads in the segment. Payloads the
+// backend rejects are dropped, as their datums were already acknowledged. The lock is not held while a segment is
+// written, so flush keeps buffering behind it, and a single replay runs at a time. It fails when the segment cannot
+// be rewritten, its payloads already written are then written again by the next replay.
+func (b *walBuffer) replay(ctx context.Context, write func(context.Context, []PrometheusPayload) error) (bool, error) {
+	b.replayMu.Lock()
+	defer b.replayMu.Unlock()
+	for {
+		b.mu.Lock()
+		if len(b.entries) == 0 {
+			b.updateMetrics()
+			b.mu.Unlock()
+			return true, nil
+		}
+		// Only replay removes or rewrites the first segment, the entry stays valid without the lock
+		entry := b.entries[0]
+		b.mu.Unlock()
+		segment, _, err := readWALSegment(entry.path)
+		if err != nil {
+			b.logger.Errorw("Dropping unreadable write-ahead buffer segment", zap.String("path", entry.path), zap.Error(err))
+			b.metrics.IncreaseBufferDropped(entry.payloads)
+			_ = os.Rename(entry.path, entry.path+walCorruptExt)
+			b.remove()
+			continue
+		}
+		for i := range segment.Payloads {
+			segment.Payloads[i].datum = i
+		}
+		var pending []PrometheusPayload
+		if err := write(ctx, segment.Payloads); err != nil {
+			failed := failedDatums(err, segment.Payloads)
+			dropped := 0
+			for i, payload := range segment.Payloads {
+				if err, ok := failed[i]; ok {
+					if isUnavailable(err) || ctx.Err() != nil {
+						pending = append(pending, payload)
+						continue
+					}
+					b.logger.Errorw("Dropping buffered metric rejected by the backend", zap.Any("payload", payload), zap.Error(err))
+					dropped++
+				}
+			}
+			b.metrics.IncreaseBufferDropped(dropped)
+		}
+		if len(pending) == 0 {
+			_ = os.Remove(entry.path)
+			b.remove()
+			b.logger.Infow("Replayed write-ahead buffer segment", zap.Int("metrics", len(segment.Payloads)))
+			continue
+		}
+		if len(pending) == len(segment.Payloads) {
+			b.mu.Lock()
+			b.updateMetrics()
+			b.mu.Unlock()
+			return false, nil
+		}
+		written := len(segment.Payloads) - len(pending)
+		segment.Payloads = pending
+		size, err := writeSegment(entry.path, segment)
+		if err != nil {
+			b.logger.Errorw("Failed to rewrite the partly replayed write-ahead buffer segment, its written metrics will be replayed again",
+				zap.String("path", entry.path), zap.Int("metrics", written), zap.Error(err))
+			return false, fmt.Errorf("failed to rewrite the write-ahead buffer segment %s: %w", entry.path, err)
+		}
+		b.mu.Lock()
+		b.size += size - entry.size
+		b.entries[0].payloads, b.entries[0].size = len(pending), size
+		b.updateMetrics()
+		b.mu.Unlock()
+		return false, nil
+	}
+}
+
+// remove forgets the first segment.
+func (b *walBuffer) remove() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.size -= b.entries[0].size
+	b.entries = b.entries[1:]
+	b.updateMetrics()
+}
+
+// flush writes the payloads, or buffers them behind the pending segments without writing them, leaving the replay
+// to start. Payloads failing because the backend is unavailable are buffered and their datums succeed, the error
+// only holds the datums that still fail. Like replay, the lock is not held while the payloads are written, and a
+// single flush runs at a time so the payloads are buffered in order.
+func (b *walBuffer) flush(ctx context.Context, payloads []PrometheusPayload, write func(context.Context, []PrometheusPayload) error) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	if len(b.entries) > 0 {
+		defer b.mu.Unlock()
+		defer b.updateMetrics()
+		return b.append(payloads)
+	}
+	b.mu.Unlock()
+	err := write(ctx, payloads)
+	if err == nil {
+		return nil
+	}
+	failed := failedDatums(err, payloads)
+	unavailable := make(map[int]bool)
+	for datum, err := range failed {
+		if isUnavailable(err) {
+			unavailable[datum] = true
+		}
+	}
+	var pending []PrometheusPayload
+	for _, payload := range payloads {
+		for _, datum := range payload.datums() {
+			if unavailable[datum] {
+				pending = append(pending, payload)
+				break
+			}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.updateMetrics()
+	if err := b.append(pending); err != nil {
+		b.logger.Errorw("Failed to buffer metrics", zap.Error(err))
+		return &writeError{err: err, datums: failed}
+	}
+	for datum := range unavailable {
+		delete(failed, datum)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &writeError{err: err, datums: failed}
+}
+
+// start replays the buffer every interval until the context is done. It is the only caller of replay outside tests.
+func (b *walBuffer) start(ctx context.Context, interval time.Duration, write func(context.Context, []PrometheusPayload) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// replay logs its errors, the next tick retries
+			_, _ = b.replay(ctx, write)
+		}
+	}
+}
+
+// updateMetrics sets the depth and oldest entry age gauges.
+func (b *walBuffer) updateMetrics() {
+	depth := 0
+	for _, entry := range b.entries {
+		depth += entry.payloads
+	}
+	var age time.Duration
+	if len(b.entries) > 0 {
+		age = b.now().Sub(time.UnixMilli(b.entries[0].createdMs))
+	}
+	b.metrics.SetBufferState(depth, age)
+}
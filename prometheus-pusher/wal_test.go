@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/numaproj/numaflow/pkg/shared/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBackend records the payloads written, failing the names in unavailable or rejected.
+type fakeBackend struct {
+	written     []string
+	unavailable map[string]bool
+	rejected    map[string]bool
+}
+
+func (fb *fakeBackend) write(ctx context.Context, payloads []PrometheusPayload) error {
+	werr := &writeError{}
+	for _, payload := range payloads {
+		switch {
+		case fb.unavailable[payload.Name]:
+			werr.fail(payload.datum, &unavailableError{err: errors.New("backend returned status code 503")})
+		case fb.rejected[payload.Name]:
+			werr.fail(payload.datum, errors.New("backend returned status code 400"))
+		default:
+			fb.written = append(fb.written, payload.Name)
+		}
+	}
+	if len(werr.datums) > 0 {
+		werr.err = errors.New("write failed")
+		return werr
+	}
+	return nil
+}
+
+func newTestWALBuffer(t *testing.T, dir string, maxBytes int64, prefix string) *walBuffer {
+	b, err := openWALBuffer(logging.NewLogger().Named("buffer"), dir, maxBytes, NewMetricsServer(nil, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.now = func() time.Time { return time.UnixMilli(1680124991883) }
+	return b
+}
+
+func TestWALBuffer_Flush(t *testing.T) {
+	dir := t.TempDir()
+	b := newTestWALBuffer(t, dir, 1<<20, "test_wal_flush")
+	backend := &fakeBackend{unavailable: map[string]bool{"a": true, "b": true}}
+
+	// Unavailable payloads are buffered and their datums succeed, rejected ones still fail
+	backend.rejected = map[string]bool{"c": true}
+	err := b.flush(context.Background(), []PrometheusPayload{{Name: "a", datum: 0}, {Name: "b", datum: 1}, {Name: "c", datum: 2}, {Name: "d", datum: 3}}, backend.write)
+	var werr *writeError
+	if assert.ErrorAs(t, err, &werr) {
+		assert.Len(t, werr.datums, 1)
+		assert.Contains(t, werr.datums, 2)
+	}
+	assert.Equal(t, []string{"d"}, backend.written)
+	assert.Len(t, b.entries, 1)
+	assert.Equal(t, float64(2), testutil.ToFloat64(b.metrics.bufferDepth))
+
+	// While the buffer cannot be replayed, new payloads are buffered behind it
+	b.now = func() time.Time { return time.UnixMilli(1680124991883 + 30000) }
+	assert.NoError(t, b.flush(context.Background(), []PrometheusPayload{{Name: "e", datum: 0}}, backend.write))
+	assert.Equal(t, []string{"d"}, backend.written)
+	assert.Len(t, b.entries, 2)
+	assert.Equal(t, float64(3), testutil.ToFloat64(b.metrics.bufferDepth))
+	assert.Equal(t, float64(30), testutil.ToFloat64(b.metrics.bufferOldestAge))
+
+	// Flush never replays, even once the backend recovers the new payloads wait for the background replay
+	backend.unavailable = nil
+	assert.NoError(t, b.flush(context.Background(), []PrometheusPayload{{Name: "f", datum: 0}}, backend.write))
+	assert.Equal(t, []string{"d"}, backend.written)
+	assert.Len(t, b.entries, 3)
+
+	// The replay writes the buffer in order
+	empty, err := b.replay(context.Background(), backend.write)
+	assert.NoError(t, err)
+	assert.True(t, empty)
+	assert.Equal(t, []string{"d", "a", "b", "e", "f"}, backend.written)
+	assert.Empty(t, b.entries)
+	assert.Zero(t, b.size)
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.metrics.bufferDepth))
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.metrics.bufferOldestAge))
+	files, _ := os.ReadDir(dir)
+	assert.Empty(t, files)
+}
+
+func TestWALBuffer_Replay(t *testing.T) {
+	b := newTestWALBuffer(t, t.TempDir(), 1<<20, "test_wal_replay")
+	backend := &fakeBackend{unavailable: map[string]bool{"a": true, "b": true, "c": true}}
+	assert.NoError(t, b.flush(context.Background(), []PrometheusPayload{{Name: "a", datum: 0}, {Name: "b", datum: 0}, {Name: "c", datum: 1}}, backend.write))
+
+	// The rejected payload is dropped and the segment only keeps the unavailable one
+	backend.unavailable = map[string]bool{"c": true}
+	backend.rejected = map[string]bool{"b": true}
+	empty, err := b.replay(context.Background(), backend.write)
+	assert.NoError(t, err)
+	assert.False(t, empty)
+	assert.Equal(t, []string{"a"}, backend.written)
+	assert.Equal(t, 1, b.entries[0].payloads)
+	assert.Equal(t, float64(1), testutil.ToFloat64(b.metrics.bufferTotalDropped))
+	segment, size, err := readWALSegment(b.entries[0].path)
+	assert.NoError(t, err)
+	assert.Equal(t, b.size, size)
+	// Payloads are buffered with the time they were received
+	assert.Equal(t, []PrometheusPayload{{Name: "c", TimestampMs: 1680124991883}}, segment.Payloads)
+
+	backend.unavailable = nil
+	empty, err = b.replay(context.Background(), backend.write)
+	assert.NoError(t, err)
+	assert.True(t, empty)
+	assert.Equal(t, []string{"a", "c"}, backend.written)
+}
+
+func TestWALBuffer_RewriteFailure(t *testing.T) {
+	b := newTestWALBuffer(t, t.TempDir(), 1<<20, "test_wal_rewrite_failure")
+	backend := &fakeBackend{unavailable: map[string]bool{"a": true, "b": true}}
+	assert.NoError(t, b.flush(context.Background(), []PrometheusPayload{{Name: "a", datum: 0}, {Name: "b", datum: 1}}, backend.write))
+
+	// The partly replayed segment cannot be rewritten, the replay fails and keeps the whole segment
+	assert.NoError(t, os.Mkdir(b.entries[0].path+walTmpExt, 0o755))
+	backend.unavailable = map[string]bool{"b": true}
+	empty, err := b.replay(context.Background(), backend.write)
+	assert.ErrorContains(t, err, "failed to rewrite the write-ahead buffer segment")
+	assert.False(t, empty)
+	assert.Equal(t, 2, b.entries[0].payloads)
+	segment, _, err := readWALSegment(b.entries[0].path)
+	assert.NoError(t, err)
+	assert.Len(t, segment.Payloads, 2)
+}
+
+func TestWALBuffer_FlushUnlocked(t *testing.T) {
+	b := newTestWALBuffer(t, t.TempDir(), 1<<20, "test_wal_flush_unlocked")
+	backend := &fakeBackend{unavailable: map[string]bool{"a": true}}
+	// The entries stay available to the replay while the payloads are written
+	write := func(ctx context.Context, payloads []PrometheusPayload) error {
+		if assert.True(t, b.mu.TryLock()) {
+			b.mu.Unlock()
+		}
+		return backend.write(ctx, payloads)
+	}
+	assert.NoError(t, b.flush(context.Background(), []PrometheusPayload{{Name: "a", datum: 0}, {Name: "b", datum: 1}}, write))
+	assert.Equal(t, []string{"b"}, backend.written)
+	assert.Len(t, b.entries, 1)
+}
+
+func TestWALBuffer_Full(t *testing.T) {
+	b := newTestWALBuffer(t, t.TempDir(), 200, "test_wal_full")
+	backend := &fakeBackend{unavailable: map[string]bool{"a": true, "b": true}}
+	assert.NoError(t, b.flush(context.Background(), []PrometheusPayload{{Name: "a", datum: 0}}, backend.write))
+
+	// Once full the datums fail as without a buffer
+	payloads := []PrometheusPayload{{Name: "b", Labels: map[string]string{"long": string(make([]byte, 200))}, datum: 0}, {Name: "c", datum: 1}}
+	err := b.flush(context.Background(), payloads, backend.write)
+	assert.ErrorContains(t, err, "write-ahead buffer is full")
+	assert.Len(t, failedDatums(err, payloads), 2)
+	assert.Len(t, b.entries, 1)
+}
+
+func TestWALBuffer_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	b := newTestWALBuffer(t, dir, 1<<20, "test_wal_reopen")
+	backend := &fakeBackend{unavailable: map[string]bool{"a": true, "b": true}}
+	assert.NoError(t, b.flush(context.Background(), []PrometheusPayload{{Name: "a", datum: 0}}, backend.write))
+	assert.NoError(t, b.flush(context.Background(), []PrometheusPayload{{Name: "b", datum: 0}}, backend.write))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000002.json.tmp"), []byte("{"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000003.json"), []byte("{"), 0600))
+
+	reopened := newTestWALBuffer(t, dir, 1<<20, "test_wal_reopened")
+	assert.Len(t, reopened.entries, 2)
+	assert.Equal(t, b.size, reopened.size)
+	assert.Equal(t, uint64(2), reopened.nextSeq)
+	assert.Equal(t, float64(2), testutil.ToFloat64(reopened.metrics.bufferDepth))
+	assert.NoFileExists(t, filepath.Join(dir, "00000000000000000002.json.tmp"))
+	assert.FileExists(t, filepath.Join(dir, "00000000000000000003.json.corrupt"))
+
+	backend.unavailable = nil
+	empty, err := reopened.replay(context.Background(), backend.write)
+	assert.NoError(t, err)
+	assert.True(t, empty)
+	assert.Equal(t, []string{"a", "b"}, backend.written)
+
+	_, err = openWALBuffer(logging.NewLogger(), dir, 0, reopened.metrics)
+	assert.ErrorContains(t, err, "must be positive")
+}
+
+func TestSink_Buffer(t *testing.T) {
+	pgw, _ := newFakePushgateway(t)
+	pgw.fail = "/metrics/job/ns_none_score"
+	ps := &prometheusSink{logger: logging.NewLogger().Named("prometheus-sink"), ignoreMetricsTs: true, pushConcurrency: 1,
+		pushMaxRetries: 1, pushMinBackoff: time.Millisecond, pushMaxBackoff: time.Millisecond}
+	ps.metrics = NewMetricsServer(nil, "test_sink_buffer")
+	var err error
+	ps.buffer, err = openWALBuffer(ps.logger, t.TempDir(), 1<<20, ps.metrics)
+	assert.NoError(t, err)
+
+	results := sinkDatums(ps, testDatum{id: "1", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":1}`})
+	assert.True(t, results["1"].Success)
+	assert.Empty(t, pgw.requests["/metrics/job/ns_none_score"])
+	assert.Len(t, ps.buffer.entries, 1)
+
+	// Recovered or not, the next batch is buffered behind the first one without a request
+	pgw.fail = ""
+	results = sinkDatums(ps, testDatum{id: "2", value: `{"name":"score","namespace":"ns","subsystem":"none","type":"Gauge","value":2}`})
+	assert.True(t, results["2"].Success)
+	assert.Empty(t, pgw.requests["/metrics/job/ns_none_score"])
+	assert.Len(t, ps.buffer.entries, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ps.buffer.start(ctx, time.Millisecond, ps.write)
+	assert.Eventually(t, func() bool {
+		ps.buffer.mu.Lock()
+		defer ps.buffer.mu.Unlock()
+		return len(ps.buffer.entries) == 0
+	}, time.Second, time.Millisecond)
+	if pushed := pgw.requests["/metrics/job/ns_none_score"]; assert.Len(t, pushed, 2) {
+		assert.Equal(t, float64(1), pushed[0].GetMetric()[0].GetGauge().GetValue())
+		assert.Equal(t, float64(2), pushed[1].GetMetric()[0].GetGauge().GetValue())
+	}
+}